@@ -0,0 +1,360 @@
+package fcgx
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNoBackends is returned when a Balancer has no backends to route to.
+var ErrNoBackends = errors.New("fcgx: no backends")
+
+// Backend is a FastCGI server a Balancer can route requests to.
+type Backend struct {
+	Network string // "tcp" or "unix"
+	Address string // Server address or socket path
+	Weight  int    // Relative share of requests; values below 1 count as 1
+}
+
+func (b Backend) String() string {
+	return b.Network + ":" + b.Address
+}
+
+// BalancePolicy selects how a Balancer picks a backend for each request.
+type BalancePolicy int
+
+const (
+	// RoundRobin spreads requests over backends in proportion to their weight
+	RoundRobin BalancePolicy = iota
+	// LeastOutstanding picks the backend with the fewest in-flight requests
+	// relative to its weight
+	LeastOutstanding
+	// ConsistentHash routes requests with the same HashParam value to the
+	// same backend while it is healthy
+	ConsistentHash
+)
+
+// ringReplicas is the number of points per unit of weight on the hash ring.
+const ringReplicas = 64
+
+// BalancerConfig holds configuration options for a Balancer.
+type BalancerConfig struct {
+	// Policy selects how backends are picked.
+	// Default: RoundRobin
+	Policy BalancePolicy
+
+	// HashParam names the request param hashed by ConsistentHash, for example
+	// a session cookie forwarded as HTTP_COOKIE. Requests without the param
+	// fall back to round-robin.
+	HashParam string
+
+	// EjectDuration is how long a backend is skipped after a connect error or
+	// an FCGI_OVERLOADED response.
+	// Default: 10 seconds
+	EjectDuration time.Duration
+
+	// ClientConfig configures the connections made to each backend.
+	// Default: DefaultConfig()
+	ClientConfig *Config
+}
+
+// DefaultBalancerConfig returns a BalancerConfig with sensible defaults
+func DefaultBalancerConfig() *BalancerConfig {
+	return &BalancerConfig{
+		Policy:        RoundRobin,
+		EjectDuration: 10 * time.Second,
+		ClientConfig:  DefaultConfig(),
+	}
+}
+
+// BackendStats is a point-in-time view of a backend's state in a Balancer.
+type BackendStats struct {
+	Backend
+	Outstanding  int       // Requests currently in flight
+	Requests     uint64    // Requests routed to the backend
+	Failures     uint64    // Requests that returned an error
+	Ejected      bool      // Whether the backend is currently skipped
+	EjectedUntil time.Time // When the current or last ejection ends
+}
+
+// backendState tracks a backend's load and health inside a Balancer.
+type backendState struct {
+	Backend
+	current      int // Smooth weighted round-robin counter
+	outstanding  int
+	requests     uint64
+	failures     uint64
+	ejectedUntil time.Time
+}
+
+// ringPoint is a position on the consistent hash ring.
+type ringPoint struct {
+	hash    uint64
+	backend int
+}
+
+// Balancer routes FastCGI requests across several backends, such as multiple
+// PHP-FPM pools or hosts. Each request uses its own connection.
+// Backends are ejected for EjectDuration after a connect error or an
+// FCGI_OVERLOADED response, and such requests fail over to the next backend
+// because the failed backend never processed them. Backends whose circuit is
+// open in ClientConfig.CircuitBreakers are skipped the same way. When every
+// backend is ejected, requests are still routed rather than failing outright.
+// All methods are thread-safe and can be called concurrently.
+type Balancer struct {
+	config       *BalancerConfig
+	clientConfig *Config // ClientConfig without Retry; retries wrap whole failovers
+
+	mu       sync.Mutex
+	backends []*backendState
+	ring     []ringPoint
+}
+
+// NewBalancer creates a Balancer for the given backends.
+func NewBalancer(backends []Backend, config *BalancerConfig) (*Balancer, error) {
+	if len(backends) == 0 {
+		return nil, ErrNoBackends
+	}
+	if config == nil {
+		config = DefaultBalancerConfig()
+	}
+	c := *config
+	if c.ClientConfig == nil {
+		c.ClientConfig = DefaultConfig()
+	}
+	if c.EjectDuration <= 0 {
+		c.EjectDuration = 10 * time.Second
+	}
+
+	clientConfig := *c.ClientConfig
+	clientConfig.Retry = nil
+
+	b := &Balancer{config: &c, clientConfig: &clientConfig}
+	for i, be := range backends {
+		if be.Weight < 1 {
+			be.Weight = 1
+		}
+		b.backends = append(b.backends, &backendState{Backend: be})
+		for r := 0; r < be.Weight*ringReplicas; r++ {
+			b.ring = append(b.ring, ringPoint{hash: hashKey(fmt.Sprintf("%s#%d", be, r)), backend: i})
+		}
+	}
+	sort.Slice(b.ring, func(i, j int) bool { return b.ring[i].hash < b.ring[j].hash })
+	return b, nil
+}
+
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// Stats returns the current state of every backend, in configuration order.
+func (b *Balancer) Stats() []BackendStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	stats := make([]BackendStats, len(b.backends))
+	for i, be := range b.backends {
+		stats[i] = BackendStats{
+			Backend:      be.Backend,
+			Outstanding:  be.outstanding,
+			Requests:     be.requests,
+			Failures:     be.failures,
+			Ejected:      now.Before(be.ejectedUntil),
+			EjectedUntil: be.ejectedUntil,
+		}
+	}
+	return stats
+}
+
+// DoRequest routes a request to a backend picked by the configured policy.
+// When ClientConfig.Retry is set, each retry starts a new failover round.
+func (b *Balancer) DoRequest(ctx context.Context, params map[string]string, body io.Reader) (*http.Response, error) {
+	// Buffer the body so it can be replayed on failover and retries
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = io.ReadAll(body); err != nil {
+			return nil, wrap(err, ErrRead, "reading request body")
+		}
+	}
+
+	return withRetry(ctx, b.config.ClientConfig.Retry, params["REQUEST_METHOD"], func(int) (*http.Response, error) {
+		return b.failover(ctx, params, body != nil, data)
+	})
+}
+
+// failover tries backends in policy order until one accepts the request.
+func (b *Balancer) failover(ctx context.Context, params map[string]string, hasBody bool, data []byte) (*http.Response, error) {
+	tried := make([]bool, len(b.backends))
+	var lastErr error
+	for range b.backends {
+		idx := b.pick(params, tried)
+		tried[idx] = true
+		be := b.backends[idx].Backend
+
+		var reqBody io.Reader
+		if hasBody {
+			reqBody = bytes.NewReader(data)
+		}
+		resp, err := b.do(ctx, be, params, reqBody)
+		if err == nil {
+			b.release(idx, false, false)
+			return resp, nil
+		}
+
+		// A cancelled caller says nothing about the backend's health. An open
+		// circuit already keeps the backend out of rotation.
+		rejected := errors.Is(err, ErrConnect) || errors.Is(err, ErrOverloaded)
+		b.release(idx, true, rejected && ctx.Err() == nil)
+		if !(rejected || errors.Is(err, ErrCircuitOpen)) || ctx.Err() != nil {
+			return nil, err
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// Get performs a GET request on a backend picked by the configured policy.
+func (b *Balancer) Get(ctx context.Context, params map[string]string) (*http.Response, error) {
+	prepareGet(params)
+	return b.DoRequest(ctx, params, nil)
+}
+
+// Post performs a POST request on a backend picked by the configured policy.
+func (b *Balancer) Post(ctx context.Context, params map[string]string, body io.Reader, contentLength int) (*http.Response, error) {
+	return b.DoRequest(ctx, params, preparePost(params, body, contentLength))
+}
+
+// do performs a single request on its own connection to be.
+func (b *Balancer) do(ctx context.Context, be Backend, params map[string]string, body io.Reader) (*http.Response, error) {
+	client, err := DialContextWithConfig(ctx, be.Network, be.Address, b.clientConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	return client.DoRequest(ctx, params, body)
+}
+
+// pick selects an untried backend and counts the request as outstanding.
+// Ejected backends are only picked when no healthy untried backend remains.
+func (b *Balancer) pick(params map[string]string, tried []bool) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	cb := b.clientConfig.CircuitBreakers
+	eligible := make([]bool, len(b.backends))
+	healthy := false
+	for i, be := range b.backends {
+		eligible[i] = !tried[i] && !now.Before(be.ejectedUntil)
+		if eligible[i] && cb != nil {
+			eligible[i] = cb.State(be.Network, be.Address) != CircuitOpen
+		}
+		healthy = healthy || eligible[i]
+	}
+	if !healthy {
+		for i := range b.backends {
+			eligible[i] = !tried[i]
+		}
+	}
+
+	idx := -1
+	switch b.config.Policy {
+	case LeastOutstanding:
+		idx = b.pickLeastOutstanding(eligible)
+	case ConsistentHash:
+		if key, ok := params[b.config.HashParam]; ok && key != "" {
+			idx = b.pickHash(key, eligible)
+		}
+	}
+	if idx < 0 {
+		idx = b.pickRoundRobin(eligible)
+	}
+
+	b.backends[idx].outstanding++
+	return idx
+}
+
+// pickRoundRobin implements smooth weighted round-robin over eligible backends.
+func (b *Balancer) pickRoundRobin(eligible []bool) int {
+	best, total := -1, 0
+	for i, be := range b.backends {
+		if !eligible[i] {
+			continue
+		}
+		be.current += be.Weight
+		total += be.Weight
+		if best < 0 || be.current > b.backends[best].current {
+			best = i
+		}
+	}
+	b.backends[best].current -= total
+	return best
+}
+
+// pickLeastOutstanding picks the eligible backend with the lowest
+// outstanding/weight ratio, breaking ties with round-robin.
+func (b *Balancer) pickLeastOutstanding(eligible []bool) int {
+	best := -1
+	for i, be := range b.backends {
+		if !eligible[i] {
+			continue
+		}
+		if best < 0 {
+			best = i
+			continue
+		}
+		other := b.backends[best]
+		if be.outstanding*other.Weight < other.outstanding*be.Weight {
+			best = i
+		}
+	}
+
+	// Break ties between equally loaded backends with round-robin
+	tied := make([]bool, len(b.backends))
+	least := b.backends[best]
+	for i, be := range b.backends {
+		tied[i] = eligible[i] && be.outstanding*least.Weight == least.outstanding*be.Weight
+	}
+	return b.pickRoundRobin(tied)
+}
+
+// pickHash walks the ring from key's position to the first eligible backend.
+func (b *Balancer) pickHash(key string, eligible []bool) int {
+	h := hashKey(key)
+	start := sort.Search(len(b.ring), func(i int) bool { return b.ring[i].hash >= h })
+	for i := 0; i < len(b.ring); i++ {
+		p := b.ring[(start+i)%len(b.ring)]
+		if eligible[p.backend] {
+			return p.backend
+		}
+	}
+	return -1
+}
+
+// release records the outcome of a request and ejects the backend when it
+// could not be reached or reported overload.
+func (b *Balancer) release(idx int, failed, eject bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	be := b.backends[idx]
+	be.outstanding--
+	be.requests++
+	if failed {
+		be.failures++
+	}
+	if eject {
+		be.ejectedUntil = time.Now().Add(b.config.EjectDuration)
+	}
+}
@@ -0,0 +1,194 @@
+package fcgx
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/gophpeek/fcgx/internal/fcgitest"
+	"github.com/gophpeek/fcgx/protocol"
+)
+
+func balancerGet(t *testing.T, b *Balancer, params map[string]string) error {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	resp, err := b.Get(ctx, params)
+	if err != nil {
+		return err
+	}
+	_, err = ReadBody(resp)
+	return err
+}
+
+func TestNewBalancer(t *testing.T) {
+	if _, err := NewBalancer(nil, nil); !errors.Is(err, ErrNoBackends) {
+		t.Errorf("Expected ErrNoBackends, got %v", err)
+	}
+
+	// Defaults are applied to a copy of the config
+	config := &BalancerConfig{}
+	if _, err := NewBalancer([]Backend{{Network: "tcp", Address: "127.0.0.1:9000"}}, config); err != nil {
+		t.Fatalf("NewBalancer failed: %v", err)
+	}
+	if config.ClientConfig != nil || config.EjectDuration != 0 {
+		t.Errorf("Expected the caller's config to be left alone, got %+v", config)
+	}
+}
+
+func TestBalancerRoundRobin(t *testing.T) {
+	ok := fcgitest.RespondWith("Content-Type: text/plain\r\n\r\nok")
+	a, b := fcgitest.NewServer(t, ok), fcgitest.NewServer(t, ok)
+
+	bal, err := NewBalancer([]Backend{
+		{Network: "tcp", Address: a.Addr(), Weight: 3},
+		{Network: "tcp", Address: b.Addr(), Weight: 1},
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewBalancer failed: %v", err)
+	}
+
+	for i := 0; i < 8; i++ {
+		if err := balancerGet(t, bal, testParams()); err != nil {
+			t.Fatalf("Request %d failed: %v", i, err)
+		}
+	}
+	if a.Connections() != 6 || b.Connections() != 2 {
+		t.Errorf("Expected 6/2 split, got %d/%d", a.Connections(), b.Connections())
+	}
+
+	stats := bal.Stats()
+	if stats[0].Requests != 6 || stats[1].Requests != 2 || stats[0].Outstanding != 0 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestBalancerLeastOutstanding(t *testing.T) {
+	release := make(chan struct{})
+	slow := fcgitest.NewServer(t, func(w io.Writer, req *fcgitest.Request) {
+		<-release
+		fcgitest.RespondWith("Content-Type: text/plain\r\n\r\nok")(w, req)
+	})
+	fast := fcgitest.NewServer(t, fcgitest.RespondWith("Content-Type: text/plain\r\n\r\nok"))
+
+	config := DefaultBalancerConfig()
+	config.Policy = LeastOutstanding
+	bal, err := NewBalancer([]Backend{
+		{Network: "tcp", Address: slow.Addr()},
+		{Network: "tcp", Address: fast.Addr()},
+	}, config)
+	if err != nil {
+		t.Fatalf("NewBalancer failed: %v", err)
+	}
+
+	// The first request ties and goes to the slow backend, which then holds it
+	done := make(chan error, 1)
+	go func() { done <- balancerGet(t, bal, testParams()) }()
+	for bal.Stats()[0].Outstanding == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := balancerGet(t, bal, testParams()); err != nil {
+			t.Fatalf("Request %d failed: %v", i, err)
+		}
+	}
+	if fast.Connections() != 3 {
+		t.Errorf("Expected 3 requests on the idle backend, got %d", fast.Connections())
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("Slow request failed: %v", err)
+	}
+}
+
+func TestBalancerConsistentHash(t *testing.T) {
+	ok := fcgitest.RespondWith("Content-Type: text/plain\r\n\r\nok")
+	servers := []*fcgitest.Server{fcgitest.NewServer(t, ok), fcgitest.NewServer(t, ok), fcgitest.NewServer(t, ok)}
+	var backends []Backend
+	for _, s := range servers {
+		backends = append(backends, Backend{Network: "tcp", Address: s.Addr()})
+	}
+
+	config := DefaultBalancerConfig()
+	config.Policy = ConsistentHash
+	config.HashParam = "HTTP_COOKIE"
+	bal, err := NewBalancer(backends, config)
+	if err != nil {
+		t.Fatalf("NewBalancer failed: %v", err)
+	}
+
+	params := testParams()
+	params["HTTP_COOKIE"] = "PHPSESSID=abc123"
+	for i := 0; i < 5; i++ {
+		if err := balancerGet(t, bal, params); err != nil {
+			t.Fatalf("Request %d failed: %v", i, err)
+		}
+	}
+
+	used := 0
+	for _, s := range servers {
+		if s.Connections() > 0 {
+			used++
+			if s.Connections() != 5 {
+				t.Errorf("Expected all 5 requests on one backend, got %d", s.Connections())
+			}
+		}
+	}
+	if used != 1 {
+		t.Errorf("Expected a single backend to be used, got %d", used)
+	}
+}
+
+func TestBalancerEjection(t *testing.T) {
+	t.Run("ConnectError", func(t *testing.T) {
+		live := fcgitest.NewServer(t, fcgitest.RespondWith("Content-Type: text/plain\r\n\r\nok"))
+		bal, err := NewBalancer([]Backend{
+			{Network: "tcp", Address: fcgitest.DeadAddr(t)},
+			{Network: "tcp", Address: live.Addr()},
+		}, nil)
+		if err != nil {
+			t.Fatalf("NewBalancer failed: %v", err)
+		}
+
+		for i := 0; i < 4; i++ {
+			if err := balancerGet(t, bal, testParams()); err != nil {
+				t.Fatalf("Request %d failed over unsuccessfully: %v", i, err)
+			}
+		}
+		stats := bal.Stats()
+		if !stats[0].Ejected || stats[0].Requests != 1 || stats[0].Failures != 1 {
+			t.Errorf("Expected dead backend ejected after one attempt, got %+v", stats[0])
+		}
+		if live.Connections() != 4 {
+			t.Errorf("Expected 4 requests on the live backend, got %d", live.Connections())
+		}
+	})
+
+	t.Run("Overloaded", func(t *testing.T) {
+		overloaded := fcgitest.NewServer(t, func(w io.Writer, req *fcgitest.Request) {
+			fcgitest.WriteEndRequest(w, req.ID, 0, protocol.StatusOverloaded)
+		})
+		bal, err := NewBalancer([]Backend{{Network: "tcp", Address: overloaded.Addr()}}, nil)
+		if err != nil {
+			t.Fatalf("NewBalancer failed: %v", err)
+		}
+
+		if err := balancerGet(t, bal, testParams()); !errors.Is(err, ErrOverloaded) {
+			t.Fatalf("Expected ErrOverloaded, got %v", err)
+		}
+		if !bal.Stats()[0].Ejected {
+			t.Error("Expected overloaded backend to be ejected")
+		}
+		// With every backend ejected, requests are still routed
+		if err := balancerGet(t, bal, testParams()); !errors.Is(err, ErrOverloaded) {
+			t.Fatalf("Expected ErrOverloaded, got %v", err)
+		}
+		if overloaded.Connections() != 2 {
+			t.Errorf("Expected 2 connections, got %d", overloaded.Connections())
+		}
+	})
+}
@@ -0,0 +1,142 @@
+package fcgx
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/gophpeek/fcgx/protocol"
+)
+
+// benchServer answers every request on a keep-alive connection with a fixed,
+// pre-encoded response without allocating, so allocations reported by the
+// benchmarks belong to the client.
+func benchServer(tb testing.TB, body string) string {
+	tb.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("listen: %v", err)
+	}
+	tb.Cleanup(func() { ln.Close() })
+
+	var resp bytes.Buffer
+	w := protocol.NewRecordWriter(&resp)
+	_ = w.WriteRecord(protocol.TypeStdout, 1, []byte(body))
+	_ = w.WriteRecord(protocol.TypeStdout, 1, nil)
+	_ = w.WriteEndRequest(1, protocol.EndRequestBody{})
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := protocol.NewRecordReader(conn)
+		for {
+			rec, err := r.ReadRecord()
+			if err != nil {
+				return
+			}
+			if rec.Type == protocol.TypeBeginRequest {
+				setRequestID(resp.Bytes(), rec.RequestID)
+			}
+			if rec.Type == protocol.TypeStdin && len(rec.Content) == 0 {
+				if _, err := conn.Write(resp.Bytes()); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// setRequestID rewrites the request ID of every record in the encoded stream b.
+func setRequestID(b []byte, id uint16) {
+	for len(b) >= protocol.HeaderLen {
+		h, _ := protocol.DecodeHeader(b)
+		h.RequestID = id
+		h.Encode(b)
+		b = b[protocol.HeaderLen+int(h.ContentLength)+int(h.PaddingLength):]
+	}
+}
+
+// TestDoRequestAllocs guards the allocations of a request on a kept
+// connection, including one with a body that spans several STDIN records.
+func TestDoRequestAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocation counts are unreliable with the race detector")
+	}
+	addr := benchServer(t, "Content-Type: application/json\r\n\r\n{\"pool\":\"www\",\"active processes\":1}")
+	config := DefaultConfig()
+	config.KeepConn = true
+	config.MaxWriteSize = 1000
+	client, err := DialWithConfig("tcp", addr, config)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close()
+
+	params := map[string]string{
+		"SCRIPT_FILENAME": "/status",
+		"SCRIPT_NAME":     "/status",
+		"QUERY_STRING":    "json",
+		"REQUEST_METHOD":  "GET",
+	}
+	payload := make([]byte, 4500)
+	body := bytes.NewReader(payload)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		body func() io.Reader
+		max  float64
+	}{
+		{"Get", func() io.Reader { return nil }, 12},
+		{"Post", func() io.Reader { body.Reset(payload); return body }, 12},
+	}
+	for _, tt := range tests {
+		do := func() {
+			resp, err := client.DoRequest(ctx, params, tt.body())
+			if err != nil {
+				t.Fatalf("DoRequest failed: %v", err)
+			}
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		do() // warm up pools and reusable buffers
+		if n := testing.AllocsPerRun(100, do); n > tt.max {
+			t.Errorf("%s: expected at most %v allocations, got %v", tt.name, tt.max, n)
+		}
+	}
+}
+
+func BenchmarkDoRequest(b *testing.B) {
+	addr := benchServer(b, "Content-Type: application/json\r\n\r\n{\"pool\":\"www\",\"active processes\":1}")
+	config := DefaultConfig()
+	config.KeepConn = true
+	client, err := DialWithConfig("tcp", addr, config)
+	if err != nil {
+		b.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close()
+
+	params := map[string]string{
+		"SCRIPT_FILENAME": "/status",
+		"SCRIPT_NAME":     "/status",
+		"QUERY_STRING":    "json",
+		"REQUEST_METHOD":  "GET",
+	}
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		resp, err := client.DoRequest(ctx, params, nil)
+		if err != nil {
+			b.Fatalf("DoRequest failed: %v", err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+}
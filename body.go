@@ -0,0 +1,175 @@
+package fcgx
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+// FormFile is a file uploaded with PostMultipart.
+type FormFile struct {
+	FieldName   string    // Form field, the key in PHP's $_FILES
+	FileName    string    // File name reported to PHP
+	ContentType string    // Default: "application/octet-stream"
+	Content     io.Reader // File content, streamed unless Config.Retry is set
+}
+
+// PostForm sends values as an application/x-www-form-urlencoded POST body,
+// which PHP parses into $_POST.
+func (c *Client) PostForm(ctx context.Context, params map[string]string, values url.Values) (*http.Response, error) {
+	body := values.Encode()
+	params["CONTENT_TYPE"] = "application/x-www-form-urlencoded"
+	return c.Post(ctx, params, strings.NewReader(body), len(body))
+}
+
+// PostMultipart sends fields and files as a multipart/form-data POST body,
+// which PHP parses into $_POST and $_FILES. File contents are streamed to
+// the server in MaxWriteSize records, unless Config.Retry is set, which
+// buffers every request body for replay. To compute CONTENT_LENGTH up
+// front, the length of each file is taken from a Len method, Stat for an
+// *os.File, or Seek; other readers are read into memory first. A body
+// longer than an int can hold fails with ErrWrite.
+func (c *Client) PostMultipart(ctx context.Context, params map[string]string, fields url.Values, files []FormFile) (*http.Response, error) {
+	body, contentType, length, err := multipartBody(fields, files)
+	if err != nil {
+		err.Addr, err.Script = c.address, scriptName(params)
+		return nil, err
+	}
+	params["CONTENT_TYPE"] = contentType
+	return c.Post(ctx, params, body, int(length))
+}
+
+var quoteEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// multipartBody lays out the body as a sequence of readers: the multipart
+// framing, written to buffers, alternating with the file contents.
+func multipartBody(fields url.Values, files []FormFile) (io.Reader, string, int64, *Error) {
+	var parts []io.Reader
+	var length int64
+	var tooLong bool // Post takes the length as an int
+	grow := func(n int64) {
+		if n > math.MaxInt-length {
+			tooLong = true
+			return
+		}
+		length += n
+	}
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	flush := func() {
+		if buf.Len() > 0 {
+			grow(int64(buf.Len()))
+			parts = append(parts, bytes.NewReader(bytes.Clone(buf.Bytes())))
+			buf.Reset()
+		}
+	}
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		for _, value := range fields[name] {
+			if err := w.WriteField(name, value); err != nil {
+				return nil, "", 0, wrap(err, ErrWrite, "writing form field")
+			}
+		}
+	}
+
+	for _, f := range files {
+		contentType := f.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+			quoteEscaper.Replace(f.FieldName), quoteEscaper.Replace(f.FileName)))
+		h.Set("Content-Type", contentType)
+		if _, err := w.CreatePart(h); err != nil {
+			return nil, "", 0, wrap(err, ErrWrite, "writing form file header")
+		}
+		flush()
+
+		content, n, err := sizedReader(f.Content)
+		if err != nil {
+			return nil, "", 0, wrap(err, ErrRead, "reading form file "+f.FileName)
+		}
+		if grow(n); tooLong {
+			return nil, "", 0, wrap(nil, ErrWrite, "multipart body exceeds the maximum length")
+		}
+		parts = append(parts, &exactReader{r: content, n: n})
+	}
+	if err := w.Close(); err != nil {
+		return nil, "", 0, wrap(err, ErrWrite, "writing multipart body")
+	}
+	if flush(); tooLong {
+		return nil, "", 0, wrap(nil, ErrWrite, "multipart body exceeds the maximum length")
+	}
+	return io.MultiReader(parts...), w.FormDataContentType(), length, nil
+}
+
+// sizedReader returns r with the number of bytes it has left, buffering r
+// when the length cannot be determined otherwise.
+func sizedReader(r io.Reader) (io.Reader, int64, error) {
+	if r == nil {
+		return bytes.NewReader(nil), 0, nil
+	}
+	if l, ok := r.(interface{ Len() int }); ok {
+		return r, int64(l.Len()), nil
+	}
+	if f, ok := r.(*os.File); ok {
+		if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+			if pos, err := f.Seek(0, io.SeekCurrent); err == nil {
+				return r, fi.Size() - pos, nil
+			}
+		}
+	} else if s, ok := r.(io.Seeker); ok {
+		if pos, err := s.Seek(0, io.SeekCurrent); err == nil {
+			if end, err := s.Seek(0, io.SeekEnd); err == nil {
+				if _, err := s.Seek(pos, io.SeekStart); err == nil {
+					return r, end - pos, nil
+				}
+			}
+		}
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	return bytes.NewReader(data), int64(len(data)), nil
+}
+
+// exactReader reads exactly n bytes from r, failing when r ends early so a
+// short file cannot leave the server waiting for the announced length.
+type exactReader struct {
+	r io.Reader
+	n int64
+}
+
+func (e *exactReader) Read(p []byte) (int, error) {
+	if e.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > e.n {
+		p = p[:e.n]
+	}
+	n, err := e.r.Read(p)
+	e.n -= int64(n)
+	if err == io.EOF && e.n > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
@@ -0,0 +1,216 @@
+package fcgx
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math"
+	"mime"
+	"mime/multipart"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gophpeek/fcgx/internal/fcgitest"
+)
+
+func TestPostForm(t *testing.T) {
+	s := fcgitest.NewServer(t, fcgitest.RespondWith("Content-Type: text/plain\r\n\r\nok"))
+	client, err := Dial("tcp", s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	params := map[string]string{"SCRIPT_FILENAME": "/form.php", "CONTENT_TYPE": "text/plain"}
+	resp, err := client.PostForm(context.Background(), params, url.Values{"name": {"Jöhn Doe"}, "tags": {"a", "b&c"}})
+	if err != nil {
+		t.Fatalf("PostForm failed: %v", err)
+	}
+	resp.Body.Close()
+
+	req := s.Request(0)
+	want := "name=J%C3%B6hn+Doe&tags=a&tags=b%26c"
+	if string(req.Stdin) != want || req.Params["CONTENT_LENGTH"] != strconv.Itoa(len(want)) ||
+		req.Params["CONTENT_TYPE"] != "application/x-www-form-urlencoded" || req.Params["REQUEST_METHOD"] != "POST" {
+		t.Errorf("Unexpected form request %q %v", req.Stdin, req.Params)
+	}
+}
+
+func TestPostMultipart(t *testing.T) {
+	s := fcgitest.NewServer(t, fcgitest.RespondWith("Content-Type: text/plain\r\n\r\nok"))
+	config := DefaultConfig()
+	config.MaxWriteSize = 1000 // Spread the body over several records
+	client, err := DialWithConfig("tcp", s.Addr(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	path := filepath.Join(t.TempDir(), "report.csv")
+	csv := strings.Repeat("a,b,c\n", 1000)
+	if err := os.WriteFile(path, []byte(csv), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	files := []FormFile{
+		{FieldName: "report", FileName: "report.csv", ContentType: "text/csv", Content: file},
+		{FieldName: "avatar", FileName: `me "1".png`, Content: bytes.NewReader([]byte{0x89, 'P', 'N', 'G'})},
+		{FieldName: "notes", FileName: "notes.txt", Content: io.LimitReader(strings.NewReader("streamed notes"), 100)},
+	}
+	params := map[string]string{"SCRIPT_FILENAME": "/upload.php"}
+	resp, err := client.PostMultipart(context.Background(), params, url.Values{"title": {"Q3"}, "tags": {"x", "y"}}, files)
+	if err != nil {
+		t.Fatalf("PostMultipart failed: %v", err)
+	}
+	resp.Body.Close()
+
+	req := s.Request(0)
+	if req.Params["CONTENT_LENGTH"] != strconv.Itoa(len(req.Stdin)) {
+		t.Errorf("CONTENT_LENGTH %s does not match the body length %d", req.Params["CONTENT_LENGTH"], len(req.Stdin))
+	}
+	mediaType, mparams, err := mime.ParseMediaType(req.Params["CONTENT_TYPE"])
+	if err != nil || mediaType != "multipart/form-data" {
+		t.Fatalf("Unexpected CONTENT_TYPE %q", req.Params["CONTENT_TYPE"])
+	}
+
+	form, err := multipart.NewReader(bytes.NewReader(req.Stdin), mparams["boundary"]).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatalf("Parsing multipart body: %v", err)
+	}
+	if got := form.Value; len(got) != 2 || got["title"][0] != "Q3" || len(got["tags"]) != 2 {
+		t.Errorf("Unexpected fields %v", got)
+	}
+	wantFiles := map[string]struct{ name, contentType, content string }{
+		"report": {"report.csv", "text/csv", csv},
+		"avatar": {`me "1".png`, "application/octet-stream", "\x89PNG"},
+		"notes":  {"notes.txt", "application/octet-stream", "streamed notes"},
+	}
+	for field, want := range wantFiles {
+		fhs := form.File[field]
+		if len(fhs) != 1 {
+			t.Errorf("Expected one %s file, got %d", field, len(fhs))
+			continue
+		}
+		fh := fhs[0]
+		f, _ := fh.Open()
+		content, _ := io.ReadAll(f)
+		f.Close()
+		if fh.Filename != want.name || fh.Header.Get("Content-Type") != want.contentType || string(content) != want.content {
+			t.Errorf("Unexpected %s file %q %q (%d bytes)", field, fh.Filename, fh.Header.Get("Content-Type"), len(content))
+		}
+	}
+}
+
+func TestPostMultipartTooLong(t *testing.T) {
+	s := fcgitest.NewServer(t, fcgitest.RespondWith("Content-Type: text/plain\r\n\r\nok"))
+	client, err := Dial("tcp", s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// Two files whose lengths only add up past the int range
+	files := []FormFile{
+		{FieldName: "a", FileName: "a", Content: &hugeSeeker{size: math.MaxInt / 2}},
+		{FieldName: "b", FileName: "b", Content: &hugeSeeker{size: math.MaxInt / 2}},
+	}
+	_, err = client.PostMultipart(context.Background(), map[string]string{"SCRIPT_FILENAME": "/upload.php"}, nil, files)
+	if !errors.Is(err, ErrWrite) {
+		t.Fatalf("Expected ErrWrite for an over-long body, got %v", err)
+	}
+	if len(s.Requests()) != 0 {
+		t.Error("Expected nothing to be sent")
+	}
+}
+
+// hugeSeeker reports size bytes through Seek without holding them.
+type hugeSeeker struct {
+	size int64
+	pos  int64
+}
+
+func (h *hugeSeeker) Read([]byte) (int, error) { return 0, io.EOF }
+
+func (h *hugeSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		h.pos = offset
+	case io.SeekCurrent:
+		h.pos += offset
+	case io.SeekEnd:
+		h.pos = h.size + offset
+	}
+	return h.pos, nil
+}
+
+func TestRequestBodyReadError(t *testing.T) {
+	s := fcgitest.NewServer(t, fcgitest.RespondWith("Content-Type: text/plain\r\n\r\nok"))
+	config := DefaultConfig()
+	config.KeepConn = true
+	config.AutoRedial = true
+	config.MaxWriteSize = 1000
+	client, err := DialWithConfig("tcp", s.Addr(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// Part of the body is sent before the reader fails
+	readErr := errors.New("disk gone")
+	body := io.MultiReader(strings.NewReader(strings.Repeat("x", 2500)), &failingReader{readErr})
+	_, err = client.Post(context.Background(), map[string]string{"SCRIPT_FILENAME": "/upload.php"}, body, 5000)
+	var fe *Error
+	if !errors.Is(err, ErrRead) || !errors.Is(err, readErr) || !errors.As(err, &fe) || fe.Phase != PhaseStdin {
+		t.Fatalf("Expected ErrRead in phase stdin, got %v", err)
+	}
+
+	// The half-sent request cannot be finished, so the next one redials
+	if err := doGet(t, client); err != nil {
+		t.Fatalf("Expected the next request to succeed, got %v", err)
+	}
+	if s.Connections() != 2 {
+		t.Errorf("Expected a fresh connection after the failed body, got %d connections", s.Connections())
+	}
+}
+
+type failingReader struct{ err error }
+
+func (r *failingReader) Read([]byte) (int, error) { return 0, r.err }
+
+func TestPostMultipartShortFile(t *testing.T) {
+	s := fcgitest.NewServer(t, fcgitest.RespondWith("Content-Type: text/plain\r\n\r\nok"))
+	client, err := Dial("tcp", s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// The file announces more bytes than it delivers
+	files := []FormFile{{FieldName: "a", FileName: "a", Content: &shortReader{strings.NewReader("short"), 100}}}
+	_, err = client.PostMultipart(context.Background(), map[string]string{"SCRIPT_FILENAME": "/upload.php"}, nil, files)
+	var fe *Error
+	if !errors.Is(err, ErrRead) || !errors.Is(err, io.ErrUnexpectedEOF) || !errors.As(err, &fe) || fe.Phase != PhaseStdin {
+		t.Fatalf("Expected ErrRead in phase stdin, got %v", err)
+	}
+	if len(s.Requests()) != 0 {
+		t.Error("Expected the truncated request to be abandoned")
+	}
+}
+
+// shortReader claims a length through Len that its reader does not have.
+type shortReader struct {
+	*strings.Reader
+	n int
+}
+
+func (r *shortReader) Len() int { return r.n }
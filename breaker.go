@@ -0,0 +1,362 @@
+package fcgx
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without contacting the server while the
+// circuit breaker for its address is open.
+var ErrCircuitOpen = errors.New("fcgx: circuit open")
+
+// CircuitState is the state of a circuit breaker.
+type CircuitState int
+
+const (
+	// CircuitClosed lets all requests through while counting failures
+	CircuitClosed CircuitState = iota
+	// CircuitOpen fails requests fast with ErrCircuitOpen
+	CircuitOpen
+	// CircuitHalfOpen lets a limited number of probe requests through
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("CircuitState(%d)", int(s))
+}
+
+// BreakerConfig holds configuration options for circuit breakers. Zero
+// fields take their defaults, so &BreakerConfig{OpenTimeout: time.Minute}
+// keeps the default thresholds and FailOn.
+type BreakerConfig struct {
+	// FailureRatio opens the circuit when this fraction of requests in the
+	// current window failed.
+	// Default: 0.5
+	FailureRatio float64
+
+	// MinRequests is the number of requests a window needs before
+	// FailureRatio is evaluated.
+	// Default: 10
+	MinRequests int
+
+	// Window is how long failures are counted while the circuit is closed.
+	// Counts reset at the end of each window.
+	// Default: 10 seconds
+	Window time.Duration
+
+	// OpenTimeout is how long the circuit stays open before half-opening.
+	// Default: 5 seconds
+	OpenTimeout time.Duration
+
+	// HalfOpenProbes is the number of probe requests allowed while half-open.
+	// The circuit closes once that many probes succeed and reopens on the
+	// first failed probe.
+	// Default: 1
+	HalfOpenProbes int
+
+	// FailOn lists the sentinel errors that count as failures. Other errors,
+	// and context cancellation in particular, do not count against the server.
+	// Requests failing fast with ErrConnClosedByServer are not counted at all.
+	// Default: ErrTimeout, ErrConnect, ErrOverloaded
+	FailOn []error
+
+	// FailOnStatus lists HTTP status codes that count as failures.
+	// Default: 503
+	FailOnStatus []int
+
+	// OnStateChange, if set, is called after a breaker changes state. It is
+	// called without holding any lock, so it may use the CircuitBreakers,
+	// and may be called concurrently. It must not block.
+	OnStateChange func(addr string, from, to CircuitState)
+}
+
+// DefaultBreakerConfig returns a BreakerConfig with sensible defaults
+func DefaultBreakerConfig() *BreakerConfig {
+	return &BreakerConfig{
+		FailureRatio:   0.5,
+		MinRequests:    10,
+		Window:         10 * time.Second,
+		OpenTimeout:    5 * time.Second,
+		HalfOpenProbes: 1,
+		FailOn:         []error{ErrTimeout, ErrConnect, ErrOverloaded},
+		FailOnStatus:   []int{http.StatusServiceUnavailable},
+	}
+}
+
+// BreakerStats is a point-in-time view of one circuit breaker.
+type BreakerStats struct {
+	Addr     string       // Backend the breaker guards, as "network:address"
+	State    CircuitState // Current state
+	Requests int          // Requests counted in the current window
+	Failures int          // Failures counted in the current window
+	Changed  time.Time    // When the breaker last changed state
+}
+
+// CircuitBreakers holds one circuit breaker per backend address. Share a
+// single instance through Config.CircuitBreakers so every client and
+// Balancer connection to the same address uses the same breaker.
+// All methods are thread-safe and can be called concurrently.
+type CircuitBreakers struct {
+	config *BreakerConfig
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+	changes  []stateChange // Transitions to report once mu is released
+}
+
+// stateChange is a transition waiting for OnStateChange.
+type stateChange struct {
+	addr     string
+	from, to CircuitState
+}
+
+// breaker is the state of a single circuit, guarded by CircuitBreakers.mu.
+type breaker struct {
+	state       CircuitState
+	changed     time.Time
+	windowStart time.Time
+	requests    int
+	failures    int
+	probes      int // Probes in flight while half-open
+	successes   int // Successful probes while half-open
+}
+
+// NewCircuitBreakers creates an empty set of circuit breakers. A nil config
+// uses DefaultBreakerConfig.
+func NewCircuitBreakers(config *BreakerConfig) *CircuitBreakers {
+	return &CircuitBreakers{config: config.withDefaults(), breakers: make(map[string]*breaker)}
+}
+
+// withDefaults returns a copy of c with zero fields set to their defaults.
+// A nil config yields DefaultBreakerConfig.
+func (c *BreakerConfig) withDefaults() *BreakerConfig {
+	d := DefaultBreakerConfig()
+	if c == nil {
+		return d
+	}
+	cfg := *c
+	if cfg.FailureRatio == 0 {
+		cfg.FailureRatio = d.FailureRatio
+	}
+	if cfg.MinRequests == 0 {
+		cfg.MinRequests = d.MinRequests
+	}
+	if cfg.Window == 0 {
+		cfg.Window = d.Window
+	}
+	if cfg.OpenTimeout == 0 {
+		cfg.OpenTimeout = d.OpenTimeout
+	}
+	if cfg.HalfOpenProbes == 0 {
+		cfg.HalfOpenProbes = d.HalfOpenProbes
+	}
+	if cfg.FailOn == nil {
+		cfg.FailOn = d.FailOn
+	}
+	if cfg.FailOnStatus == nil {
+		cfg.FailOnStatus = d.FailOnStatus
+	}
+	return &cfg
+}
+
+func breakerKey(network, address string) string {
+	return network + ":" + address
+}
+
+// State returns the current state of the breaker for network and address.
+func (cb *CircuitBreakers) State(network, address string) CircuitState {
+	cb.mu.Lock()
+	defer cb.unlock()
+	b, ok := cb.breakers[breakerKey(network, address)]
+	if !ok {
+		return CircuitClosed
+	}
+	cb.advance(breakerKey(network, address), b, time.Now())
+	return b.state
+}
+
+// Stats returns the state of every breaker, sorted by address.
+func (cb *CircuitBreakers) Stats() []BreakerStats {
+	cb.mu.Lock()
+	defer cb.unlock()
+
+	now := time.Now()
+	stats := make([]BreakerStats, 0, len(cb.breakers))
+	for addr, b := range cb.breakers {
+		cb.advance(addr, b, now)
+		stats = append(stats, BreakerStats{
+			Addr:     addr,
+			State:    b.state,
+			Requests: b.requests,
+			Failures: b.failures,
+			Changed:  b.changed,
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Addr < stats[j].Addr })
+	return stats
+}
+
+// advance applies time-based transitions: window resets while closed and
+// half-opening once OpenTimeout has passed.
+func (cb *CircuitBreakers) advance(addr string, b *breaker, now time.Time) {
+	switch b.state {
+	case CircuitClosed:
+		if now.Sub(b.windowStart) >= cb.config.Window {
+			b.windowStart = now
+			b.requests, b.failures = 0, 0
+		}
+	case CircuitOpen:
+		if now.Sub(b.changed) >= cb.config.OpenTimeout {
+			cb.transition(addr, b, CircuitHalfOpen, now)
+		}
+	}
+}
+
+// transition moves b to state and resets its counters.
+func (cb *CircuitBreakers) transition(addr string, b *breaker, state CircuitState, now time.Time) {
+	from := b.state
+	b.state = state
+	b.changed = now
+	b.windowStart = now
+	b.requests, b.failures = 0, 0
+	b.probes, b.successes = 0, 0
+	if cb.config.OnStateChange != nil {
+		cb.changes = append(cb.changes, stateChange{addr, from, state})
+	}
+}
+
+// unlock releases cb.mu and then reports the transitions made while it was
+// held, so OnStateChange can call back into cb.
+func (cb *CircuitBreakers) unlock() {
+	changes := cb.changes
+	cb.changes = nil
+	cb.mu.Unlock()
+	for _, c := range changes {
+		cb.config.OnStateChange(c.addr, c.from, c.to)
+	}
+}
+
+// checkOpen fails fast while the breaker for addr is open, without taking a
+// half-open probe slot. It guards dialing.
+func (cb *CircuitBreakers) checkOpen(addr string) error {
+	cb.mu.Lock()
+	defer cb.unlock()
+	b, ok := cb.breakers[addr]
+	if !ok {
+		return nil
+	}
+	cb.advance(addr, b, time.Now())
+	if b.state == CircuitOpen {
+		return &Error{Op: "dial not attempted", Kind: ErrCircuitOpen, Phase: PhaseConnect, Addr: addr}
+	}
+	return nil
+}
+
+// allow admits a request to addr and returns a function that must be called
+// with its outcome, or ErrCircuitOpen when the request must fail fast.
+func (cb *CircuitBreakers) allow(addr string) (func(*http.Response, error), error) {
+	cb.mu.Lock()
+	defer cb.unlock()
+
+	now := time.Now()
+	b, ok := cb.breakers[addr]
+	if !ok {
+		b = &breaker{changed: now, windowStart: now}
+		cb.breakers[addr] = b
+	}
+	cb.advance(addr, b, now)
+
+	probe := false
+	switch b.state {
+	case CircuitOpen:
+		return nil, &Error{Op: "request not sent", Kind: ErrCircuitOpen, Addr: addr}
+	case CircuitHalfOpen:
+		if b.probes >= max(cb.config.HalfOpenProbes, 1) {
+			return nil, &Error{Op: "request not sent while probing", Kind: ErrCircuitOpen, Addr: addr}
+		}
+		b.probes++
+		probe = true
+	}
+
+	return func(resp *http.Response, err error) {
+		cb.record(addr, b, probe, resp, err)
+	}, nil
+}
+
+// isFailure reports whether an outcome counts against the server, and
+// whether it should be counted at all.
+func (cb *CircuitBreakers) isFailure(resp *http.Response, err error) (failed, counted bool) {
+	if err != nil {
+		// The request failed fast on a connection an earlier failure left
+		// unusable, and never reached the server
+		if errors.Is(err, ErrConnClosedByServer) {
+			return false, false
+		}
+		if isAny(err, cb.config.FailOn) {
+			return true, true
+		}
+		return false, !errors.Is(err, ErrContextCancelled)
+	}
+	return resp != nil && slices.Contains(cb.config.FailOnStatus, resp.StatusCode), true
+}
+
+// record applies the outcome of an admitted request.
+func (cb *CircuitBreakers) record(addr string, b *breaker, probe bool, resp *http.Response, err error) {
+	failed, counted := cb.isFailure(resp, err)
+
+	cb.mu.Lock()
+	defer cb.unlock()
+
+	now := time.Now()
+	if probe {
+		// A transition since the probe was admitted makes its outcome stale
+		if b.state != CircuitHalfOpen {
+			return
+		}
+		b.probes--
+		switch {
+		case !counted:
+		case failed:
+			cb.transition(addr, b, CircuitOpen, now)
+		default:
+			b.successes++
+			if b.successes >= max(cb.config.HalfOpenProbes, 1) {
+				cb.transition(addr, b, CircuitClosed, now)
+			}
+		}
+		return
+	}
+
+	if b.state != CircuitClosed || !counted {
+		return
+	}
+	cb.advance(addr, b, now)
+	b.requests++
+	if failed {
+		b.failures++
+	}
+	if b.requests >= cb.config.MinRequests && float64(b.failures) >= cb.config.FailureRatio*float64(b.requests) {
+		cb.transition(addr, b, CircuitOpen, now)
+	}
+}
+
+// recordDialFailure counts a failed dial to addr as a failed request.
+func (cb *CircuitBreakers) recordDialFailure(addr string, err error) {
+	done, allowErr := cb.allow(addr)
+	if allowErr == nil {
+		done(nil, err)
+	}
+}
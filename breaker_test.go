@@ -0,0 +1,219 @@
+package fcgx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gophpeek/fcgx/internal/fcgitest"
+)
+
+func testBreakerConfig() *BreakerConfig {
+	config := DefaultBreakerConfig()
+	config.MinRequests = 4
+	config.OpenTimeout = 20 * time.Millisecond
+	return config
+}
+
+func TestBreakerConfigDefaults(t *testing.T) {
+	c := (&BreakerConfig{OpenTimeout: time.Minute}).withDefaults()
+	d := DefaultBreakerConfig()
+	if c.OpenTimeout != time.Minute || c.FailureRatio != d.FailureRatio || c.MinRequests != d.MinRequests ||
+		c.Window != d.Window || c.HalfOpenProbes != d.HalfOpenProbes ||
+		len(c.FailOn) != len(d.FailOn) || len(c.FailOnStatus) != len(d.FailOnStatus) {
+		t.Errorf("Expected zero fields to take defaults, got %+v", c)
+	}
+
+	// A partial config neither opens on success nor ignores timeouts
+	cb := NewCircuitBreakers(&BreakerConfig{OpenTimeout: time.Minute})
+	done, _ := cb.allow("tcp:a")
+	done(nil, nil)
+	for i := 0; i < 9; i++ {
+		done, _ := cb.allow("tcp:b")
+		done(nil, &Error{Kind: ErrTimeout})
+	}
+	if cb.State("tcp", "a") != CircuitClosed || cb.State("tcp", "b") != CircuitClosed {
+		t.Error("Expected both circuits to stay closed below MinRequests")
+	}
+	done, _ = cb.allow("tcp:b")
+	done(nil, &Error{Kind: ErrTimeout})
+	if cb.State("tcp", "b") != CircuitOpen {
+		t.Error("Expected timeouts to open the circuit")
+	}
+}
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	var mu sync.Mutex
+	var changes []string
+	var cb *CircuitBreakers
+	config := testBreakerConfig()
+	config.OnStateChange = func(addr string, from, to CircuitState) {
+		// The callback may use the breakers without deadlocking
+		if s := cb.Stats(); len(s) != 1 || s[0].State != to {
+			t.Errorf("Expected Stats to show %s in OnStateChange, got %+v", to, s)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		changes = append(changes, fmt.Sprintf("%s->%s", from, to))
+	}
+	cb = NewCircuitBreakers(config)
+	key := breakerKey("tcp", "backend:9000")
+	timeout := fmt.Errorf("%w: slow", ErrTimeout)
+
+	outcome := func(resp *http.Response, err error) error {
+		done, allowErr := cb.allow(key)
+		if allowErr != nil {
+			return allowErr
+		}
+		done(resp, err)
+		return nil
+	}
+
+	// Errors that say nothing about the server are not counted
+	for i := 0; i < 10; i++ {
+		outcome(nil, ErrContextCancelled)
+	}
+	outcome(&http.Response{StatusCode: 200}, nil)
+	outcome(&http.Response{StatusCode: 200}, nil)
+	outcome(nil, timeout)
+	if s := cb.Stats()[0]; s.State != CircuitClosed || s.Requests != 3 || s.Failures != 1 {
+		t.Fatalf("Expected closed with 1/3 failures, got %+v", s)
+	}
+
+	// The fourth request reaches MinRequests at a 50% failure ratio
+	outcome(&http.Response{StatusCode: 503}, nil)
+	if cb.State("tcp", "backend:9000") != CircuitOpen {
+		t.Fatal("Expected circuit to open")
+	}
+	if err := outcome(nil, nil); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected ErrCircuitOpen, got %v", err)
+	}
+
+	// After OpenTimeout a single probe is let through; a failed one reopens
+	time.Sleep(config.OpenTimeout)
+	done, err := cb.allow(key)
+	if err != nil {
+		t.Fatalf("Expected probe to be allowed, got %v", err)
+	}
+	if _, err := cb.allow(key); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected second probe to be rejected, got %v", err)
+	}
+	done(nil, timeout)
+	if cb.State("tcp", "backend:9000") != CircuitOpen {
+		t.Fatal("Expected failed probe to reopen the circuit")
+	}
+
+	// A successful probe closes it
+	time.Sleep(config.OpenTimeout)
+	if err := outcome(&http.Response{StatusCode: 200}, nil); err != nil {
+		t.Fatalf("Expected probe to be allowed, got %v", err)
+	}
+	if cb.State("tcp", "backend:9000") != CircuitClosed {
+		t.Fatal("Expected successful probe to close the circuit")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"closed->open", "open->half-open", "half-open->open", "open->half-open", "half-open->closed"}
+	if fmt.Sprint(changes) != fmt.Sprint(want) {
+		t.Errorf("Expected transitions %v, got %v", want, changes)
+	}
+}
+
+func TestClientCircuitBreaker(t *testing.T) {
+	srv, calls := flakyServer(t, 100, overload)
+	config := DefaultConfig()
+	config.KeepConn = true
+	config.CircuitBreakers = NewCircuitBreakers(testBreakerConfig())
+	client, err := DialWithConfig("tcp", srv.Addr(), config)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close()
+
+	for i := 0; i < 4; i++ {
+		if err := doGet(t, client); !errors.Is(err, ErrOverloaded) {
+			t.Fatalf("Request %d: expected ErrOverloaded, got %v", i, err)
+		}
+	}
+	if err := doGet(t, client); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected ErrCircuitOpen, got %v", err)
+	}
+	if calls.Load() != 4 {
+		t.Errorf("Expected the open circuit to fail fast, got %d server calls", calls.Load())
+	}
+
+	// Dialing fails fast as well
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := DialContextWithConfig(ctx, "tcp", srv.Addr(), config); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected dial to fail with ErrCircuitOpen, got %v", err)
+	}
+}
+
+func TestCircuitBreakerFailedRedial(t *testing.T) {
+	srv := fcgitest.NewServer(t, fcgitest.RespondWith("Content-Type: text/plain\r\n\r\nok"))
+	var dials int
+	config := DefaultConfig()
+	config.AutoRedial = true
+	config.CircuitBreakers = NewCircuitBreakers(testBreakerConfig())
+	config.Dialer = func(ctx context.Context, network, address string) (net.Conn, error) {
+		if dials++; dials > 1 {
+			return nil, errors.New("refused")
+		}
+		var d net.Dialer
+		return d.DialContext(ctx, network, address)
+	}
+	client, err := DialWithConfig("tcp", srv.Addr(), config)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close()
+
+	client.markConnClosed(errors.New("lost"))
+	if err := doGet(t, client); !errors.Is(err, ErrConnect) {
+		t.Fatalf("Expected ErrConnect, got %v", err)
+	}
+	stats := config.CircuitBreakers.Stats()
+	if len(stats) != 1 || stats[0].Requests != 1 || stats[0].Failures != 1 {
+		t.Errorf("Expected the failed redial to count once, got %+v", stats)
+	}
+}
+
+func TestBalancerCircuitBreaker(t *testing.T) {
+	dead := fcgitest.DeadAddr(t)
+	live := fcgitest.NewServer(t, fcgitest.RespondWith("Content-Type: text/plain\r\n\r\nok"))
+
+	breakerConfig := testBreakerConfig()
+	breakerConfig.MinRequests = 1
+	breakerConfig.OpenTimeout = time.Minute
+	config := DefaultBalancerConfig()
+	config.EjectDuration = time.Nanosecond
+	config.ClientConfig.CircuitBreakers = NewCircuitBreakers(breakerConfig)
+	bal, err := NewBalancer([]Backend{
+		{Network: "tcp", Address: dead},
+		{Network: "tcp", Address: live.Addr()},
+	}, config)
+	if err != nil {
+		t.Fatalf("NewBalancer failed: %v", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		if err := balancerGet(t, bal, testParams()); err != nil {
+			t.Fatalf("Request %d failed over unsuccessfully: %v", i, err)
+		}
+	}
+	// Ejection lapses immediately, so only the open circuit keeps the dead
+	// backend out of rotation after its first failure
+	if got := bal.Stats()[0].Requests; got != 1 {
+		t.Errorf("Expected the dead backend to be tried once, got %d", got)
+	}
+	if config.ClientConfig.CircuitBreakers.State("tcp", dead) != CircuitOpen {
+		t.Error("Expected the dead backend's circuit to be open")
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/gophpeek/fcgx"
+	"github.com/gophpeek/fcgx/internal/cli"
+)
+
+type options struct {
+	network     string
+	address     string
+	concurrency int
+	requests    int
+	duration    time.Duration
+	rate        float64
+	timeout     time.Duration
+	noKeepConn  bool
+	cli.Request
+}
+
+// templateData is passed to param templates.
+type templateData struct {
+	Seq    int64 // Request number, from 1
+	Worker int   // Worker number, from 0
+	Rand   int   // Random non-negative int
+}
+
+// param is one FastCGI param. Values containing "{{" are templates executed
+// for every request.
+type param struct {
+	key   string
+	value string
+	tmpl  *template.Template
+}
+
+type bench struct {
+	opts   options
+	params []param
+	body   []byte
+}
+
+// workerStats is collected by a single worker and merged into the report.
+type workerStats struct {
+	latencies []time.Duration
+	errors    map[string]int
+	status    map[int]int
+	protocol  map[string]int
+}
+
+func newBench(opts options) (*bench, error) {
+	b := &bench{opts: opts}
+	if opts.Data != "" {
+		if name, ok := strings.CutPrefix(opts.Data, "@"); ok {
+			data, err := os.ReadFile(name)
+			if err != nil {
+				return nil, err
+			}
+			b.body = data
+		} else {
+			b.body = []byte(opts.Data)
+		}
+	}
+
+	values, err := opts.BuildParams("fcgx-bench")
+	if err != nil {
+		return nil, err
+	}
+	if opts.Data != "" {
+		cli.SetDefault(values, "CONTENT_LENGTH", strconv.Itoa(len(b.body)))
+		cli.SetDefault(values, "CONTENT_TYPE", "application/x-www-form-urlencoded")
+	} else {
+		cli.SetDefault(values, "CONTENT_LENGTH", "0")
+	}
+	for k, v := range values {
+		p := param{key: k, value: v}
+		if strings.Contains(v, "{{") {
+			if p.tmpl, err = template.New(k).Option("missingkey=error").Parse(v); err != nil {
+				return nil, fmt.Errorf("param %s: %w", k, err)
+			}
+		}
+		b.params = append(b.params, p)
+	}
+	return b, nil
+}
+
+// requestParams returns the params for one request, executing templates.
+func (b *bench) requestParams(seq int64, worker int, buf *strings.Builder) (map[string]string, error) {
+	params := make(map[string]string, len(b.params))
+	data := templateData{Seq: seq, Worker: worker, Rand: rand.IntN(math.MaxInt32)}
+	for _, p := range b.params {
+		if p.tmpl == nil {
+			params[p.key] = p.value
+			continue
+		}
+		buf.Reset()
+		if err := p.tmpl.Execute(buf, data); err != nil {
+			return nil, err
+		}
+		params[p.key] = buf.String()
+	}
+	return params, nil
+}
+
+// run drives the server until the request count or duration is reached, or
+// ctx is cancelled, and reports the results. Requests in flight when the
+// duration ends are allowed to finish.
+func (b *bench) run(ctx context.Context) *report {
+	if b.opts.duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.opts.duration)
+		defer cancel()
+	}
+	var tokens <-chan time.Time
+	if b.opts.rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / b.opts.rate))
+		defer ticker.Stop()
+		tokens = ticker.C
+	}
+
+	start := time.Now()
+	var seq atomic.Int64
+	stats := make([]*workerStats, b.opts.concurrency)
+	var wg sync.WaitGroup
+	for i := range stats {
+		stats[i] = &workerStats{errors: map[string]int{}, status: map[int]int{}, protocol: map[string]int{}}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.worker(ctx, i, &seq, tokens, stats[i])
+		}()
+	}
+	wg.Wait()
+	return newReport(time.Since(start), stats)
+}
+
+// worker sends requests one after another on its own client.
+func (b *bench) worker(ctx context.Context, id int, seq *atomic.Int64, tokens <-chan time.Time, s *workerStats) {
+	config := fcgx.DefaultConfig()
+	config.KeepConn = !b.opts.noKeepConn
+	config.AutoRedial = true
+	config.RequestTimeout = b.opts.timeout
+
+	var client *fcgx.Client
+	defer func() {
+		if client != nil {
+			client.Close()
+		}
+	}()
+
+	traceCtx := fcgx.WithTrace(context.Background(), &fcgx.Trace{
+		EndRequest: func(info fcgx.EndRequestInfo) {
+			s.protocol[info.ProtocolStatus.String()]++
+		},
+	})
+	var buf strings.Builder
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		n := seq.Add(1)
+		if b.opts.requests > 0 && n > int64(b.opts.requests) {
+			return
+		}
+		if tokens != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tokens:
+			}
+		}
+
+		params, err := b.requestParams(n, id, &buf)
+		if err != nil {
+			s.record(0, err)
+			continue
+		}
+		// Requests are not tied to ctx so the last ones are not cut short
+		reqCtx, cancel := context.WithTimeout(traceCtx, b.opts.timeout)
+		start := time.Now()
+		if client == nil {
+			client, err = fcgx.DialContextWithConfig(reqCtx, b.opts.network, b.opts.address, config)
+		}
+		if err == nil {
+			var body io.Reader
+			if b.body != nil {
+				body = bytes.NewReader(b.body)
+			}
+			var resp *http.Response
+			resp, err = client.DoRequest(reqCtx, params, body)
+			if err == nil {
+				_, err = io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				if err == nil {
+					s.status[resp.StatusCode]++
+				}
+			}
+		}
+		cancel()
+		s.record(time.Since(start), err)
+	}
+}
+
+// record counts the outcome of a request. Only successful requests add to
+// the latency sample, so fast failures such as FCGI_OVERLOADED rejections
+// cannot make a saturated pool look quick.
+func (s *workerStats) record(d time.Duration, err error) {
+	if err != nil {
+		s.errors[errorClass(err)]++
+		return
+	}
+	if d > 0 {
+		s.latencies = append(s.latencies, d)
+	}
+}
+
+// errorClasses are the sentinel errors requests are grouped by.
+var errorClasses = []error{
+	fcgx.ErrOverloaded,
+	fcgx.ErrTimeout,
+	fcgx.ErrConnect,
+	fcgx.ErrCircuitOpen,
+	fcgx.ErrConnClosedByServer,
+	fcgx.ErrUnexpectedEOF,
+	fcgx.ErrPHPFPM,
+	fcgx.ErrProtocol,
+	fcgx.ErrInvalidResponse,
+	fcgx.ErrWrite,
+	fcgx.ErrRead,
+	fcgx.ErrContextCancelled,
+	fcgx.ErrClientClosed,
+}
+
+// errorClass names the sentinel error err matches.
+func errorClass(err error) string {
+	for _, class := range errorClasses {
+		if errors.Is(err, class) {
+			return class.Error()
+		}
+	}
+	return "other"
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gophpeek/fcgx"
+	"github.com/gophpeek/fcgx/internal/cli"
+	"github.com/gophpeek/fcgx/internal/fcgitest"
+	"github.com/gophpeek/fcgx/protocol"
+)
+
+// serveFastCGI answers requests, keeping connections open when asked to.
+// Every request whose QUERY_STRING is "busy" is rejected with
+// FCGI_OVERLOADED. The REQUEST_URI of each request is sent on seen.
+func serveFastCGI(t *testing.T, seen chan<- string) string {
+	t.Helper()
+	return fcgitest.NewServer(t, func(w io.Writer, req *fcgitest.Request) {
+		seen <- req.Params["REQUEST_URI"]
+		if req.Params["QUERY_STRING"] == "busy" {
+			fcgitest.WriteEndRequest(w, req.ID, 0, protocol.StatusOverloaded)
+			return
+		}
+		fcgitest.WriteRecord(w, protocol.TypeStdout, req.ID, []byte("Content-Type: text/plain\r\n\r\nok"))
+		fcgitest.WriteEndRequest(w, req.ID, 0, protocol.StatusRequestComplete)
+	}).Addr()
+}
+
+func TestRun(t *testing.T) {
+	seen := make(chan string, 100)
+	addr := serveFastCGI(t, seen)
+
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), []string{"-json", "-c", "4", "-n", "20", "-script", "/item.php", "-query", "id={{.Seq}}", addr}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("run exited %d: %s", code, stderr.String())
+	}
+
+	var rep jsonReport
+	if err := json.Unmarshal(stdout.Bytes(), &rep); err != nil {
+		t.Fatalf("decoding report %q: %v", stdout.String(), err)
+	}
+	if rep.Requests != 20 || rep.Successful != 20 || rep.StatusCodes[200] != 20 || rep.ProtocolStatus["FCGI_REQUEST_COMPLETE"] != 20 {
+		t.Errorf("Unexpected report %+v", rep)
+	}
+	if rep.Latency["p99"] <= 0 || rep.Latency["p50"] > rep.Latency["p99"] {
+		t.Errorf("Unexpected latency %v", rep.Latency)
+	}
+
+	// Each request executes the templates with its own sequence number
+	uris := map[string]bool{}
+	for range 20 {
+		uris[<-seen] = true
+	}
+	if len(uris) != 20 || !uris["/item.php?id=1"] || !uris["/item.php?id=20"] {
+		t.Errorf("Expected distinct URIs per request, got %v", uris)
+	}
+}
+
+func TestRunOverloaded(t *testing.T) {
+	addr := serveFastCGI(t, make(chan string, 100))
+
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), []string{"-c", "2", "-n", "10", "-query", "busy", addr}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("run exited %d: %s", code, stderr.String())
+	}
+	out := stdout.String()
+	// Rejections are not part of the latency sample
+	for _, want := range []string{"Requests:      10", "Successful:    0", "FCGI_OVERLOADED", "fcgx: server overloaded  10", "p50   0s"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in report:\n%s", want, out)
+		}
+	}
+}
+
+func TestRunDuration(t *testing.T) {
+	addr := serveFastCGI(t, make(chan string, 1000))
+
+	var stdout, stderr bytes.Buffer
+	start := time.Now()
+	code := run(context.Background(), []string{"-json", "-c", "2", "-z", "200ms", "-q", "50", addr}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("run exited %d: %s", code, stderr.String())
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected run to stop after its duration, took %v", elapsed)
+	}
+	var rep jsonReport
+	if err := json.Unmarshal(stdout.Bytes(), &rep); err != nil {
+		t.Fatal(err)
+	}
+	// 50 requests per second for 200ms, give or take scheduling
+	if rep.Requests < 3 || rep.Requests > 20 {
+		t.Errorf("Expected rate limited run, got %d requests", rep.Requests)
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{&fcgx.Error{Op: "x", Kind: fcgx.ErrTimeout}, "fcgx: timeout"},
+		{&fcgx.Error{Op: "x", Kind: fcgx.ErrOverloaded}, "fcgx: server overloaded"},
+		{&fcgx.Error{Op: "x", Kind: fcgx.ErrConnect}, "fcgx: connect error"},
+		{errors.New("boom"), "other"},
+	}
+	for _, tt := range tests {
+		if got := errorClass(tt.err); got != tt.want {
+			t.Errorf("errorClass(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestDistribution(t *testing.T) {
+	var d []time.Duration
+	for i := 100; i >= 1; i-- {
+		d = append(d, time.Duration(i)*time.Millisecond)
+	}
+	l := distribution(d)
+	if l.Min != time.Millisecond || l.Max != 100*time.Millisecond || l.P50 != 50*time.Millisecond ||
+		l.P99 != 99*time.Millisecond || l.Mean != 50500*time.Microsecond {
+		t.Errorf("Unexpected distribution %+v", l)
+	}
+	if (distribution(nil) != latency{}) {
+		t.Error("Expected empty distribution for no requests")
+	}
+}
+
+func TestRequestParams(t *testing.T) {
+	b, err := newBench(options{Request: cli.Request{Script: "/a.php", Params: cli.MultiFlag{"HTTP_X_WORKER=w{{.Worker}}"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf strings.Builder
+	params, err := b.requestParams(7, 3, &buf)
+	if err != nil || params["HTTP_X_WORKER"] != "w3" || params["SCRIPT_NAME"] != "/a.php" || params["CONTENT_LENGTH"] != "0" {
+		t.Errorf("Unexpected params %v, %v", params, err)
+	}
+
+	if _, err := newBench(options{Request: cli.Request{Params: cli.MultiFlag{"X={{.Nope"}}}); err == nil {
+		t.Error("Expected invalid template to be rejected")
+	}
+}
@@ -0,0 +1,90 @@
+// Command fcgx-bench load tests a FastCGI server such as PHP-FPM directly,
+// without an HTTP server in front, in the style of hey and wrk.
+//
+// Usage:
+//
+//	fcgx-bench [flags] address
+//
+// The address is "unix:/path/to.sock", "tcp:host:port", an absolute socket
+// path or host:port. For example:
+//
+//	fcgx-bench -c 50 -z 30s -script /var/www/index.php /run/php-fpm.sock
+//	fcgx-bench -c 20 -q 500 -n 10000 -script /var/www/item.php -query 'id={{.Seq}}' 127.0.0.1:9000
+//
+// Param values, including -script and -query, are Go templates executed for
+// every request with .Seq (request number from 1), .Worker (worker number
+// from 0) and .Rand (a random non-negative int).
+//
+// The report lists throughput, latency percentiles of successful requests,
+// HTTP status codes, FCGI_END_REQUEST protocol statuses such as
+// FCGI_OVERLOADED, and errors grouped by fcgx sentinel error.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/gophpeek/fcgx/internal/cli"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	os.Exit(run(ctx, os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	var opts options
+	var jsonOut bool
+	fs := flag.NewFlagSet("fcgx-bench", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.IntVar(&opts.concurrency, "c", 10, "number of workers, each with its own connection")
+	fs.IntVar(&opts.requests, "n", 0, "number of requests to send; 0 runs for the duration")
+	fs.DurationVar(&opts.duration, "z", 0, "duration to send requests for (default 10s unless -n is set)")
+	fs.Float64Var(&opts.rate, "q", 0, "overall rate limit in requests per second; 0 means unlimited")
+	fs.DurationVar(&opts.timeout, "timeout", 30*time.Second, "timeout for each request")
+	fs.BoolVar(&opts.noKeepConn, "disable-keepalive", false, "open a new connection for every request")
+	opts.AddFlags(fs, "@file reads a file")
+	fs.BoolVar(&jsonOut, "json", false, "write the report as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: fcgx-bench [flags] address\n\naddress is unix:/path, tcp:host:port, /path or host:port\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	if opts.concurrency < 1 {
+		fmt.Fprintln(stderr, "fcgx-bench: -c must be at least 1")
+		return 2
+	}
+	if opts.requests == 0 && opts.duration == 0 {
+		opts.duration = 10 * time.Second
+	}
+	opts.network, opts.address = cli.ParseAddress(fs.Arg(0))
+
+	b, err := newBench(opts)
+	if err != nil {
+		fmt.Fprintf(stderr, "fcgx-bench: %v\n", err)
+		return 2
+	}
+	rep := b.run(ctx)
+	if jsonOut {
+		err = rep.writeJSON(stdout)
+	} else {
+		err = rep.writeText(stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "fcgx-bench: %v\n", err)
+		return 1
+	}
+	return 0
+}
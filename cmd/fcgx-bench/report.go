@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// report summarizes a benchmark run.
+type report struct {
+	Total          time.Duration
+	Requests       int
+	Errors         map[string]int // By sentinel error
+	StatusCodes    map[int]int    // HTTP status of successful requests
+	ProtocolStatus map[string]int // FCGI_END_REQUEST protocol status
+	Latency        latency
+}
+
+// latency holds the latency distribution over successful requests.
+type latency struct {
+	Min, Mean, Max     time.Duration
+	P50, P90, P95, P99 time.Duration
+}
+
+func newReport(total time.Duration, stats []*workerStats) *report {
+	r := &report{
+		Total:          total,
+		Errors:         map[string]int{},
+		StatusCodes:    map[int]int{},
+		ProtocolStatus: map[string]int{},
+	}
+	var all []time.Duration
+	for _, s := range stats {
+		all = append(all, s.latencies...)
+		for k, v := range s.errors {
+			r.Errors[k] += v
+		}
+		for k, v := range s.status {
+			r.StatusCodes[k] += v
+		}
+		for k, v := range s.protocol {
+			r.ProtocolStatus[k] += v
+		}
+	}
+	for _, n := range r.StatusCodes {
+		r.Requests += n
+	}
+	for _, n := range r.Errors {
+		r.Requests += n
+	}
+	r.Latency = distribution(all)
+	return r
+}
+
+// distribution sorts d and computes its percentiles.
+func distribution(d []time.Duration) latency {
+	if len(d) == 0 {
+		return latency{}
+	}
+	slices.Sort(d)
+	var sum time.Duration
+	for _, v := range d {
+		sum += v
+	}
+	return latency{
+		Min:  d[0],
+		Mean: sum / time.Duration(len(d)),
+		Max:  d[len(d)-1],
+		P50:  percentile(d, 0.50),
+		P90:  percentile(d, 0.90),
+		P95:  percentile(d, 0.95),
+		P99:  percentile(d, 0.99),
+	}
+}
+
+// percentile returns the nearest-rank percentile q of sorted.
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	i := int(math.Ceil(q*float64(len(sorted)))) - 1
+	return sorted[max(i, 0)]
+}
+
+func (r *report) successful() int {
+	n := 0
+	for _, v := range r.StatusCodes {
+		n += v
+	}
+	return n
+}
+
+func (r *report) throughput() float64 {
+	if r.Total <= 0 {
+		return 0
+	}
+	return float64(r.Requests) / r.Total.Seconds()
+}
+
+func (r *report) writeText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Summary:\n")
+	fmt.Fprintf(tw, "  Total:\t%.4f secs\n", r.Total.Seconds())
+	fmt.Fprintf(tw, "  Requests:\t%d\n", r.Requests)
+	fmt.Fprintf(tw, "  Successful:\t%d\n", r.successful())
+	fmt.Fprintf(tw, "  Requests/sec:\t%.2f\n", r.throughput())
+
+	l := r.Latency
+	fmt.Fprintf(tw, "\nLatency:\n")
+	for _, row := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"min", l.Min}, {"mean", l.Mean}, {"p50", l.P50}, {"p90", l.P90},
+		{"p95", l.P95}, {"p99", l.P99}, {"max", l.Max},
+	} {
+		fmt.Fprintf(tw, "  %s\t%v\n", row.name, row.d.Round(time.Microsecond))
+	}
+
+	if len(r.StatusCodes) > 0 {
+		fmt.Fprintf(tw, "\nStatus codes:\n")
+		codes := slices.Sorted(maps.Keys(r.StatusCodes))
+		for _, code := range codes {
+			fmt.Fprintf(tw, "  [%d]\t%d responses\n", code, r.StatusCodes[code])
+		}
+	}
+	if len(r.ProtocolStatus) > 0 {
+		fmt.Fprintf(tw, "\nProtocol status:\n")
+		for _, status := range slices.Sorted(maps.Keys(r.ProtocolStatus)) {
+			fmt.Fprintf(tw, "  %s\t%d\n", status, r.ProtocolStatus[status])
+		}
+	}
+	if len(r.Errors) > 0 {
+		fmt.Fprintf(tw, "\nErrors:\n")
+		for _, e := range sortedByCount(r.Errors) {
+			fmt.Fprintf(tw, "  %s\t%d\n", e, r.Errors[e])
+		}
+	}
+	return tw.Flush()
+}
+
+// sortedByCount returns the keys of m, most frequent first.
+func sortedByCount(m map[string]int) []string {
+	keys := slices.Collect(maps.Keys(m))
+	sort.Slice(keys, func(i, j int) bool {
+		if m[keys[i]] != m[keys[j]] {
+			return m[keys[i]] > m[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// jsonReport is the -json output, with durations in milliseconds.
+type jsonReport struct {
+	TotalSeconds   float64            `json:"total_seconds"`
+	Requests       int                `json:"requests"`
+	Successful     int                `json:"successful"`
+	RequestsPerSec float64            `json:"requests_per_sec"`
+	Latency        map[string]float64 `json:"latency_ms"`
+	StatusCodes    map[int]int        `json:"status_codes"`
+	ProtocolStatus map[string]int     `json:"protocol_status"`
+	Errors         map[string]int     `json:"errors"`
+}
+
+func (r *report) writeJSON(w io.Writer) error {
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	l := r.Latency
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(jsonReport{
+		TotalSeconds:   r.Total.Seconds(),
+		Requests:       r.Requests,
+		Successful:     r.successful(),
+		RequestsPerSec: r.throughput(),
+		Latency: map[string]float64{
+			"min": ms(l.Min), "mean": ms(l.Mean), "max": ms(l.Max),
+			"p50": ms(l.P50), "p90": ms(l.P90), "p95": ms(l.P95), "p99": ms(l.P99),
+		},
+		StatusCodes:    r.StatusCodes,
+		ProtocolStatus: r.ProtocolStatus,
+		Errors:         r.Errors,
+	})
+}
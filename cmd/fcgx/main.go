@@ -0,0 +1,225 @@
+// Command fcgx sends a single request to a FastCGI server such as PHP-FPM,
+// in the spirit of cgi-fcgi, for poking pools from containers and scripts.
+//
+// Usage:
+//
+//	fcgx [flags] address
+//
+// The address is "unix:/path/to.sock", "tcp:host:port", an absolute socket
+// path or host:port. For example:
+//
+//	fcgx -script /var/www/index.php -query 'a=1' /run/php-fpm.sock
+//	fcgx -script /status -query 'json&full' -json 127.0.0.1:9000
+//	fcgx -script /var/www/upload.php -H 'Content-Type: application/json' -d @body.json unix:/run/php-fpm.sock
+//
+// The response headers and body are written to stdout and FCGI_STDERR output
+// to stderr. With -json a single JSON object is written instead, including the
+// FCGI_END_REQUEST status and timings. The exit status is 1 when the request
+// fails and 2 on usage errors.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gophpeek/fcgx"
+	"github.com/gophpeek/fcgx/internal/cli"
+)
+
+type options struct {
+	cli.Request
+	timeout  time.Duration
+	json     bool
+	bodyOnly bool
+}
+
+// result is the -json output.
+type result struct {
+	Status     int         `json:"status,omitempty"`
+	Headers    http.Header `json:"headers,omitempty"`
+	Body       string      `json:"body"`
+	Stderr     string      `json:"stderr"`
+	EndRequest *endRequest `json:"end_request,omitempty"`
+	Timing     timing      `json:"timing"`
+	Error      string      `json:"error,omitempty"`
+
+	statusLine string
+}
+
+type endRequest struct {
+	AppStatus      uint32 `json:"app_status"`
+	ProtocolStatus string `json:"protocol_status"`
+}
+
+// timing holds durations in milliseconds since the request started.
+type timing struct {
+	Connect   float64 `json:"connect_ms"`
+	FirstByte float64 `json:"first_byte_ms,omitempty"`
+	Total     float64 `json:"total_ms"`
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var opts options
+	fs := flag.NewFlagSet("fcgx", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	opts.AddFlags(fs, "@file reads a file and @- reads stdin")
+	fs.DurationVar(&opts.timeout, "timeout", 30*time.Second, "overall timeout including the dial")
+	fs.BoolVar(&opts.json, "json", false, "write the response, stderr, END_REQUEST status and timing as JSON")
+	fs.BoolVar(&opts.bodyOnly, "body", false, "write only the response body to stdout")
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: fcgx [flags] address\n\naddress is unix:/path, tcp:host:port, /path or host:port\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	network, address := cli.ParseAddress(fs.Arg(0))
+	params, err := opts.BuildParams("fcgx")
+	if err != nil {
+		fmt.Fprintf(stderr, "fcgx: %v\n", err)
+		return 2
+	}
+	body, size, closeBody, err := openBody(opts.Data, stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "fcgx: %v\n", err)
+		return 2
+	}
+	defer closeBody()
+	if body != nil {
+		cli.SetDefault(params, "CONTENT_LENGTH", strconv.FormatInt(size, 10))
+		cli.SetDefault(params, "CONTENT_TYPE", "application/x-www-form-urlencoded")
+	} else {
+		cli.SetDefault(params, "CONTENT_LENGTH", "0")
+	}
+
+	var res result
+	var stderrBuf strings.Builder
+	start := time.Now()
+	since := func() float64 { return float64(time.Since(start)) / float64(time.Millisecond) }
+	trace := &fcgx.Trace{
+		ConnectDone: func(string, string, error) { res.Timing.Connect = since() },
+		FirstResponseByte: func() {
+			res.Timing.FirstByte = since()
+		},
+		StderrChunk: func(p []byte) {
+			if opts.json {
+				stderrBuf.Write(p)
+			} else {
+				stderr.Write(p)
+			}
+		},
+		EndRequest: func(info fcgx.EndRequestInfo) {
+			res.EndRequest = &endRequest{AppStatus: info.AppStatus, ProtocolStatus: info.ProtocolStatus.String()}
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.timeout)
+	defer cancel()
+	ctx = fcgx.WithTrace(ctx, trace)
+
+	respBody, err := do(ctx, network, address, params, body, &res)
+	res.Timing.Total = since()
+	res.Stderr = stderrBuf.String()
+
+	if opts.json {
+		res.Body = string(respBody)
+		if err != nil {
+			res.Error = err.Error()
+		}
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(res)
+	} else if err == nil {
+		if !opts.bodyOnly {
+			fmt.Fprintf(stdout, "Status: %s\r\n", res.statusLine)
+			res.Headers.Write(stdout)
+			io.WriteString(stdout, "\r\n")
+		}
+		stdout.Write(respBody)
+	}
+	if err != nil {
+		if !opts.json {
+			fmt.Fprintln(stderr, err)
+		}
+		return 1
+	}
+	return 0
+}
+
+// do sends the request and reads the whole response body.
+func do(ctx context.Context, network, address string, params map[string]string, body io.Reader, res *result) ([]byte, error) {
+	client, err := fcgx.DialContextWithConfig(ctx, network, address, fcgx.DefaultConfig())
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	resp, err := client.DoRequest(ctx, params, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	res.Status, res.statusLine, res.Headers = resp.StatusCode, resp.Status, resp.Header
+	return io.ReadAll(resp.Body)
+}
+
+// openBody returns the request body for -d and its size. Files and
+// redirected stdin are streamed; a pipe is read up front because FastCGI
+// needs CONTENT_LENGTH before the body.
+func openBody(data string, stdin io.Reader) (io.Reader, int64, func(), error) {
+	noop := func() {}
+	if data == "" {
+		return nil, 0, noop, nil
+	}
+	name, ok := strings.CutPrefix(data, "@")
+	if !ok {
+		return strings.NewReader(data), int64(len(data)), noop, nil
+	}
+
+	if name == "-" {
+		if f, ok := stdin.(*os.File); ok {
+			if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+				return f, fi.Size(), noop, nil
+			}
+		}
+		b, err := io.ReadAll(stdin)
+		if err != nil {
+			return nil, 0, noop, fmt.Errorf("reading stdin: %w", err)
+		}
+		return bytes.NewReader(b), int64(len(b)), noop, nil
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, 0, noop, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, noop, err
+	}
+	if !fi.Mode().IsRegular() {
+		f.Close()
+		return nil, 0, noop, errors.New(name + " is not a regular file")
+	}
+	return f, fi.Size(), func() { f.Close() }, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gophpeek/fcgx/internal/fcgitest"
+	"github.com/gophpeek/fcgx/protocol"
+)
+
+// serveFastCGI answers each request by echoing its stdin after writing a
+// line to FCGI_STDERR, and sends the params it received on seen.
+func serveFastCGI(t *testing.T, network string, seen chan<- map[string]string) string {
+	t.Helper()
+	handler := func(w io.Writer, req *fcgitest.Request) {
+		seen <- req.Params
+		fcgitest.WriteRecord(w, protocol.TypeStderr, req.ID, []byte("PHP Notice: echo\n"))
+		fcgitest.WriteRecord(w, protocol.TypeStdout, req.ID, append([]byte("Status: 201 Created\r\nX-Echo: 1\r\n\r\n"), req.Stdin...))
+		fcgitest.WriteEndRequest(w, req.ID, 3, protocol.StatusRequestComplete)
+	}
+	if network == "unix" {
+		return fcgitest.NewUnixServer(t, handler).Addr()
+	}
+	return fcgitest.NewServer(t, handler).Addr()
+}
+
+func TestRun(t *testing.T) {
+	seen := make(chan map[string]string, 1)
+	addr := serveFastCGI(t, "unix", seen)
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{
+		"-script", "/var/www/echo.php",
+		"-query", "a=1",
+		"-H", "X-Request-Id: abc",
+		"-H", "Content-Type: text/plain",
+		"-param", "DOCUMENT_ROOT=/var/www",
+		"-d", "@-",
+		addr,
+	}, strings.NewReader("hello"), &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("run exited %d: %s", code, stderr.String())
+	}
+
+	params := <-seen
+	want := map[string]string{
+		"REQUEST_METHOD":    "POST",
+		"SCRIPT_FILENAME":   "/var/www/echo.php",
+		"REQUEST_URI":       "/var/www/echo.php?a=1",
+		"QUERY_STRING":      "a=1",
+		"HTTP_X_REQUEST_ID": "abc",
+		"CONTENT_TYPE":      "text/plain",
+		"CONTENT_LENGTH":    "5",
+		"DOCUMENT_ROOT":     "/var/www",
+	}
+	for k, v := range want {
+		if params[k] != v {
+			t.Errorf("%s = %q, want %q", k, params[k], v)
+		}
+	}
+
+	if got := stdout.String(); !strings.HasPrefix(got, "Status: 201 Created\r\n") || !strings.HasSuffix(got, "\r\n\r\nhello") || !strings.Contains(got, "X-Echo: 1") {
+		t.Errorf("Unexpected stdout %q", got)
+	}
+	if stderr.String() != "PHP Notice: echo\n" {
+		t.Errorf("Unexpected stderr %q", stderr.String())
+	}
+}
+
+func TestRunJSON(t *testing.T) {
+	seen := make(chan map[string]string, 1)
+	addr := serveFastCGI(t, "tcp", seen)
+
+	body := filepath.Join(t.TempDir(), "body.json")
+	if err := os.WriteFile(body, []byte(`{"a":1}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-json", "-method", "put", "-script", "/echo.php", "-d", "@" + body, "tcp:" + addr}, nil, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("run exited %d: %s", code, stderr.String())
+	}
+	if params := <-seen; params["REQUEST_METHOD"] != "PUT" || params["CONTENT_LENGTH"] != "7" {
+		t.Errorf("Unexpected params %v", params)
+	}
+
+	var res result
+	if err := json.Unmarshal(stdout.Bytes(), &res); err != nil {
+		t.Fatalf("decoding output %q: %v", stdout.String(), err)
+	}
+	if res.Status != 201 || res.Body != `{"a":1}` || res.Stderr != "PHP Notice: echo\n" || res.Headers.Get("X-Echo") != "1" {
+		t.Errorf("Unexpected result %+v", res)
+	}
+	if res.EndRequest == nil || res.EndRequest.AppStatus != 3 || res.EndRequest.ProtocolStatus != "FCGI_REQUEST_COMPLETE" {
+		t.Errorf("Unexpected END_REQUEST %+v", res.EndRequest)
+	}
+	if res.Timing.Total <= 0 || res.Timing.FirstByte <= 0 || res.Timing.FirstByte > res.Timing.Total {
+		t.Errorf("Unexpected timing %+v", res.Timing)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"-script", "/x.php"}, nil, &stdout, &stderr); code != 2 {
+		t.Errorf("Expected usage error without address, got %d", code)
+	}
+	if code := run([]string{"-H", "broken", "127.0.0.1:1"}, nil, &stdout, &stderr); code != 2 {
+		t.Errorf("Expected usage error for invalid header, got %d", code)
+	}
+
+	// A dead address is reported in the JSON output
+	addr := fcgitest.DeadAddr(t)
+	stdout.Reset()
+	if code := run([]string{"-json", addr}, nil, &stdout, &stderr); code != 1 {
+		t.Errorf("Expected request failure, got %d", code)
+	}
+	var res result
+	if err := json.Unmarshal(stdout.Bytes(), &res); err != nil || !strings.Contains(res.Error, "connect") {
+		t.Errorf("Expected connect error in output, got %q", stdout.String())
+	}
+}
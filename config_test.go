@@ -1,6 +1,7 @@
 package fcgx
 
 import (
+	"errors"
 	"testing"
 	"time"
 )
@@ -54,28 +55,30 @@ func TestWrapWithContext(t *testing.T) {
 	kindErr := ErrTimeout
 
 	// Test with empty context
-	err1 := wrapWithContext(baseErr, kindErr, "test message", nil)
+	err1 := wrap(baseErr, kindErr, "test message")
 	expected1 := "fcgx: timeout: test message: base error"
 	if err1.Error() != expected1 {
 		t.Errorf("Expected %q, got %q", expected1, err1.Error())
 	}
 
-	// Test with context
-	context := map[string]interface{}{
-		"reqID":    42,
-		"deadline": "2024-01-01T12:00:00Z",
+	// Test with the request context a client adds
+	client := &Client{address: "127.0.0.1:9000", reqID: 42}
+	err2 := client.requestError(err1, map[string]string{"SCRIPT_NAME": "/index.php"})
+	expected2 := "fcgx: timeout: test message (addr=127.0.0.1:9000 reqID=42 script=/index.php): base error"
+	if err2.Error() != expected2 {
+		t.Errorf("Expected %q, got %q", expected2, err2.Error())
 	}
-	err2 := wrapWithContext(baseErr, kindErr, "test message", context)
-	// The exact order of context items may vary due to map iteration
-	result := err2.Error()
-	if !contains(result, "fcgx: timeout: test message") {
-		t.Errorf("Error should contain base message, got %q", result)
+	if !errors.Is(err2, ErrTimeout) || !errors.Is(err2, baseErr) {
+		t.Error("Expected error to match its kind and underlying error")
 	}
-	if !contains(result, "reqID=42") {
-		t.Errorf("Error should contain reqID context, got %q", result)
+	if err1.Error() != expected1 {
+		t.Errorf("Expected the wrapped error to be left alone, got %q", err1.Error())
 	}
-	if !contains(result, "deadline=2024-01-01T12:00:00Z") {
-		t.Errorf("Error should contain deadline context, got %q", result)
+
+	// Context already set is kept
+	err3 := client.requestError(&Error{Op: "test message", Kind: kindErr, Addr: "backend:9000"}, nil)
+	if !contains(err3.Error(), "addr=backend:9000 reqID=42") {
+		t.Errorf("Expected existing address to be kept, got %q", err3.Error())
 	}
 }
 
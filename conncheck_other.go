@@ -0,0 +1,10 @@
+//go:build !unix
+
+package fcgx
+
+import "net"
+
+// connCheck is a no-op on platforms without non-blocking raw socket reads.
+func connCheck(conn net.Conn) error {
+	return nil
+}
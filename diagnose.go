@@ -0,0 +1,269 @@
+package fcgx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// diagnoseTimeout limits the dial Diagnose makes to check for a listener.
+const diagnoseTimeout = time.Second
+
+// Diagnosis reports why a FastCGI server can or cannot be reached. For unix
+// sockets it covers the socket file, its permissions against the current
+// process, and the directories leading to it.
+//
+// A Diagnosis is also attached to the ErrConnect error of a failed unix
+// socket dial, and can be retrieved with errors.As:
+//
+//	var d *fcgx.Diagnosis
+//	if errors.As(err, &d) {
+//		for _, p := range d.Problems {
+//			log.Print(p)
+//		}
+//	}
+type Diagnosis struct {
+	Network string
+	Address string
+
+	// Socket file; unix sockets only
+	Exists   bool        // The path exists
+	IsSocket bool        // The path is a socket
+	Mode     fs.FileMode // Type and permission bits
+	UID      int         // Owner, or -1 when unknown
+	GID      int         // Group, or -1 when unknown
+	Owner    string      // Owner name, if it could be resolved
+	Group    string      // Group name, if it could be resolved
+
+	// The current process
+	ProcessUID  int   // Effective user ID, or -1 when unknown
+	ProcessGIDs []int // Effective and supplementary group IDs
+
+	// Permitted reports whether the process has the write permission on the
+	// socket that connecting requires. It is true when that is unknown.
+	Permitted bool
+
+	// BlockedDir is a directory on the socket's path the process cannot
+	// search, which also makes connecting fail with permission denied.
+	BlockedDir string
+
+	// Listening reports whether a connection could be established.
+	Listening bool
+
+	// Err is the dial error the diagnosis explains, or nil.
+	Err error
+
+	// Problems describes every problem found, most fundamental first.
+	Problems []string
+}
+
+// Diagnose inspects network and address to explain a failed dial. For a unix
+// socket it checks that the path exists and is a socket, whether its mode and
+// ownership let the current process connect, and whether anyone is listening.
+// For TCP it only checks whether anyone is listening. Dialing uses a timeout
+// of one second.
+func Diagnose(network, address string) *Diagnosis {
+	conn, err := net.DialTimeout(network, address, diagnoseTimeout)
+	if err == nil {
+		conn.Close()
+	}
+	return diagnose(network, address, err)
+}
+
+// diagnose builds the Diagnosis of a dial to address that returned err.
+func diagnose(network, address string, err error) *Diagnosis {
+	d := &Diagnosis{
+		Network:   network,
+		Address:   address,
+		UID:       -1,
+		GID:       -1,
+		Permitted: true,
+		Listening: err == nil,
+		Err:       err,
+	}
+	d.ProcessUID, d.ProcessGIDs = processIDs()
+	if isUnixNetwork(network) && !isAbstractSocket(address) {
+		d.inspectSocket()
+	}
+
+	switch {
+	case err == nil || len(d.Problems) > 0:
+		// A listener, or a reason it cannot be reached, was found
+	case errors.Is(err, syscall.ECONNREFUSED):
+		if isUnixNetwork(network) {
+			d.Problems = append(d.Problems, fmt.Sprintf(
+				"nothing is listening on %s; the socket is stale or PHP-FPM is not running", address))
+		} else {
+			d.Problems = append(d.Problems, fmt.Sprintf("nothing is listening on %s", address))
+		}
+	case isTimeout(err):
+		d.Problems = append(d.Problems, fmt.Sprintf(
+			"%s did not accept the connection in time; the listen backlog may be full or a firewall is dropping packets", address))
+	}
+	return d
+}
+
+// inspectSocket checks the socket file at d.Address.
+func (d *Diagnosis) inspectSocket() {
+	path := d.Address
+	fi, err := os.Stat(path)
+	if err != nil {
+		d.inspectDirs(filepath.Dir(path))
+		if errors.Is(err, fs.ErrNotExist) {
+			dir := filepath.Dir(path)
+			if _, derr := os.Stat(dir); errors.Is(derr, fs.ErrNotExist) {
+				d.Problems = append(d.Problems, fmt.Sprintf(
+					"directory %s does not exist; PHP-FPM is not running or listens elsewhere", dir))
+			} else if d.BlockedDir == "" {
+				d.Problems = append(d.Problems, fmt.Sprintf(
+					"socket %s does not exist; PHP-FPM is not running or listens elsewhere", path))
+			}
+		}
+		return
+	}
+
+	d.Exists = true
+	d.Mode = fi.Mode()
+	d.IsSocket = fi.Mode()&fs.ModeSocket != 0
+	if uid, gid, ok := fileOwner(fi); ok {
+		d.UID, d.GID = uid, gid
+		if u, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+			d.Owner = u.Username
+		}
+		if g, err := user.LookupGroupId(strconv.Itoa(gid)); err == nil {
+			d.Group = g.Name
+		}
+	}
+	d.inspectDirs(filepath.Dir(path))
+	if !d.IsSocket {
+		d.Problems = append(d.Problems, fmt.Sprintf("%s is not a socket (mode %s)", path, d.Mode))
+		return
+	}
+
+	if d.UID >= 0 && d.ProcessUID >= 0 {
+		d.Permitted = d.allows(d.Mode.Perm(), d.UID, d.GID, 2)
+	}
+	if !d.Permitted {
+		d.Problems = append(d.Problems, fmt.Sprintf(
+			"uid %d has no write permission on %s (mode %s, owner %s, group %s); "+
+				"run as the owner, join the group, or adjust listen.owner, listen.group and listen.mode",
+			d.ProcessUID, path, d.Mode, d.ownerName(), d.groupName()))
+	}
+}
+
+// inspectDirs finds the first directory from the root down to dir that the
+// process cannot search.
+func (d *Diagnosis) inspectDirs(dir string) {
+	if d.ProcessUID < 0 {
+		return
+	}
+	var dirs []string
+	for {
+		dirs = append(dirs, dir)
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		fi, err := os.Stat(dirs[i])
+		if err != nil {
+			return
+		}
+		uid, gid, ok := fileOwner(fi)
+		if !ok {
+			return
+		}
+		if !d.allows(fi.Mode().Perm(), uid, gid, 1) {
+			d.BlockedDir = dirs[i]
+			d.Problems = append(d.Problems, fmt.Sprintf(
+				"uid %d cannot search directory %s (mode %s)", d.ProcessUID, dirs[i], fi.Mode()))
+			return
+		}
+	}
+}
+
+// allows reports whether the process is granted bit (4 read, 2 write,
+// 1 execute) by perm on a file owned by uid and gid.
+func (d *Diagnosis) allows(perm fs.FileMode, uid, gid int, bit fs.FileMode) bool {
+	switch {
+	case d.ProcessUID == 0:
+		return true
+	case d.ProcessUID == uid:
+		return perm&(bit<<6) != 0
+	case d.inGroup(gid):
+		return perm&(bit<<3) != 0
+	default:
+		return perm&bit != 0
+	}
+}
+
+func (d *Diagnosis) inGroup(gid int) bool {
+	for _, g := range d.ProcessGIDs {
+		if g == gid {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Diagnosis) ownerName() string {
+	if d.Owner != "" {
+		return d.Owner
+	}
+	return strconv.Itoa(d.UID)
+}
+
+func (d *Diagnosis) groupName() string {
+	if d.Group != "" {
+		return d.Group
+	}
+	return strconv.Itoa(d.GID)
+}
+
+// Error returns the dial error followed by the problems found, so a
+// Diagnosis can stand in for the error it explains.
+func (d *Diagnosis) Error() string {
+	msg := "no problems found"
+	if len(d.Problems) > 0 {
+		msg = strings.Join(d.Problems, "; ")
+	}
+	if d.Err == nil {
+		return msg
+	}
+	return d.Err.Error() + " (" + msg + ")"
+}
+
+// Unwrap returns the dial error.
+func (d *Diagnosis) Unwrap() error {
+	return d.Err
+}
+
+// explainDial attaches a Diagnosis to a failed unix socket dial. Cancelled
+// dials are left alone since they say nothing about the socket.
+func explainDial(ctx context.Context, network, address string, err error) error {
+	if !isUnixNetwork(network) || ctx.Err() != nil {
+		return err
+	}
+	return diagnose(network, address, err)
+}
+
+func isUnixNetwork(network string) bool {
+	return network == "unix" || network == "unixpacket"
+}
+
+// isAbstractSocket reports whether address is in the Linux abstract socket
+// namespace, which has no file to inspect.
+func isAbstractSocket(address string) bool {
+	return strings.HasPrefix(address, "@") || strings.HasPrefix(address, "\x00")
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package fcgx
+
+import "io/fs"
+
+// fileOwner is not supported on platforms without Unix ownership.
+func fileOwner(fi fs.FileInfo) (uid, gid int, ok bool) {
+	return -1, -1, false
+}
+
+// processIDs is not supported on platforms without Unix ownership.
+func processIDs() (uid int, gids []int) {
+	return -1, nil
+}
@@ -0,0 +1,113 @@
+//go:build unix
+
+package fcgx
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func hasProblem(d *Diagnosis, substr string) bool {
+	for _, p := range d.Problems {
+		if strings.Contains(p, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDiagnoseUnix(t *testing.T) {
+	dir := t.TempDir()
+
+	listening := filepath.Join(dir, "up.sock")
+	ln, err := net.Listen("unix", listening)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if d := Diagnose("unix", listening); !d.Listening || !d.Exists || !d.IsSocket || !d.Permitted || len(d.Problems) != 0 {
+		t.Errorf("Unexpected diagnosis of a listening socket %+v", d)
+	}
+
+	stale := filepath.Join(dir, "stale.sock")
+	sln, err := net.Listen("unix", stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sln.(*net.UnixListener).SetUnlinkOnClose(false)
+	sln.Close()
+	if d := Diagnose("unix", stale); d.Listening || !d.IsSocket || !hasProblem(d, "nothing is listening") {
+		t.Errorf("Unexpected diagnosis of a stale socket %+v", d)
+	}
+
+	regular := filepath.Join(dir, "file")
+	if err := os.WriteFile(regular, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if d := Diagnose("unix", regular); d.IsSocket || !d.Exists || !hasProblem(d, "is not a socket") {
+		t.Errorf("Unexpected diagnosis of a regular file %+v", d)
+	}
+
+	if d := Diagnose("unix", filepath.Join(dir, "missing.sock")); d.Exists || !hasProblem(d, "missing.sock does not exist") {
+		t.Errorf("Unexpected diagnosis of a missing socket %+v", d)
+	}
+	if d := Diagnose("unix", filepath.Join(dir, "gone", "php.sock")); !hasProblem(d, "gone does not exist") {
+		t.Errorf("Unexpected diagnosis of a missing directory %+v", d)
+	}
+}
+
+func TestDiagnosePermissions(t *testing.T) {
+	d := &Diagnosis{ProcessUID: 1000, ProcessGIDs: []int{1000, 33}}
+	tests := []struct {
+		perm     os.FileMode
+		uid, gid int
+		want     bool
+	}{
+		{0o600, 1000, 0, true},
+		{0o060, 1000, 33, false}, // The owner bits apply to the owner
+		{0o660, 0, 33, true},
+		{0o660, 0, 0, false},
+		{0o666, 0, 0, true},
+	}
+	for _, tt := range tests {
+		if got := d.allows(tt.perm, tt.uid, tt.gid, 2); got != tt.want {
+			t.Errorf("allows(%v, %d, %d) = %v, want %v", tt.perm, tt.uid, tt.gid, got, tt.want)
+		}
+	}
+	root := &Diagnosis{ProcessUID: 0}
+	if !root.allows(0, 1000, 1000, 2) {
+		t.Error("Expected root to be allowed")
+	}
+}
+
+func TestDialDiagnosis(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "php-fpm.sock")
+	_, err := DialContext(context.Background(), "unix", path)
+
+	var d *Diagnosis
+	if !errors.Is(err, ErrConnect) || !errors.As(err, &d) || !errors.Is(err, syscall.ENOENT) {
+		t.Fatalf("Expected connect error with diagnosis, got %v", err)
+	}
+	if d.Address != path || !strings.Contains(err.Error(), "php-fpm.sock does not exist") {
+		t.Errorf("Expected diagnosis in the error message, got %v", err)
+	}
+
+	// TCP dial errors are not diagnosed
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	if _, err := Dial("tcp", ln.Addr().String()); errors.As(err, &d) {
+		t.Errorf("Expected no diagnosis for TCP, got %v", d)
+	}
+	if d := Diagnose("tcp", ln.Addr().String()); d.Listening || !hasProblem(d, "nothing is listening") {
+		t.Errorf("Unexpected diagnosis of a closed port %+v", d)
+	}
+}
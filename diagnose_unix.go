@@ -0,0 +1,25 @@
+//go:build unix
+
+package fcgx
+
+import (
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// fileOwner returns the owner and group of a file.
+func fileOwner(fi fs.FileInfo) (uid, gid int, ok bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return -1, -1, false
+	}
+	return int(st.Uid), int(st.Gid), true
+}
+
+// processIDs returns the effective user ID and the effective and
+// supplementary group IDs of the process.
+func processIDs() (uid int, gids []int) {
+	gids, _ = os.Getgroups()
+	return os.Geteuid(), append(gids, os.Getegid())
+}
@@ -0,0 +1,142 @@
+package fcgx
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gophpeek/fcgx/internal/fcgitest"
+)
+
+func TestNewClient(t *testing.T) {
+	clientEnd, serverEnd := net.Pipe()
+	go fcgitest.ServeConn(serverEnd, fcgitest.RespondWith("Content-Type: text/plain\r\n\r\npiped"))
+
+	client := NewClient(clientEnd, nil)
+	defer client.Close()
+	resp, err := client.Get(context.Background(), map[string]string{"SCRIPT_FILENAME": "/index.php"})
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if body, _ := ReadBody(resp); string(body) != "piped" {
+		t.Errorf("Expected body piped, got %q", body)
+	}
+}
+
+func TestConfigDialer(t *testing.T) {
+	handler := fcgitest.RespondWith("Content-Type: text/plain\r\n\r\nok")
+	var dialed []string
+	config := DefaultConfig()
+	config.Dialer = func(ctx context.Context, network, address string) (net.Conn, error) {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("Expected dial context to carry ConnectTimeout")
+		}
+		dialed = append(dialed, network+" "+address)
+		if address == "down" {
+			return nil, errors.New("no route")
+		}
+		clientEnd, serverEnd := net.Pipe()
+		go fcgitest.ServeConn(serverEnd, handler)
+		return clientEnd, nil
+	}
+
+	client, err := DialWithConfig("memory", "php", config)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close()
+	resp, err := client.Get(context.Background(), map[string]string{"SCRIPT_FILENAME": "/index.php"})
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	resp.Body.Close()
+
+	_, err = DialWithConfig("memory", "down", config)
+	var fe *Error
+	if !errors.As(err, &fe) || fe.Kind != ErrConnect || fe.Addr != "down" || !strings.Contains(err.Error(), "no route") {
+		t.Errorf("Expected connect error from dialer, got %v", err)
+	}
+	if len(dialed) != 2 || dialed[0] != "memory php" {
+		t.Errorf("Unexpected dials %v", dialed)
+	}
+}
+
+// selfSignedCert returns a certificate for 127.0.0.1 and a pool trusting it.
+func selfSignedCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "fcgx test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+func TestConfigTLS(t *testing.T) {
+	cert, roots := selfSignedCert(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := fcgitest.Serve(t, tls.NewListener(ln, &tls.Config{Certificates: []tls.Certificate{cert}}),
+		fcgitest.RespondWith("Content-Type: text/plain\r\n\r\nsecure"))
+
+	config := DefaultConfig()
+	config.KeepConn = true
+	config.TLSConfig = &tls.Config{RootCAs: roots}
+	client, err := DialWithConfig("tcp", s.Addr(), config)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close()
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(context.Background(), map[string]string{"SCRIPT_FILENAME": "/index.php"})
+		if err != nil {
+			t.Fatalf("Get %d failed: %v", i, err)
+		}
+		if body, _ := ReadBody(resp); string(body) != "secure" {
+			t.Errorf("Expected body secure, got %q", body)
+		}
+	}
+	if n := s.Connections(); n != 1 {
+		t.Errorf("Expected the TLS connection to be reused, got %d connections", n)
+	}
+	if config.TLSConfig.ServerName != "" {
+		t.Error("Expected the caller's TLS config to be left alone")
+	}
+
+	// An untrusted certificate fails the dial
+	config.TLSConfig = &tls.Config{}
+	_, err = DialWithConfig("tcp", s.Addr(), config)
+	var certErr *tls.CertificateVerificationError
+	if !errors.Is(err, ErrConnect) || !errors.As(err, &certErr) {
+		t.Errorf("Expected certificate error, got %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+package fcgx
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Phase identifies the stage of a request in which an Error occurred.
+type Phase string
+
+const (
+	PhaseConnect  Phase = "connect"  // Dialing or redialing the server
+	PhaseBegin    Phase = "begin"    // Writing FCGI_BEGIN_REQUEST
+	PhaseParams   Phase = "params"   // Writing FCGI_PARAMS
+	PhaseStdin    Phase = "stdin"    // Reading the request body and writing FCGI_STDIN
+	PhaseResponse Phase = "response" // Reading records until FCGI_END_REQUEST
+	PhaseParse    Phase = "parse"    // Parsing the CGI response headers
+)
+
+// Error is the error type returned by fcgx. It carries the request context
+// known when the error occurred, and matches its Kind with errors.Is:
+//
+//	var fe *fcgx.Error
+//	if errors.As(err, &fe) && errors.Is(err, fcgx.ErrTimeout) {
+//		log.Printf("%s timed out in phase %s", fe.Script, fe.Phase)
+//	}
+type Error struct {
+	Op        string // What was being done, e.g. "reading response header"
+	Kind      error  // Sentinel error classifying the failure, e.g. ErrTimeout
+	Phase     Phase  // Request stage; empty outside a request
+	Addr      string // Server address, if known
+	RequestID uint16 // FastCGI request ID; zero outside a request
+	Script    string // SCRIPT_NAME, or SCRIPT_FILENAME, of the request
+	Err       error  // Underlying error, or nil
+}
+
+// wrap builds an Error of the given kind around err.
+func wrap(err, kind error, op string) *Error {
+	return &Error{Op: op, Kind: kind, Err: err}
+}
+
+// wrapPhase builds an Error of the given kind around err in a request phase.
+func wrapPhase(err, kind error, phase Phase, op string) *Error {
+	return &Error{Op: op, Kind: kind, Phase: phase, Err: err}
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	if e.Kind != nil {
+		b.WriteString(e.Kind.Error())
+		b.WriteString(": ")
+	}
+	b.WriteString(e.Op)
+
+	// Request context, in a fixed order
+	sep := " ("
+	field := func(name, value string) {
+		b.WriteString(sep)
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(value)
+		sep = " "
+	}
+	if e.Addr != "" {
+		field("addr", e.Addr)
+	}
+	if e.RequestID != 0 {
+		field("reqID", strconv.Itoa(int(e.RequestID)))
+	}
+	if e.Script != "" {
+		field("script", e.Script)
+	}
+	if sep == " " {
+		b.WriteByte(')')
+	}
+
+	if e.Err != nil {
+		b.WriteString(": ")
+		b.WriteString(e.Err.Error())
+	}
+	return b.String()
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the sentinel classifying e.
+func (e *Error) Is(target error) bool {
+	return e.Kind != nil && target == e.Kind
+}
+
+// LogValue implements slog.LogValuer so the error is logged as attributes.
+func (e *Error) LogValue() slog.Value {
+	attrs := make([]slog.Attr, 0, 7)
+	attrs = append(attrs, slog.String("msg", e.Error()))
+	if e.Kind != nil {
+		attrs = append(attrs, slog.String("kind", e.Kind.Error()))
+	}
+	attrs = append(attrs, slog.String("op", e.Op))
+	if e.Phase != "" {
+		attrs = append(attrs, slog.String("phase", string(e.Phase)))
+	}
+	if e.Addr != "" {
+		attrs = append(attrs, slog.String("addr", e.Addr))
+	}
+	if e.RequestID != 0 {
+		attrs = append(attrs, slog.Int("reqID", int(e.RequestID)))
+	}
+	if e.Script != "" {
+		attrs = append(attrs, slog.String("script", e.Script))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// isTimeout reports whether err is a timeout: an fcgx timeout, a network
+// timeout, or an expired deadline.
+func isTimeout(err error) bool {
+	if errors.Is(err, ErrTimeout) ||
+		errors.Is(err, os.ErrDeadlineExceeded) ||
+		errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// isEOF reports whether err means the stream ended, cleanly or mid-record.
+func isEOF(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,125 @@
+package fcgx
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gophpeek/fcgx/internal/fcgitest"
+	"github.com/gophpeek/fcgx/protocol"
+)
+
+func TestErrorFormat(t *testing.T) {
+	baseErr := &testError{msg: "base error"}
+
+	err1 := wrap(baseErr, ErrTimeout, "test message")
+	expected1 := "fcgx: timeout: test message: base error"
+	if err1.Error() != expected1 {
+		t.Errorf("Expected %q, got %q", expected1, err1.Error())
+	}
+
+	// Request context keeps a fixed order
+	err2 := &Error{Op: "test message", Kind: ErrTimeout, Phase: PhaseResponse, Script: "/index.php", RequestID: 1, Addr: "127.0.0.1:9000", Err: baseErr}
+	expected2 := "fcgx: timeout: test message (addr=127.0.0.1:9000 reqID=1 script=/index.php): base error"
+	if err2.Error() != expected2 {
+		t.Errorf("Expected %q, got %q", expected2, err2.Error())
+	}
+
+	err3 := wrap(nil, ErrOverloaded, "request rejected")
+	if err3.Error() != "fcgx: server overloaded: request rejected" {
+		t.Errorf("Unexpected message %q", err3.Error())
+	}
+
+	// Logged errors expose the context as attributes
+	var buf bytes.Buffer
+	slog.New(slog.NewTextHandler(&buf, nil)).Error("failed", "err", err2)
+	if !contains(buf.String(), "err.kind=\"fcgx: timeout\" err.op=\"test message\" err.phase=response err.addr=127.0.0.1:9000 err.reqID=1 err.script=/index.php") {
+		t.Errorf("Expected structured context in log output, got %q", buf.String())
+	}
+}
+
+func TestErrorIs(t *testing.T) {
+	err := fmt.Errorf("outer: %w", wrapPhase(io.ErrUnexpectedEOF, ErrUnexpectedEOF, PhaseResponse, "reading header"))
+	if !errors.Is(err, ErrUnexpectedEOF) || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Error("Expected error to match its kind and underlying error")
+	}
+	if errors.Is(err, ErrTimeout) {
+		t.Error("Expected error not to match other sentinels")
+	}
+
+	var fe *Error
+	if !errors.As(err, &fe) || fe.Phase != PhaseResponse {
+		t.Errorf("Expected *Error in phase response, got %+v", fe)
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "slow" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestErrorClassification(t *testing.T) {
+	tests := []struct {
+		err     error
+		timeout bool
+		eof     bool
+	}{
+		{os.ErrDeadlineExceeded, true, false},
+		{&net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}, true, false},
+		{context.DeadlineExceeded, true, false},
+		{timeoutError{}, true, false},
+		{wrap(errors.New("x"), ErrTimeout, "waiting"), true, false},
+		{io.EOF, false, true},
+		{fmt.Errorf("reading: %w", io.ErrUnexpectedEOF), false, true},
+		// Messages merely mentioning these words are not misclassified
+		{errors.New("script printed EOF"), false, false},
+		{errors.New("session timeout page"), false, false},
+	}
+	for _, tt := range tests {
+		if got := isTimeout(tt.err); got != tt.timeout {
+			t.Errorf("isTimeout(%v) = %v, want %v", tt.err, got, tt.timeout)
+		}
+		if got := isEOF(tt.err); got != tt.eof {
+			t.Errorf("isEOF(%v) = %v, want %v", tt.err, got, tt.eof)
+		}
+	}
+}
+
+func TestRequestErrorContext(t *testing.T) {
+	srv := fcgitest.NewServer(t, func(w io.Writer, req *fcgitest.Request) {
+		fcgitest.WriteEndRequest(w, req.ID, 0, protocol.StatusOverloaded)
+	})
+	client, err := Dial("tcp", srv.Addr())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	params := testParams()
+	params["SCRIPT_NAME"] = "/busy.php"
+	_, err = client.Get(ctx, params)
+
+	var fe *Error
+	if !errors.As(err, &fe) || !errors.Is(err, ErrOverloaded) {
+		t.Fatalf("Expected overloaded *Error, got %v", err)
+	}
+	if fe.Addr != srv.Addr() || fe.RequestID != 1 || fe.Script != "/busy.php" || fe.Phase != PhaseResponse {
+		t.Errorf("Unexpected error context %+v", fe)
+	}
+
+	// A dead address reports the connect phase
+	_, err = DialContext(ctx, "tcp", fcgitest.DeadAddr(t))
+	if !errors.As(err, &fe) || fe.Kind != ErrConnect || fe.Phase != PhaseConnect || fe.Addr == "" {
+		t.Errorf("Unexpected dial error %+v", fe)
+	}
+}
@@ -40,11 +40,12 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"encoding/binary"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/http/httputil"
@@ -53,6 +54,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/gophpeek/fcgx/protocol"
 )
 
 var bufferPool = sync.Pool{
@@ -61,6 +64,12 @@ var bufferPool = sync.Pool{
 	},
 }
 
+var readerPool = sync.Pool{
+	New: func() interface{} {
+		return bufio.NewReader(nil)
+	},
+}
+
 var (
 	ErrClientClosed     = errors.New("fcgx: client closed")
 	ErrTimeout          = errors.New("fcgx: timeout")
@@ -71,22 +80,143 @@ var (
 	ErrConnect          = errors.New("fcgx: connect error")
 	ErrWrite            = errors.New("fcgx: write error")
 	ErrRead             = errors.New("fcgx: read error")
+
+	// ErrConnClosedByServer is returned when the server has closed, or is about to
+	// close, the connection, or an earlier failure left it unusable, and the
+	// client is not configured to redial. The error wraps the original cause.
+	ErrConnClosedByServer = errors.New("fcgx: connection closed by server")
+
+	// ErrProtocol is returned when the server violates the FastCGI protocol,
+	// for example by answering with FCGI_UNKNOWN_TYPE.
+	ErrProtocol = errors.New("fcgx: protocol error")
+
+	// ErrOverloaded is returned when the server rejects a request with
+	// FCGI_OVERLOADED in its FCGI_END_REQUEST record.
+	ErrOverloaded = errors.New("fcgx: server overloaded")
+
+	// ErrInvalidParams is returned when Config.ParamPolicy rejects the params
+	// of a request. Nothing is sent to the server.
+	ErrInvalidParams = errors.New("fcgx: invalid params")
 )
 
+// UnknownTypeError is decoded from an FCGI_UNKNOWN_TYPE record, sent by the
+// server when it does not understand a record type the client sent.
+type UnknownTypeError struct {
+	Type protocol.RecordType // The record type the server did not recognize
+}
+
+func (e *UnknownTypeError) Error() string {
+	return fmt.Sprintf("server does not understand record type %s", e.Type)
+}
+
 // Config holds configuration options for FastCGI client behavior.
 // Zero values provide sensible defaults for most use cases.
 type Config struct {
 	// MaxWriteSize controls the maximum size of data chunks sent to the FastCGI server.
+	// The request body is read and sent one chunk at a time.
 	// Default: 65500 bytes (slightly under 64KB for protocol safety)
 	MaxWriteSize int
 
-	// ConnectTimeout sets the timeout for establishing initial connections.
+	// ConnectTimeout sets the timeout for establishing initial connections,
+	// including the TLS handshake.
 	// Default: 5 seconds
 	ConnectTimeout time.Duration
 
+	// Dialer, if set, establishes connections instead of a net.Dialer. Use it
+	// for SOCKS proxies, source address binding, keepalive tuning or
+	// in-memory connections in tests. It is called with a context limited by
+	// ConnectTimeout.
+	// Default: nil
+	Dialer func(ctx context.Context, network, address string) (net.Conn, error)
+
+	// TLSConfig, if set, makes the client speak TLS on every connection, for
+	// FastCGI servers behind stunnel or a TLS sidecar. An empty ServerName is
+	// taken from the host of a TCP address.
+	// Default: nil
+	TLSConfig *tls.Config
+
 	// RequestTimeout sets a default timeout for requests when context has no deadline.
+	// Zero means no overall timeout.
 	// Default: 30 seconds
 	RequestTimeout time.Duration
+
+	// WriteTimeout limits how long sending the request, including its body,
+	// may take. It never extends the overall deadline.
+	// Default: 0 (no limit)
+	WriteTimeout time.Duration
+
+	// FirstByteTimeout limits how long the server may take, once the request
+	// is sent, before the first FCGI_STDOUT content arrives.
+	// Default: 0 (no limit)
+	FirstByteTimeout time.Duration
+
+	// ReadIdleTimeout limits the gap between FCGI_STDOUT records once the
+	// response has started. It is reset on each record, so long-running
+	// streaming responses only fail when they stall.
+	// Default: 0 (no limit)
+	ReadIdleTimeout time.Duration
+
+	// KeepConn sets FCGI_KEEP_CONN on every request so the server keeps the
+	// connection open after FCGI_END_REQUEST and the client can be reused.
+	// Without it PHP-FPM closes the connection after each request.
+	// Default: false
+	KeepConn bool
+
+	// ParamPolicy, if set, validates the params of every request before it
+	// is sent, rejecting header smuggling and httpoxy; see ParamPolicy.
+	// Default: nil
+	ParamPolicy *ParamPolicy
+
+	// HeaderPolicy, if set, sanitizes every response before it is returned,
+	// for responses forwarded to browsers; see HeaderPolicy.
+	// Default: nil
+	HeaderPolicy *HeaderPolicy
+
+	// StrictProtocol makes requests fail with ErrProtocol on protocol violations
+	// such as an unsupported version byte, unexpected record types or records
+	// for another request ID, instead of skipping those records.
+	// Default: false
+	StrictProtocol bool
+
+	// Retry retries failed requests and connection attempts. Nil disables
+	// retries; see DefaultRetryPolicy.
+	// Default: nil
+	Retry *RetryPolicy
+
+	// AutoRedial makes the client dial a fresh connection when the current one
+	// has been closed by the server, instead of returning ErrConnClosedByServer.
+	// Default: false
+	AutoRedial bool
+
+	// CircuitBreakers, if set, guards requests and dials with the breaker for
+	// the server's address. Share one instance between configs so all clients
+	// to an address trip together; see NewCircuitBreakers.
+	// Default: nil
+	CircuitBreakers *CircuitBreakers
+
+	// Logger receives connection events, protocol anomalies, FCGI_STDERR
+	// output and slow requests. Nil disables logging.
+	// Default: nil
+	Logger *slog.Logger
+
+	// SlowRequestThreshold logs requests that take at least this long at
+	// warning level and reports them to OnSlowRequest. Zero disables slow
+	// request detection.
+	// Default: 0
+	SlowRequestThreshold time.Duration
+
+	// OnSlowRequest, if set, is called with every request that took at least
+	// SlowRequestThreshold, on the calling goroutine before the request
+	// returns.
+	// Default: nil
+	OnSlowRequest func(SlowRequest)
+
+	// SlowRequestStatus, if set, fetches the PHP-FPM status page once a
+	// request has run for SlowRequestThreshold and passes the entry of the
+	// worker running it to OnSlowRequest. A slow request then waits up to
+	// the endpoint's Timeout for the snapshot before returning.
+	// Default: nil
+	SlowRequestStatus *StatusEndpoint
 }
 
 // DefaultConfig returns a Config with sensible defaults for most use cases
@@ -98,325 +228,506 @@ func DefaultConfig() *Config {
 	}
 }
 
-// wrap enhances errors with contextual information and error classification
-func wrap(err, kind error, msg string) error {
-	return fmt.Errorf("%w: %s: %v", kind, msg, err)
-}
-
-// wrapWithContext enhances errors with additional debugging context
-func wrapWithContext(err, kind error, msg string, context map[string]interface{}) error {
-	if len(context) == 0 {
-		return wrap(err, kind, msg)
-	}
-
-	var ctxParts []string
-	for k, v := range context {
-		ctxParts = append(ctxParts, fmt.Sprintf("%s=%v", k, v))
-	}
-	contextStr := strings.Join(ctxParts, " ")
-	return fmt.Errorf("%w: %s (%s): %v", kind, msg, contextStr, err)
-}
-
-// isTimeout checks if an error is timeout-related, including various timeout error types
-// that can be returned by the network layer or context cancellation
-func isTimeout(err error) bool {
-	return errors.Is(err, ErrTimeout) ||
-		strings.Contains(err.Error(), "timeout") ||
-		strings.Contains(err.Error(), "deadline exceeded") ||
-		(strings.Contains(err.Error(), "i/o timeout"))
-}
-
-// isEOF checks if an error indicates end-of-file, including EOF variations
-// that can occur during FastCGI protocol communication
-func isEOF(err error) bool {
-	return errors.Is(err, io.EOF) || strings.Contains(err.Error(), "EOF")
-}
-
 const (
 	// FastCGI protocol constants
-	FCGI_HEADER_LEN = 8 // FastCGI record header length in bytes
-	fcgiVersion1    = 1 // FastCGI protocol version
-
-	// FastCGI record types
-	fcgiBeginRequest = 1 // Begin request record
-	fcgiAbortRequest = 2 // Abort request record
-	fcgiEndRequest   = 3 // End request record
-	fcgiParams       = 4 // Parameters record
-	fcgiStdin        = 5 // STDIN data record
-	fcgiStdout       = 6 // STDOUT data record
-	fcgiStderr       = 7 // STDERR data record
-
-	// FastCGI application roles and status
-	fcgiResponder       = 1 // Responder role (handles HTTP requests)
-	fcgiRequestComplete = 0 // Request completed successfully
-
+	FCGI_HEADER_LEN = protocol.HeaderLen // FastCGI record header length in bytes
 )
 
-// header represents a FastCGI record header as defined in the FastCGI specification
-type header struct {
-	Version       uint8  // Protocol version (always 1)
-	Type          uint8  // Record type (FCGI_BEGIN_REQUEST, FCGI_PARAMS, etc.)
-	RequestID     uint16 // Request ID to multiplex multiple requests over one connection
-	ContentLength uint16 // Length of the content data that follows this header
-	PaddingLength uint8  // Number of padding bytes that follow the content
-	Reserved      uint8  // Reserved for future use (always 0)
-}
-
 // Client represents a FastCGI client connection.
 // It maintains state for communicating with a FastCGI server (typically PHP-FPM).
 // All methods are thread-safe and can be called concurrently.
 type Client struct {
-	conn   net.Conn     // Underlying network connection to FastCGI server
-	mu     sync.Mutex   // Protects concurrent access to client state
-	reqID  uint16       // Current request ID (incremented for each request)
-	closed bool         // Whether the client has been closed
-	buf    bytes.Buffer // Reusable buffer for building FastCGI records
-	config *Config      // Configuration options for this client
+	conn    net.Conn               // Underlying network connection to FastCGI server
+	mu      sync.Mutex             // Protects concurrent access to client state
+	reqMu   sync.Mutex             // Serializes requests sharing the connection
+	reqID   uint16                 // Current request ID (incremented for each request)
+	closed  bool                   // Whether the client has been closed
+	rw      *protocol.RecordWriter // Record writer on the current connection
+	rr      *protocol.RecordReader // Record reader on the current connection
+	config  *Config                // Configuration options for this client
+	network string                 // Network used to dial, kept for redialing
+	address string                 // Address used to dial, kept for redialing
+	connErr error                  // Why the current connection cannot carry another request
+	used    bool                   // Whether the current connection completed a request
+
+	deadline      time.Time    // Overall deadline of the current request; zero for none
+	deadlineLimit timeoutLimit // Limit behind deadline, for error messages
+	timeoutLimit  timeoutLimit // Limit behind the deadline currently set on the connection
+}
+
+// timeoutLimit names the limit behind a deadline. It is only formatted when
+// the deadline expires, keeping requests free of string building.
+type timeoutLimit struct {
+	name string
+	d    time.Duration
 }
 
-// writeRecord constructs and sends a FastCGI record to the server.
-// It handles proper header construction, padding calculation, and thread-safe transmission.
-func (c *Client) writeRecord(recType uint8, content []byte) error {
+func (l timeoutLimit) String() string {
+	switch {
+	case l.name == "":
+		return "timeout"
+	case l.d > 0:
+		return l.name + " " + l.d.String()
+	}
+	return l.name
+}
+
+// writeRecord sends a single FastCGI record for the current request.
+// The record writer handles header construction and padding.
+func (c *Client) writeRecord(recType protocol.RecordType, content []byte) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	return c.rw.WriteRecord(recType, c.reqID, content)
+}
 
-	c.buf.Reset()
-	contentLen := len(content)
-	padLen := uint8((8 - (contentLen % 8)) % 8)
+// writeBeginRequest sends a FCGI_BEGIN_REQUEST record to start a new request
+func (c *Client) writeBeginRequest(role protocol.Role, flags uint8) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rw.WriteBeginRequest(c.reqID, protocol.BeginRequestBody{Role: role, Flags: flags})
+}
 
-	h := header{
-		Version:       fcgiVersion1,
-		Type:          recType,
-		RequestID:     c.reqID,
-		ContentLength: uint16(contentLen),
-		PaddingLength: padLen,
-	}
+// writePairs encodes and sends name-value pairs, split across as many
+// records as needed. This is used for sending request parameters.
+func (c *Client) writePairs(recType protocol.RecordType, pairs map[string]string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rw.WritePairs(recType, c.reqID, pairs)
+}
 
-	if err := binary.Write(&c.buf, binary.BigEndian, h); err != nil {
-		return wrap(err, ErrWrite, "writing record header")
+// writeStdin streams body as FCGI_STDIN records of up to MaxWriteSize bytes,
+// reading one record's worth at a time so the body is never held in memory
+// as a whole. It does not write the terminating empty record.
+func (c *Client) writeStdin(ctx context.Context, body io.Reader) (int64, error) {
+	size := c.config.MaxWriteSize
+	if size <= 0 || size > protocol.MaxContentLen {
+		size = protocol.MaxContentLen
 	}
+	chunkBuf := bufferPool.Get().(*bytes.Buffer)
+	chunkBuf.Reset()
+	chunkBuf.Grow(size)
+	defer bufferPool.Put(chunkBuf)
+	chunk := chunkBuf.AvailableBuffer()[:size]
 
-	if contentLen > 0 {
-		c.buf.Write(content)
+	var total int64
+	for {
+		// Check context before each chunk
+		if err := ctx.Err(); err != nil {
+			return total, c.cancelError(err, PhaseStdin)
+		}
+
+		// Fill the chunk by hand: io.ReadFull would turn a body that fails
+		// with io.ErrUnexpectedEOF into a clean end
+		n := 0
+		var rerr error
+		for n < size && rerr == nil {
+			var m int
+			m, rerr = body.Read(chunk[n:])
+			n += m
+		}
+		if n > 0 {
+			c.mu.Lock()
+			err := c.rw.WriteStream(protocol.TypeStdin, c.reqID, chunk[:n], size)
+			c.mu.Unlock()
+			if err != nil {
+				return total, c.writeError(err, PhaseStdin, "writing stdin chunk")
+			}
+			total += int64(n)
+		}
+		switch rerr {
+		case nil:
+		case io.EOF:
+			return total, nil
+		default:
+			// The server is left waiting for the rest of the body
+			err := wrapPhase(rerr, ErrRead, PhaseStdin, "reading request body")
+			c.discardConn(err)
+			return total, err
+		}
 	}
+}
 
-	if padLen > 0 {
-		c.buf.Write(make([]byte, padLen))
+// writeError classifies an error returned while writing records
+func (c *Client) writeError(err error, phase Phase, op string) *Error {
+	if isTimeout(err) {
+		return c.timeoutError(err, phase, op)
 	}
+	return wrapPhase(err, ErrWrite, phase, op)
+}
 
-	_, err := c.conn.Write(c.buf.Bytes())
-	if err != nil {
-		if isTimeout(err) {
-			return wrap(err, ErrTimeout, "timeout while writing record")
+// timeoutError builds an ErrTimeout error naming the limit that expired and
+// discards the connection, since a record may have been cut off midway.
+func (c *Client) timeoutError(err error, phase Phase, op string) *Error {
+	terr := wrapPhase(err, ErrTimeout, phase, c.timeoutLimit.String()+" exceeded while "+op)
+	c.discardConn(terr)
+	return terr
+}
+
+// cancelError builds an ErrContextCancelled error for a request abandoned
+// midway and discards the connection, since the server may still be reading
+// the request or sending its response.
+func (c *Client) cancelError(err error, phase Phase) *Error {
+	cerr := wrapPhase(err, ErrContextCancelled, phase, "context error")
+	c.discardConn(cerr)
+	return cerr
+}
+
+// setPhaseDeadline sets the read or write deadline for the next phase of the
+// current request: timeout from now, capped by the overall deadline.
+func (c *Client) setPhaseDeadline(set func(time.Time) error, timeout time.Duration, name string) error {
+	deadline, limit := c.deadline, c.deadlineLimit
+	if timeout > 0 {
+		if d := time.Now().Add(timeout); deadline.IsZero() || d.Before(deadline) {
+			deadline, limit = d, timeoutLimit{name, timeout}
 		}
-		return wrap(err, ErrWrite, "writing record")
 	}
-	return nil
+	c.timeoutLimit = limit
+	return set(deadline)
 }
 
-// writeBeginRequest sends a FCGI_BEGIN_REQUEST record to start a new request
-func (c *Client) writeBeginRequest(role uint16, flags uint8) error {
-	b := [8]byte{byte(role >> 8), byte(role), flags}
-	return c.writeRecord(fcgiBeginRequest, b[:])
-}
-
-// encodePair encodes a key-value pair in FastCGI name-value format.
-// It handles both short (< 128 bytes) and long (>= 128 bytes) length encoding
-// as specified in the FastCGI protocol.
-func encodePair(w *bytes.Buffer, k, v string) {
-	writeSize := func(size int) {
-		if size < 128 {
-			w.WriteByte(byte(size))
-		} else {
-			sz := uint32(size) | (1 << 31)
-			_ = binary.Write(w, binary.BigEndian, sz)
+// DoRequest sends a request with params and body and returns the response
+// built from its FCGI_STDOUT stream. FCGI_STDERR output, which PHP uses for
+// errors and notices, is not part of the response: it is passed to
+// Trace.StderrChunk and logged by Config.Logger, and otherwise dropped.
+func (c *Client) DoRequest(ctx context.Context, params map[string]string, body io.Reader) (*http.Response, error) {
+	if p := c.config.ParamPolicy; p != nil {
+		checked, err := p.Validate(params)
+		if err != nil {
+			if fe, ok := err.(*Error); ok {
+				fe.Addr, fe.Script = c.address, scriptName(params)
+			}
+			return nil, err
+		}
+		params = checked
+	}
+	if !c.config.Retry.enabled() {
+		return c.guardedRequest(ctx, params, body, false)
+	}
+
+	// Buffer the body so every attempt sends the same bytes
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = io.ReadAll(body); err != nil {
+			return nil, wrap(err, ErrRead, "reading request body")
 		}
 	}
-	writeSize(len(k))
-	writeSize(len(v))
-	w.WriteString(k)
-	w.WriteString(v)
+	return withRetry(ctx, c.config.Retry, params["REQUEST_METHOD"], func(attempt int) (*http.Response, error) {
+		var reqBody io.Reader
+		if body != nil {
+			reqBody = bytes.NewReader(data)
+		}
+		// A retry needs a fresh connection when the failed attempt left the
+		// current one unusable, whether or not AutoRedial is set
+		return c.guardedRequest(ctx, params, reqBody, attempt > 1)
+	})
 }
 
-// writePairs encodes and sends name-value pairs as a FastCGI record.
-// This is used for sending environment variables and request parameters.
-// It uses a buffer pool to reduce memory allocations.
-func (c *Client) writePairs(recType uint8, pairs map[string]string) error {
-	// Get a buffer from the pool to reduce allocations
-	w := bufferPool.Get().(*bytes.Buffer)
-	w.Reset()
-	defer bufferPool.Put(w)
+// guardedRequest runs a request attempt through the circuit breaker for the
+// client's address, failing fast with ErrCircuitOpen while it is open, and
+// logs and reports its outcome.
+func (c *Client) guardedRequest(ctx context.Context, params map[string]string, body io.Reader, redial bool) (*http.Response, error) {
+	var done func(*http.Response, error)
+	if cb := c.config.CircuitBreakers; cb != nil {
+		var err error
+		if done, err = cb.allow(breakerKey(c.network, c.address)); err != nil {
+			return nil, err
+		}
+	}
 
-	for k, v := range pairs {
-		encodePair(w, k, v)
+	start := time.Now()
+	var slow func(*http.Response, error)
+	if c.config.OnSlowRequest != nil && c.config.SlowRequestThreshold > 0 {
+		slow = c.watchSlowRequest(params, start)
+	}
+	resp, err := c.doRequest(ctx, params, body, redial)
+	if done != nil {
+		done(resp, err)
+	}
+	if slow != nil {
+		slow(resp, err)
+	}
+	if c.config.Logger != nil {
+		c.logRequest(ctx, params, time.Since(start), resp, err)
 	}
-	return c.writeRecord(recType, w.Bytes())
+	return resp, err
 }
 
-func (c *Client) DoRequest(ctx context.Context, params map[string]string, body io.Reader) (*http.Response, error) {
+// doRequest performs a single request attempt on the client's connection.
+func (c *Client) doRequest(ctx context.Context, params map[string]string, body io.Reader, redial bool) (_ *http.Response, err error) {
+	defer func() {
+		if err != nil {
+			err = c.requestError(err, params)
+		}
+	}()
+
 	// Check if context is already cancelled
 	if err := ctx.Err(); err != nil {
 		return nil, wrap(err, ErrContextCancelled, "context error")
 	}
 
+	c.reqMu.Lock()
+	defer c.reqMu.Unlock()
+
 	c.mu.Lock()
 	if c.closed {
 		c.mu.Unlock()
 		return nil, ErrClientClosed
 	}
+	// A fresh ID per request keeps records of an abandoned request from
+	// being taken for the next one's
+	c.reqID++
+	if c.reqID == protocol.NullRequestID {
+		c.reqID = 1
+	}
 	c.mu.Unlock()
 
-	// Set deadline from context
-	deadline, ok := ctx.Deadline()
-	if ok {
-		if err := c.conn.SetDeadline(deadline); err != nil {
-			return nil, wrapWithContext(err, ErrWrite, "setting deadline", map[string]interface{}{
-				"deadline": deadline.Format(time.RFC3339),
-				"reqID":    c.reqID,
-			})
-		}
-		// Reset deadline after request
-		defer func() { _ = c.conn.SetDeadline(time.Time{}) }()
+	if err := c.ensureConn(ctx, redial); err != nil {
+		return nil, err
+	}
+	trace := ContextTrace(ctx)
+	trace.gotConn(GotConnInfo{Conn: c.conn, Reused: c.used})
+
+	// The context deadline bounds the whole request, falling back to
+	// RequestTimeout. Each phase may set a tighter deadline on top.
+	if deadline, ok := ctx.Deadline(); ok {
+		c.deadline, c.deadlineLimit = deadline, timeoutLimit{name: "context deadline"}
+	} else if c.config.RequestTimeout > 0 {
+		c.deadline, c.deadlineLimit = time.Now().Add(c.config.RequestTimeout), timeoutLimit{"request timeout", c.config.RequestTimeout}
+	} else {
+		c.deadline, c.deadlineLimit = time.Time{}, timeoutLimit{}
 	}
+	if err := c.setPhaseDeadline(c.conn.SetWriteDeadline, c.config.WriteTimeout, "write timeout"); err != nil {
+		return nil, wrapPhase(err, ErrWrite, PhaseBegin, "setting write deadline")
+	}
+	// Reset deadlines after request
+	defer func() { _ = c.conn.SetDeadline(time.Time{}) }()
 
 	// BEGIN_REQUEST record
-	if err := c.writeBeginRequest(uint16(fcgiResponder), 0); err != nil {
-		return nil, wrap(err, ErrWrite, "writing begin request")
+	var flags uint8
+	if c.config.KeepConn {
+		flags = protocol.FlagKeepConn
+	}
+	if err := c.writeBeginRequest(protocol.RoleResponder, flags); err != nil {
+		return nil, c.writeError(err, PhaseBegin, "writing begin request")
 	}
 
 	// Check context after each major operation
 	if err := ctx.Err(); err != nil {
-		return nil, wrap(err, ErrContextCancelled, "context error")
+		return nil, c.cancelError(err, PhaseParams)
 	}
 
 	// PARAMS records
-	if err := c.writePairs(fcgiParams, params); err != nil {
-		return nil, wrap(err, ErrWrite, "writing params")
+	if err := c.writePairs(protocol.TypeParams, params); err != nil {
+		return nil, c.writeError(err, PhaseParams, "writing params")
 	}
 
 	// Send terminating empty PARAMS record
-	if err := c.writeRecord(fcgiParams, nil); err != nil {
-		return nil, wrap(err, ErrWrite, "writing empty params")
+	if err := c.writeRecord(protocol.TypeParams, nil); err != nil {
+		return nil, c.writeError(err, PhaseParams, "writing empty params")
 	}
+	trace.wroteParams()
 
 	// Check context after params
 	if err := ctx.Err(); err != nil {
-		return nil, wrap(err, ErrContextCancelled, "context error")
+		return nil, c.cancelError(err, PhaseStdin)
 	}
 
 	// STDIN records
+	var stdinBytes int64
 	if body != nil {
-		bodyBuf := bufferPool.Get().(*bytes.Buffer)
-		bodyBuf.Reset()
-		defer bufferPool.Put(bodyBuf)
-
-		if _, err := io.Copy(bodyBuf, body); err != nil {
-			return nil, wrap(err, ErrRead, "reading request body")
-		}
-		data := bodyBuf.Bytes()
-
-		total := len(data)
-		offset := 0
-		for offset < total {
-			// Check context before each chunk
-			if err := ctx.Err(); err != nil {
-				return nil, wrap(err, ErrContextCancelled, "context error")
-			}
-
-			chunkSize := total - offset
-			if chunkSize > c.config.MaxWriteSize {
-				chunkSize = c.config.MaxWriteSize
-			}
-			chunk := data[offset : offset+chunkSize]
-			if err := c.writeRecord(fcgiStdin, chunk); err != nil {
-				return nil, wrap(err, ErrWrite, "writing stdin chunk")
-			}
-			offset += chunkSize
+		n, err := c.writeStdin(ctx, body)
+		if err != nil {
+			return nil, err
 		}
+		stdinBytes = n
 	}
 
 	// Always send terminating empty STDIN record
-	if err := c.writeRecord(fcgiStdin, nil); err != nil {
-		return nil, wrap(err, ErrWrite, "writing empty stdin")
+	if err := c.writeRecord(protocol.TypeStdin, nil); err != nil {
+		return nil, c.writeError(err, PhaseStdin, "writing empty stdin")
 	}
+	trace.wroteStdin(stdinBytes)
 
-	// Read response - use buffer pool for better memory management
+	if err := c.setPhaseDeadline(c.conn.SetReadDeadline, c.config.FirstByteTimeout, "first byte timeout"); err != nil {
+		return nil, wrapPhase(err, ErrRead, PhaseResponse, "setting read deadline")
+	}
+
+	// Read response - use buffer pool for better memory management. The
+	// buffer backs the returned response body, so it only goes back to the
+	// pool when the body is closed.
 	respBuf := bufferPool.Get().(*bytes.Buffer)
 	respBuf.Reset()
-	defer bufferPool.Put(respBuf)
-	endRequestReceived := false
+	returned := false
+	var endReq protocol.EndRequestBody
+	var stdoutBytes, stderrBytes int64
+	defer func() {
+		if !returned {
+			bufferPool.Put(respBuf)
+		}
+	}()
 
+readLoop:
 	for {
 		// Check context before each read
 		if err := ctx.Err(); err != nil {
-			return nil, wrap(err, ErrContextCancelled, "context error")
+			return nil, c.cancelError(err, PhaseResponse)
 		}
 
-		h := header{}
-		if err := binary.Read(c.conn, binary.BigEndian, &h); err != nil {
+		h, err := c.rr.ReadHeader()
+		if err != nil {
 			if isEOF(err) {
-				if respBuf.Len() > 0 && endRequestReceived {
-					break
-				}
-				return nil, wrap(err, ErrUnexpectedEOF, "unexpected EOF while reading header")
+				c.markConnClosed(err)
+				return nil, wrapPhase(err, ErrUnexpectedEOF, PhaseResponse, "unexpected EOF while reading header")
 			}
 			if isTimeout(err) {
-				return nil, wrap(err, ErrTimeout, "timeout while reading header")
+				return nil, c.timeoutError(err, PhaseResponse, "reading header")
 			}
-			return nil, wrap(err, ErrRead, "reading response header")
+			return nil, wrapPhase(err, ErrRead, PhaseResponse, "reading response header")
+		}
+		if h.Version != protocol.Version1 && c.config.StrictProtocol {
+			return nil, c.protocolError(fmt.Errorf("unsupported protocol version %d", h.Version), h)
 		}
 
-		if h.Type == fcgiStdout || h.Type == fcgiStderr {
-			b := make([]byte, h.ContentLength)
-			if _, err := io.ReadFull(c.conn, b); err != nil {
-				if isTimeout(err) {
-					return nil, wrap(err, ErrTimeout, "timeout while reading response body")
-				}
-				return nil, wrap(err, ErrRead, "reading response body")
+		// Every record is consumed in full so the stream stays in sync,
+		// whether or not its type is understood.
+		content, err := c.rr.ReadContent(h)
+		if err != nil {
+			if isTimeout(err) {
+				return nil, c.timeoutError(err, PhaseResponse, "reading record content")
 			}
-			respBuf.Write(b)
-
-			if h.PaddingLength > 0 {
-				if _, err := io.CopyN(io.Discard, c.conn, int64(h.PaddingLength)); err != nil {
-					if isTimeout(err) {
-						return nil, wrap(err, ErrTimeout, "timeout while reading padding")
-					}
-					return nil, wrap(err, ErrRead, "reading padding")
-				}
+			if isEOF(err) {
+				c.markConnClosed(err)
+				return nil, wrapPhase(err, ErrUnexpectedEOF, PhaseResponse, "unexpected EOF while reading record content")
+			}
+			return nil, wrapPhase(err, ErrRead, PhaseResponse, "reading record content")
+		}
+
+		if h.RequestID == protocol.NullRequestID {
+			// Management records are never part of a request's streams
+			if h.Type == protocol.TypeUnknownType {
+				return nil, c.protocolError(decodeUnknownType(content), h)
+			}
+			if c.config.StrictProtocol {
+				return nil, c.protocolError(fmt.Errorf("unexpected management record %s", h.Type), h)
 			}
-		} else if h.Type == fcgiEndRequest {
-			endRequestReceived = true
-			if h.ContentLength > 0 {
-				if _, err := io.CopyN(io.Discard, c.conn, int64(h.ContentLength)); err != nil {
-					if isTimeout(err) {
-						return nil, wrap(err, ErrTimeout, "timeout while reading end request body")
-					}
-					return nil, wrap(err, ErrRead, "reading end request body")
+			if c.config.Logger != nil {
+				c.logSkippedRecord(ctx, h, "management record")
+			}
+			continue
+		}
+		if h.RequestID != c.reqID {
+			if c.config.StrictProtocol {
+				return nil, c.protocolError(fmt.Errorf("record for unknown request ID %d", h.RequestID), h)
+			}
+			if c.config.Logger != nil {
+				c.logSkippedRecord(ctx, h, "unknown request ID")
+			}
+			continue
+		}
+
+		switch h.Type {
+		case protocol.TypeStdout:
+			first := stdoutBytes == 0 && len(content) > 0
+			if first {
+				trace.firstResponseByte()
+			}
+			stdoutBytes += int64(len(content))
+			respBuf.Write(content)
+			// Once the response has started, only stalls count against it
+			if (first && c.config.FirstByteTimeout > 0) || c.config.ReadIdleTimeout > 0 {
+				if err := c.setPhaseDeadline(c.conn.SetReadDeadline, c.config.ReadIdleTimeout, "read idle timeout"); err != nil {
+					return nil, wrapPhase(err, ErrRead, PhaseResponse, "setting read deadline")
 				}
 			}
-			if h.PaddingLength > 0 {
-				if _, err := io.CopyN(io.Discard, c.conn, int64(h.PaddingLength)); err != nil {
-					if isTimeout(err) {
-						return nil, wrap(err, ErrTimeout, "timeout while reading end request padding")
-					}
-					return nil, wrap(err, ErrRead, "reading end request padding")
+		case protocol.TypeStderr:
+			stderrBytes += int64(len(content))
+			if len(content) > 0 {
+				trace.stderrChunk(content)
+				if c.config.Logger != nil {
+					c.logStderr(ctx, params, content)
 				}
 			}
-			if respBuf.Len() > 0 {
-				break
+			// Stderr is a separate stream; mixing it into the response
+			// would corrupt the headers or body
+		case protocol.TypeEndRequest:
+			if err := endReq.UnmarshalBinary(content); err != nil && c.config.StrictProtocol {
+				return nil, c.protocolError(err, h)
+			}
+			c.used = true
+			trace.endRequest(EndRequestInfo{
+				AppStatus:      endReq.AppStatus,
+				ProtocolStatus: endReq.ProtocolStatus,
+				StdoutBytes:    stdoutBytes,
+				StderrBytes:    stderrBytes,
+			})
+			break readLoop
+		case protocol.TypeUnknownType:
+			return nil, c.protocolError(decodeUnknownType(content), h)
+		default:
+			if c.config.StrictProtocol {
+				return nil, c.protocolError(fmt.Errorf("unexpected record %s", h.Type), h)
+			}
+			if c.config.Logger != nil {
+				c.logSkippedRecord(ctx, h, "unexpected type")
 			}
 		}
 	}
 
-	resp, err := parseHTTPResponse(respBuf)
+	// Without FCGI_KEEP_CONN the server closes the connection after END_REQUEST
+	if !c.config.KeepConn {
+		c.markConnClosed(errors.New("request sent without FCGI_KEEP_CONN"))
+	}
+
+	if err := endRequestError(endReq); err != nil {
+		return nil, err
+	}
+
+	reader := readerPool.Get().(*bufio.Reader)
+	reader.Reset(respBuf)
+	resp, err := readHTTPResponse(reader)
 	if err != nil {
-		return nil, wrap(err, ErrInvalidResponse, "parsing HTTP response")
+		readerPool.Put(reader)
+		return nil, wrapPhase(err, ErrInvalidResponse, PhaseParse, "parsing HTTP response")
+	}
+	resp.Body = &pooledBody{ReadCloser: resp.Body, buf: respBuf, reader: reader}
+	returned = true
+	if p := c.config.HeaderPolicy; p != nil {
+		if err := p.sanitize(resp, params["REQUEST_METHOD"]); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
 	}
 	return resp, nil
 }
 
+// pooledBody is a response body backed by a pooled buffer and reader.
+// Closing it returns both to their pools; reading after Close is not allowed.
+type pooledBody struct {
+	io.ReadCloser
+	once   sync.Once
+	buf    *bytes.Buffer
+	reader *bufio.Reader
+}
+
+func (b *pooledBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		b.reader.Reset(nil)
+		readerPool.Put(b.reader)
+		b.buf.Reset()
+		bufferPool.Put(b.buf)
+	})
+	return err
+}
+
 func parseHTTPResponse(buf *bytes.Buffer) (*http.Response, error) {
-	reader := bufio.NewReader(buf)
+	return readHTTPResponse(bufio.NewReader(buf))
+}
+
+// readHTTPResponse parses CGI response headers from reader and returns a
+// response whose body reads the remainder.
+func readHTTPResponse(reader *bufio.Reader) (*http.Response, error) {
 	tp := textproto.NewReader(reader)
 
 	line, err := tp.ReadLine()
@@ -511,12 +822,22 @@ func parseHTTPResponse(buf *bytes.Buffer) (*http.Response, error) {
 }
 
 func (c *Client) Get(ctx context.Context, params map[string]string) (*http.Response, error) {
-	params["REQUEST_METHOD"] = "GET"
-	params["CONTENT_LENGTH"] = "0"
+	prepareGet(params)
 	return c.DoRequest(ctx, params, nil)
 }
 
 func (c *Client) Post(ctx context.Context, params map[string]string, body io.Reader, contentLength int) (*http.Response, error) {
+	return c.DoRequest(ctx, params, preparePost(params, body, contentLength))
+}
+
+// prepareGet sets the params for a GET request without a body
+func prepareGet(params map[string]string) {
+	params["REQUEST_METHOD"] = "GET"
+	params["CONTENT_LENGTH"] = "0"
+}
+
+// preparePost sets the params for a POST request and returns the body to send
+func preparePost(params map[string]string, body io.Reader, contentLength int) io.Reader {
 	params["REQUEST_METHOD"] = "POST"
 	params["CONTENT_LENGTH"] = strconv.Itoa(contentLength)
 	if _, ok := params["CONTENT_TYPE"]; !ok {
@@ -535,7 +856,7 @@ func (c *Client) Post(ctx context.Context, params map[string]string, body io.Rea
 		body = bytes.NewReader(buf)
 	}
 
-	return c.DoRequest(ctx, params, body)
+	return body
 }
 
 func chunked(te []string) bool {
@@ -554,14 +875,11 @@ func DialWithConfig(network, address string, config *Config) (*Client, error) {
 		config = DefaultConfig()
 	}
 
-	dialer := net.Dialer{
-		Timeout: config.ConnectTimeout,
-	}
-	conn, err := dialer.Dial(network, address)
+	conn, err := dialConn(context.Background(), network, address, config, false, "dialing connection")
 	if err != nil {
-		return nil, wrap(err, ErrConnect, "dialing connection")
+		return nil, err
 	}
-	return &Client{conn: conn, reqID: 1, config: config}, nil
+	return newClient(conn, network, address, config), nil
 }
 
 // ReadBody reads and returns the actual response body as a []byte.
@@ -604,14 +922,239 @@ func DialContextWithConfig(ctx context.Context, network, address string, config
 		config = DefaultConfig()
 	}
 
-	dialer := net.Dialer{
-		Timeout: config.ConnectTimeout,
+	conn, err := dialConn(ctx, network, address, config, false, "dialing connection with context")
+	if err != nil {
+		return nil, err
+	}
+	return newClient(conn, network, address, config), nil
+}
+
+// dialConn connects to the server. Failures are wrapped as ErrConnect with
+// the given message. A dial retries connect errors according to config.Retry
+// and counts failures against the circuit breaker. A redial does neither: it
+// is part of a request attempt, which DoRequest retries and the breaker
+// counts already.
+func dialConn(ctx context.Context, network, address string, config *Config, redial bool, msg string) (net.Conn, error) {
+	cb := config.CircuitBreakers
+	key := breakerKey(network, address)
+	trace := ContextTrace(ctx)
+	retry := config.Retry
+	if redial {
+		retry = nil
+	}
+	return withRetry(ctx, retry, "", func(int) (net.Conn, error) {
+		if cb != nil {
+			if err := cb.checkOpen(key); err != nil {
+				return nil, err
+			}
+		}
+		trace.connectStart(network, address)
+		start := time.Now()
+		conn, err := connect(ctx, network, address, config)
+		trace.connectDone(network, address, err)
+		if config.Logger != nil {
+			logDial(ctx, config.Logger, network, address, time.Since(start), err)
+		}
+		if err != nil {
+			err = &Error{Op: msg, Kind: ErrConnect, Phase: PhaseConnect, Addr: address, Err: err}
+			// A cancelled caller says nothing about the server's health
+			if cb != nil && !redial && ctx.Err() == nil {
+				cb.recordDialFailure(key, err)
+			}
+			return nil, err
+		}
+		return conn, nil
+	})
+}
+
+// connect dials address with config.Dialer, or a net.Dialer, and performs
+// the TLS handshake if config.TLSConfig is set, all within ConnectTimeout.
+func connect(ctx context.Context, network, address string, config *Config) (net.Conn, error) {
+	if config.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.ConnectTimeout)
+		defer cancel()
+	}
+
+	var conn net.Conn
+	var err error
+	if config.Dialer != nil {
+		conn, err = config.Dialer(ctx, network, address)
+	} else {
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, network, address)
+		if err != nil {
+			// Only a local socket can be inspected
+			return nil, explainDial(ctx, network, address, err)
+		}
+	}
+	if err != nil || config.TLSConfig == nil {
+		return conn, err
+	}
+
+	tlsConfig := config.TLSConfig
+	if tlsConfig.ServerName == "" {
+		// Unix socket paths have no host to verify against
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.ServerName = host
+		}
+	}
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("TLS handshake: %w", err)
+	}
+	return tlsConn, nil
+}
+
+// NewClient builds a Client around an established connection, for
+// transports that Dial cannot create. The client owns conn and closes it
+// with Close. Errors and logs name conn.RemoteAddr(), which is also what
+// AutoRedial and Retry redial, so enable those only together with a
+// Config.Dialer that can reproduce the connection. A nil config uses
+// DefaultConfig.
+func NewClient(conn net.Conn, config *Config) *Client {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	network, address := "", ""
+	if addr := conn.RemoteAddr(); addr != nil {
+		network, address = addr.Network(), addr.String()
+	}
+	return newClient(conn, network, address, config)
+}
+
+// newClient builds a Client around an established connection.
+func newClient(conn net.Conn, network, address string, config *Config) *Client {
+	return &Client{
+		conn:    conn,
+		rw:      protocol.NewRecordWriter(conn),
+		rr:      protocol.NewRecordReader(conn),
+		config:  config,
+		network: network,
+		address: address,
+	}
+}
+
+// markConnClosed records that the server has closed, or will close, the
+// current connection so the next request redials or fails fast.
+func (c *Client) markConnClosed(cause error) {
+	if c.connErr == nil {
+		c.connErr = wrap(cause, ErrConnClosedByServer, "connection not reusable")
+		if c.config.Logger != nil {
+			c.config.Logger.LogAttrs(context.Background(), slog.LevelDebug, "fcgx: connection not reusable",
+				slog.String("address", c.address),
+				slog.Any("cause", cause))
+		}
+	}
+}
+
+// discardConn closes a connection whose stream can no longer be trusted and
+// marks it closed, so the next request redials or fails with
+// ErrConnClosedByServer wrapping cause.
+func (c *Client) discardConn(cause error) {
+	c.mu.Lock()
+	_ = c.conn.Close()
+	c.mu.Unlock()
+	c.markConnClosed(cause)
+}
+
+// requestError adds the request context to the error returned by doRequest.
+// The error is copied because errors such as connErr are returned repeatedly.
+func (c *Client) requestError(err error, params map[string]string) error {
+	fe, ok := err.(*Error)
+	if !ok {
+		return err
 	}
-	conn, err := dialer.DialContext(ctx, network, address)
+	e := *fe
+	if e.Addr == "" {
+		e.Addr = c.address
+	}
+	if e.RequestID == 0 {
+		e.RequestID = c.reqID
+	}
+	if e.Script == "" {
+		e.Script = scriptName(params)
+	}
+	return &e
+}
+
+// protocolError builds an ErrProtocol error for the offending record and
+// discards the connection, since the stream is out of sync.
+func (c *Client) protocolError(err error, h protocol.Header) error {
+	perr := wrapPhase(err, ErrProtocol, PhaseResponse, fmt.Sprintf("%s record for request %d", h.Type, h.RequestID))
+	if c.config.Logger != nil {
+		c.config.Logger.LogAttrs(context.Background(), slog.LevelError, "fcgx: protocol error, discarding connection",
+			slog.String("type", h.Type.String()),
+			slog.Int("reqID", int(h.RequestID)),
+			slog.String("address", c.address),
+			slog.Any("error", err))
+	}
+	c.discardConn(perr)
+	return perr
+}
+
+// decodeUnknownType decodes the body of an FCGI_UNKNOWN_TYPE record.
+func decodeUnknownType(content []byte) error {
+	var body protocol.UnknownTypeBody
+	if err := body.UnmarshalBinary(content); err != nil {
+		return err
+	}
+	return &UnknownTypeError{Type: body.Type}
+}
+
+// endRequestError converts a rejecting FCGI_END_REQUEST protocol status into
+// an error. Requests completed by the application return nil.
+func endRequestError(body protocol.EndRequestBody) error {
+	switch body.ProtocolStatus {
+	case protocol.StatusRequestComplete:
+		return nil
+	case protocol.StatusOverloaded:
+		return wrapPhase(nil, ErrOverloaded, PhaseResponse, "request rejected with "+body.ProtocolStatus.String())
+	default:
+		return wrapPhase(nil, ErrPHPFPM, PhaseResponse, "request rejected with "+body.ProtocolStatus.String())
+	}
+}
+
+// ensureConn makes sure the client holds a connection that can carry a new
+// request, redialing when AutoRedial is enabled or redial is set.
+func (c *Client) ensureConn(ctx context.Context, redial bool) error {
+	if c.connErr == nil && c.config.KeepConn {
+		if err := connCheck(c.conn); err != nil {
+			c.markConnClosed(err)
+		}
+	}
+	if c.connErr == nil {
+		return nil
+	}
+	if !c.config.AutoRedial && !redial {
+		return c.connErr
+	}
+
+	if c.config.Logger != nil {
+		c.config.Logger.LogAttrs(ctx, slog.LevelInfo, "fcgx: redialing",
+			slog.String("address", c.address),
+			slog.Any("cause", c.connErr))
+	}
+	conn, err := dialConn(ctx, c.network, c.address, c.config, true, "redialing connection")
 	if err != nil {
-		return nil, wrap(err, ErrConnect, "dialing connection with context")
+		return err
 	}
-	return &Client{conn: conn, reqID: 1, config: config}, nil
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		_ = conn.Close()
+		return ErrClientClosed
+	}
+	_ = c.conn.Close()
+	c.conn = conn
+	c.rw = protocol.NewRecordWriter(conn)
+	c.rr = protocol.NewRecordReader(conn)
+	c.connErr = nil
+	c.used = false
+	return nil
 }
 
 // Close closes the FastCGI connection.
@@ -0,0 +1,309 @@
+// Package fcgxotel instruments fcgx clients with OpenTelemetry.
+//
+// It wraps a *fcgx.Client or *fcgx.Balancer so that each FastCGI request
+// gets a client span, duration and size metrics, and W3C trace context
+// forwarded to PHP as HTTP_TRACEPARENT and HTTP_TRACESTATE params. Request
+// stages are observed through fcgx.Trace hooks.
+package fcgxotel
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gophpeek/fcgx"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ScopeName is the instrumentation scope of the spans and metrics created
+// by this package.
+const ScopeName = "github.com/gophpeek/fcgx/fcgxotel"
+
+// Attribute keys specific to FastCGI. Generic attributes use the OpenTelemetry
+// semantic convention names.
+const (
+	ScriptNameKey     = attribute.Key("fastcgi.script_name")
+	AppStatusKey      = attribute.Key("fastcgi.app_status")
+	ProtocolStatusKey = attribute.Key("fastcgi.protocol_status")
+	ConnReusedKey     = attribute.Key("fastcgi.connection.reused")
+)
+
+// Requester is implemented by *fcgx.Client and *fcgx.Balancer.
+type Requester interface {
+	DoRequest(ctx context.Context, params map[string]string, body io.Reader) (*http.Response, error)
+	Get(ctx context.Context, params map[string]string) (*http.Response, error)
+	Post(ctx context.Context, params map[string]string, body io.Reader, contentLength int) (*http.Response, error)
+}
+
+type config struct {
+	tracerProvider trace.TracerProvider
+	meterProvider  metric.MeterProvider
+	propagators    propagation.TextMapPropagator
+}
+
+// Option configures a Client.
+type Option func(*config)
+
+// WithTracerProvider sets the TracerProvider used to create spans.
+// Default: otel.GetTracerProvider()
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(c *config) { c.tracerProvider = tp }
+}
+
+// WithMeterProvider sets the MeterProvider used to record metrics.
+// Default: otel.GetMeterProvider()
+func WithMeterProvider(mp metric.MeterProvider) Option {
+	return func(c *config) { c.meterProvider = mp }
+}
+
+// WithPropagators sets the propagators used to forward trace context to PHP.
+// Default: otel.GetTextMapPropagator()
+func WithPropagators(p propagation.TextMapPropagator) Option {
+	return func(c *config) { c.propagators = p }
+}
+
+// Client wraps a Requester with OpenTelemetry instrumentation.
+// It is safe for concurrent use if the wrapped Requester is.
+type Client struct {
+	next        Requester
+	tracer      trace.Tracer
+	propagators propagation.TextMapPropagator
+
+	duration     metric.Float64Histogram
+	requestSize  metric.Int64Histogram
+	responseSize metric.Int64Histogram
+}
+
+// NewClient returns a Client that instruments requests made through next.
+func NewClient(next Requester, opts ...Option) *Client {
+	cfg := config{
+		tracerProvider: otel.GetTracerProvider(),
+		meterProvider:  otel.GetMeterProvider(),
+		propagators:    otel.GetTextMapPropagator(),
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	meter := cfg.meterProvider.Meter(ScopeName)
+	c := &Client{
+		next:        next,
+		tracer:      cfg.tracerProvider.Tracer(ScopeName),
+		propagators: cfg.propagators,
+	}
+	// Instrument creation only fails on invalid names; the no-op instruments
+	// returned alongside the error keep the client usable.
+	c.duration, _ = meter.Float64Histogram("fastcgi.client.request.duration",
+		metric.WithDescription("Duration of FastCGI requests"),
+		metric.WithUnit("s"))
+	c.requestSize, _ = meter.Int64Histogram("fastcgi.client.request.body.size",
+		metric.WithDescription("Size of FastCGI request bodies (FCGI_STDIN)"),
+		metric.WithUnit("By"))
+	c.responseSize, _ = meter.Int64Histogram("fastcgi.client.response.body.size",
+		metric.WithDescription("Size of FastCGI responses (FCGI_STDOUT)"),
+		metric.WithUnit("By"))
+	return c
+}
+
+// DoRequest performs an instrumented request.
+func (c *Client) DoRequest(ctx context.Context, params map[string]string, body io.Reader) (*http.Response, error) {
+	params = cloneParams(params)
+	return c.do(ctx, params["REQUEST_METHOD"], params, func(ctx context.Context) (*http.Response, error) {
+		return c.next.DoRequest(ctx, params, body)
+	})
+}
+
+// Get performs an instrumented GET request.
+func (c *Client) Get(ctx context.Context, params map[string]string) (*http.Response, error) {
+	params = cloneParams(params)
+	return c.do(ctx, http.MethodGet, params, func(ctx context.Context) (*http.Response, error) {
+		return c.next.Get(ctx, params)
+	})
+}
+
+// Post performs an instrumented POST request.
+func (c *Client) Post(ctx context.Context, params map[string]string, body io.Reader, contentLength int) (*http.Response, error) {
+	params = cloneParams(params)
+	return c.do(ctx, http.MethodPost, params, func(ctx context.Context) (*http.Response, error) {
+		return c.next.Post(ctx, params, body, contentLength)
+	})
+}
+
+// cloneParams copies params so injecting trace context leaves the caller's
+// map untouched.
+func cloneParams(params map[string]string) map[string]string {
+	cp := make(map[string]string, len(params)+2)
+	for k, v := range params {
+		cp[k] = v
+	}
+	return cp
+}
+
+func (c *Client) do(ctx context.Context, method string, params map[string]string, fn func(context.Context) (*http.Response, error)) (*http.Response, error) {
+	if method == "" {
+		method = http.MethodGet // PHP-FPM treats a missing REQUEST_METHOD like GET
+	}
+	script := params["SCRIPT_NAME"]
+	name := method
+	if script != "" {
+		name += " " + script
+	}
+
+	attrs := []attribute.KeyValue{attribute.String("http.request.method", method)}
+	if script != "" {
+		attrs = append(attrs, ScriptNameKey.String(script))
+	}
+	ctx, span := c.tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...))
+	defer span.End()
+
+	// The span is the parent PHP should see
+	c.propagators.Inject(ctx, paramsCarrier(params))
+
+	// Filled in by the hooks as the request progresses
+	var (
+		serverAttrs []attribute.KeyValue
+		stdinBytes  int64
+		stdoutBytes int64
+		ended       bool
+	)
+	ctx = fcgx.WithTrace(ctx, &fcgx.Trace{
+		GotConn: func(info fcgx.GotConnInfo) {
+			serverAttrs = connAttributes(info.Conn)
+			span.SetAttributes(serverAttrs...)
+			span.SetAttributes(ConnReusedKey.Bool(info.Reused))
+		},
+		WroteParams: func() { span.AddEvent("fastcgi.wrote_params") },
+		WroteStdin: func(n int64) {
+			stdinBytes = n
+			span.AddEvent("fastcgi.wrote_stdin")
+		},
+		FirstResponseByte: func() { span.AddEvent("fastcgi.first_response_byte") },
+		EndRequest: func(info fcgx.EndRequestInfo) {
+			stdoutBytes = info.StdoutBytes
+			ended = true
+			span.SetAttributes(
+				AppStatusKey.Int64(int64(info.AppStatus)),
+				ProtocolStatusKey.String(info.ProtocolStatus.String()),
+			)
+		},
+	})
+
+	start := time.Now()
+	resp, err := fn(ctx)
+	elapsed := time.Since(start)
+
+	metricAttrs := append(attrs[:1:1], serverAttrs...)
+	span.SetAttributes(attribute.Int64("http.request.body.size", stdinBytes))
+	if ended {
+		span.SetAttributes(attribute.Int64("http.response.body.size", stdoutBytes))
+	}
+	switch {
+	case err != nil:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		metricAttrs = append(metricAttrs, attribute.String("error.type", errorType(err)))
+	default:
+		span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+		metricAttrs = append(metricAttrs, attribute.Int("http.response.status_code", resp.StatusCode))
+		if resp.StatusCode >= 500 {
+			span.SetStatus(codes.Error, "")
+		}
+	}
+
+	set := metric.WithAttributes(metricAttrs...)
+	c.duration.Record(ctx, elapsed.Seconds(), set)
+	c.requestSize.Record(ctx, stdinBytes, set)
+	if ended {
+		c.responseSize.Record(ctx, stdoutBytes, set)
+	}
+	return resp, err
+}
+
+// connAttributes describes the server end of conn.
+func connAttributes(conn net.Conn) []attribute.KeyValue {
+	addr := conn.RemoteAddr()
+	if addr == nil {
+		return nil
+	}
+	attrs := []attribute.KeyValue{attribute.String("network.transport", addr.Network())}
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		// Unix sockets have no port
+		return append(attrs, attribute.String("server.address", addr.String()))
+	}
+	attrs = append(attrs, attribute.String("server.address", host))
+	if p, err := strconv.Atoi(port); err == nil {
+		attrs = append(attrs, attribute.Int("server.port", p))
+	}
+	return attrs
+}
+
+// errorTypes maps fcgx sentinel errors to error.type values, most specific
+// first.
+var errorTypes = []struct {
+	err  error
+	name string
+}{
+	{fcgx.ErrCircuitOpen, "circuit_open"},
+	{fcgx.ErrOverloaded, "overloaded"},
+	{fcgx.ErrConnClosedByServer, "connection_closed"},
+	{fcgx.ErrProtocol, "protocol"},
+	{fcgx.ErrConnect, "connect"},
+	{fcgx.ErrTimeout, "timeout"},
+	{fcgx.ErrContextCancelled, "cancelled"},
+	{fcgx.ErrUnexpectedEOF, "unexpected_eof"},
+	{fcgx.ErrInvalidResponse, "invalid_response"},
+	{fcgx.ErrPHPFPM, "php_fpm"},
+	{fcgx.ErrWrite, "write"},
+	{fcgx.ErrRead, "read"},
+	{fcgx.ErrClientClosed, "client_closed"},
+}
+
+// errorType returns a low-cardinality name for err.
+func errorType(err error) string {
+	for _, t := range errorTypes {
+		if errors.Is(err, t.err) {
+			return t.name
+		}
+	}
+	return "_OTHER"
+}
+
+// paramsCarrier adapts FastCGI params to propagation.TextMapCarrier. Keys map
+// to CGI-style names the way a web server forwards request headers, so
+// traceparent arrives in PHP as $_SERVER['HTTP_TRACEPARENT'].
+type paramsCarrier map[string]string
+
+func paramName(key string) string {
+	return "HTTP_" + strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+}
+
+func (p paramsCarrier) Get(key string) string {
+	return p[paramName(key)]
+}
+
+func (p paramsCarrier) Set(key, value string) {
+	p[paramName(key)] = value
+}
+
+func (p paramsCarrier) Keys() []string {
+	var keys []string
+	for k := range p {
+		if name, ok := strings.CutPrefix(k, "HTTP_"); ok {
+			keys = append(keys, strings.ToLower(strings.ReplaceAll(name, "_", "-")))
+		}
+	}
+	return keys
+}
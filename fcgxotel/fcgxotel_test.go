@@ -0,0 +1,165 @@
+package fcgxotel
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gophpeek/fcgx"
+	"github.com/gophpeek/fcgx/internal/fcgitest"
+	"github.com/gophpeek/fcgx/protocol"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// serveFastCGI answers each request with the response built by
+// respond, and sends the params it received on seen.
+func serveFastCGI(t *testing.T, respond func(params map[string]string, stdin []byte) string, seen chan<- map[string]string) string {
+	t.Helper()
+	return fcgitest.NewServer(t, func(w io.Writer, req *fcgitest.Request) {
+		seen <- req.Params
+		fcgitest.WriteRecord(w, protocol.TypeStdout, req.ID, []byte(respond(req.Params, req.Stdin)))
+		fcgitest.WriteEndRequest(w, req.ID, 0, protocol.StatusRequestComplete)
+	}).Addr()
+}
+
+func setup(t *testing.T, addr string) (*Client, *tracetest.InMemoryExporter, *sdkmetric.ManualReader) {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	reader := sdkmetric.NewManualReader()
+
+	bal, err := fcgx.NewBalancer([]fcgx.Backend{{Network: "tcp", Address: addr}}, nil)
+	if err != nil {
+		t.Fatalf("NewBalancer failed: %v", err)
+	}
+	client := NewClient(bal,
+		WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))),
+		WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))),
+		WithPropagators(propagation.TraceContext{}),
+	)
+	return client, exporter, reader
+}
+
+func attrs(kvs []attribute.KeyValue) map[attribute.Key]attribute.Value {
+	m := make(map[attribute.Key]attribute.Value, len(kvs))
+	for _, kv := range kvs {
+		m[kv.Key] = kv.Value
+	}
+	return m
+}
+
+func TestClientSpan(t *testing.T) {
+	seen := make(chan map[string]string, 1)
+	addr := serveFastCGI(t, func(params map[string]string, stdin []byte) string {
+		return "Status: 201 Created\r\nContent-Type: text/plain\r\n\r\n" + string(stdin)
+	}, seen)
+	client, exporter, reader := setup(t, addr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	params := map[string]string{"SCRIPT_NAME": "/index.php", "SCRIPT_FILENAME": "/var/www/index.php"}
+	resp, err := client.Post(ctx, params, strings.NewReader("a=1&b=2"), 7)
+	if err != nil {
+		t.Fatalf("Post failed: %v", err)
+	}
+	fcgx.ReadBody(resp)
+
+	if _, ok := params["HTTP_TRACEPARENT"]; ok {
+		t.Error("Expected the caller's params to be left untouched")
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+	span := spans[0]
+	if span.Name != "POST /index.php" {
+		t.Errorf("Unexpected span name %q", span.Name)
+	}
+	got := attrs(span.Attributes)
+	want := map[attribute.Key]attribute.Value{
+		"http.request.method":       attribute.StringValue("POST"),
+		"fastcgi.script_name":       attribute.StringValue("/index.php"),
+		"server.address":            attribute.StringValue("127.0.0.1"),
+		"network.transport":         attribute.StringValue("tcp"),
+		"http.response.status_code": attribute.IntValue(201),
+		"fastcgi.app_status":        attribute.Int64Value(0),
+		"fastcgi.protocol_status":   attribute.StringValue("FCGI_REQUEST_COMPLETE"),
+		"http.request.body.size":    attribute.Int64Value(7),
+		"http.response.body.size":   attribute.Int64Value(int64(len("Status: 201 Created\r\nContent-Type: text/plain\r\n\r\na=1&b=2"))),
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("Attribute %s = %v, want %v", k, got[k].Emit(), v.Emit())
+		}
+	}
+	if span.Status.Code == codes.Error {
+		t.Errorf("Unexpected error status: %+v", span.Status)
+	}
+
+	// PHP sees the client span as its parent
+	php := <-seen
+	wantParent := "00-" + span.SpanContext.TraceID().String() + "-" + span.SpanContext.SpanID().String() + "-01"
+	if php["HTTP_TRACEPARENT"] != wantParent {
+		t.Errorf("HTTP_TRACEPARENT = %q, want %q", php["HTTP_TRACEPARENT"], wantParent)
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(ctx, &rm); err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+	counts := map[string]uint64{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			switch data := m.Data.(type) {
+			case metricdata.Histogram[float64]:
+				counts[m.Name] = data.DataPoints[0].Count
+			case metricdata.Histogram[int64]:
+				counts[m.Name] = data.DataPoints[0].Count
+			}
+		}
+	}
+	for _, name := range []string{"fastcgi.client.request.duration", "fastcgi.client.request.body.size", "fastcgi.client.response.body.size"} {
+		if counts[name] != 1 {
+			t.Errorf("Expected one %s measurement, got %d", name, counts[name])
+		}
+	}
+}
+
+func TestClientSpanError(t *testing.T) {
+	client, exporter, _ := setup(t, fcgitest.DeadAddr(t))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := client.Get(ctx, map[string]string{}); !errors.Is(err, fcgx.ErrConnect) {
+		t.Fatalf("Expected ErrConnect, got %v", err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 || spans[0].Status.Code != codes.Error || len(spans[0].Events) == 0 {
+		t.Fatalf("Expected an errored span with an exception event, got %+v", spans)
+	}
+}
+
+func TestParamsCarrier(t *testing.T) {
+	p := paramsCarrier{"SCRIPT_NAME": "/x.php"}
+	p.Set("traceparent", "v")
+	p.Set("x-custom-header", "w")
+	if p["HTTP_TRACEPARENT"] != "v" || p["HTTP_X_CUSTOM_HEADER"] != "w" {
+		t.Errorf("Unexpected params %v", p)
+	}
+	if p.Get("traceparent") != "v" {
+		t.Errorf("Get(traceparent) = %q", p.Get("traceparent"))
+	}
+	if keys := p.Keys(); len(keys) != 2 {
+		t.Errorf("Expected 2 keys, got %v", keys)
+	}
+}
@@ -0,0 +1,25 @@
+module github.com/gophpeek/fcgx/fcgxotel
+
+go 1.24.1
+
+require (
+	github.com/gophpeek/fcgx v0.0.0-00010101000000-000000000000
+	go.opentelemetry.io/otel v1.41.0
+	go.opentelemetry.io/otel/metric v1.41.0
+	go.opentelemetry.io/otel/sdk v1.41.0
+	go.opentelemetry.io/otel/sdk/metric v1.41.0
+	go.opentelemetry.io/otel/trace v1.41.0
+)
+
+require (
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/go-logr/logr v1.4.4 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	go.opentelemetry.io/auto/sdk v1.2.1 // indirect
+	golang.org/x/sys v0.41.0 // indirect
+)
+
+// fcgx has no tagged release yet, so build against the parent directory.
+// Require the first tag and drop this replace once it is published.
+replace github.com/gophpeek/fcgx => ../
@@ -0,0 +1,106 @@
+package fpm
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gophpeek/fcgx/internal/fcgitest"
+	"github.com/gophpeek/fcgx/protocol"
+)
+
+// fakeFPM is a FastCGI server answering every request with a status page.
+type fakeFPM struct {
+	srv *fcgitest.Server
+
+	mu       sync.Mutex
+	page     string        // Response body, JSON
+	status   string        // Status header, empty for 200
+	delay    time.Duration // Time before responding
+	inFlight int
+	maxSeen  int    // Highest number of requests handled at once
+	query    string // QUERY_STRING of the last request
+}
+
+func newFakeFPM(t *testing.T, page string) *fakeFPM {
+	t.Helper()
+	f := &fakeFPM{page: page}
+	f.srv = fcgitest.NewServer(t, f.handle)
+	return f
+}
+
+func (f *fakeFPM) pool(name string) Pool {
+	return Pool{Name: name, Network: "tcp", Address: f.srv.Addr()}
+}
+
+// respondWith makes later responses use status, if not empty, after delay.
+func (f *fakeFPM) respondWith(status string, delay time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status, f.delay = status, delay
+}
+
+func (f *fakeFPM) maxConcurrent() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.maxSeen
+}
+
+func (f *fakeFPM) lastQuery() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.query
+}
+
+func (f *fakeFPM) handle(w io.Writer, req *fcgitest.Request) {
+	f.mu.Lock()
+	f.query = req.Params["QUERY_STRING"]
+	f.inFlight++
+	f.maxSeen = max(f.maxSeen, f.inFlight)
+	page, status, delay := f.page, f.status, f.delay
+	f.mu.Unlock()
+
+	time.Sleep(delay)
+	head := "Content-Type: application/json\r\n"
+	if status != "" {
+		head = "Status: " + status + "\r\n" + head
+	}
+	fcgitest.WriteRecord(w, protocol.TypeStdout, req.ID, []byte(head+"\r\n"+page))
+	fcgitest.WriteEndRequest(w, req.ID, 0, protocol.StatusRequestComplete)
+
+	f.mu.Lock()
+	f.inFlight--
+	f.mu.Unlock()
+}
+
+// statusPage is a "status?json" page as PHP-FPM 8 renders it.
+const statusPage = `{"pool":"www","process manager":"dynamic","start time":1700000000,"start since":3600,` +
+	`"accepted conn":1500,"listen queue":2,"max listen queue":7,"listen queue len":511,` +
+	`"idle processes":1,"active processes":4,"total processes":5,"max active processes":5,` +
+	`"max children reached":3,"slow requests":9}`
+
+// fullStatusPage is a "status?json&full" page with one stuck worker and one
+// that used far more memory than the others.
+const fullStatusPage = `{"pool":"www","process manager":"static","start time":1700000000,"start since":3600,` +
+	`"accepted conn":40,"listen queue":0,"max listen queue":0,"listen queue len":511,` +
+	`"idle processes":3,"active processes":3,"total processes":6,"max active processes":6,` +
+	`"max children reached":0,"slow requests":1,"processes":[` +
+	`{"pid":101,"state":"Idle","start time":1700000000,"start since":3600,"requests":10,"request duration":1200,` +
+	`"request method":"GET","request uri":"/index.php","content length":0,"user":"-","script":"/app/index.php",` +
+	`"last request cpu":12.5,"last request memory":2097152},` +
+	`{"pid":102,"state":"Running","start time":1700000000,"start since":3600,"requests":7,"request duration":95000000,` +
+	`"request method":"POST","request uri":"/export.php?all=1","content length":512,"user":"-","script":"/app/export.php",` +
+	`"last request cpu":0,"last request memory":0},` +
+	`{"pid":103,"state":"Idle","start time":1700000000,"start since":3600,"requests":9,"request duration":900,` +
+	`"request method":"GET","request uri":"/index.php","content length":0,"user":"-","script":"/app/index.php",` +
+	`"last request cpu":8,"last request memory":2097152},` +
+	`{"pid":104,"state":"Running","start time":1700000000,"start since":3600,"requests":3,"request duration":250000,` +
+	`"request method":"GET","request uri":"/api.php","content length":0,"user":"-","script":"/app/api.php",` +
+	`"last request cpu":0,"last request memory":0},` +
+	`{"pid":105,"state":"Idle","start time":1700000000,"start since":3600,"requests":4,"request duration":3000000,` +
+	`"request method":"GET","request uri":"/report.php","content length":0,"user":"-","script":"/app/report.php",` +
+	`"last request cpu":90,"last request memory":67108864},` +
+	`{"pid":106,"state":"Reading headers","start time":1700000000,"start since":3600,"requests":0,"request duration":0,` +
+	`"request method":"-","request uri":"-","content length":0,"user":"-","script":"-",` +
+	`"last request cpu":0,"last request memory":0}]}`
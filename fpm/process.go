@@ -0,0 +1,95 @@
+package fpm
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"time"
+
+	"github.com/gophpeek/fcgx"
+)
+
+// Process is one worker entry of the "status?full" page.
+type Process = fcgx.FPMProcess
+
+// Process states reported by PHP-FPM.
+const (
+	StateIdle           = "Idle"
+	StateAccepting      = "Accepting"
+	StateRunning        = "Running"
+	StateReadingHeaders = "Reading headers"
+	StateInfo           = "Getting request information"
+	StateFinishing      = "Finishing"
+	StateEnding         = "Ending"
+)
+
+// FetchFullStatus queries the status page at path over client, including the
+// per-process list. An empty path uses DefaultStatusPath.
+func FetchFullStatus(ctx context.Context, client *fcgx.Client, path string) (*Status, error) {
+	var status Status
+	if err := fetch(ctx, client, path, "json&full", &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// LongestRunning returns up to n processes in the Running state, longest
+// request first. A negative n returns all of them.
+func (s *Status) LongestRunning(n int) []Process {
+	running := s.RunningLongerThan(0)
+	if n >= 0 && len(running) > n {
+		running = running[:n]
+	}
+	return running
+}
+
+// RunningLongerThan returns the processes whose current request has been
+// Running for at least d, longest first. These are the candidates for stuck
+// workers.
+func (s *Status) RunningLongerThan(d time.Duration) []Process {
+	var running []Process
+	for _, p := range s.Processes {
+		if p.State == StateRunning && p.RequestDuration >= d {
+			running = append(running, p)
+		}
+	}
+	slices.SortStableFunc(running, func(a, b Process) int {
+		return cmp.Compare(b.RequestDuration, a.RequestDuration)
+	})
+	return running
+}
+
+// MemoryOutliers returns the processes whose last request used more than
+// factor times the median memory of the pool, highest first. FPM reports
+// memory only once a request has finished, so processes without a value
+// are ignored.
+func (s *Status) MemoryOutliers(factor float64) []Process {
+	var measured []Process
+	for _, p := range s.Processes {
+		if p.LastRequestMemory > 0 {
+			measured = append(measured, p)
+		}
+	}
+	if len(measured) == 0 {
+		return nil
+	}
+	slices.SortStableFunc(measured, func(a, b Process) int {
+		return cmp.Compare(b.LastRequestMemory, a.LastRequestMemory)
+	})
+
+	// Median of the descending list
+	n := len(measured)
+	median := float64(measured[n/2].LastRequestMemory)
+	if n%2 == 0 {
+		median = (median + float64(measured[n/2-1].LastRequestMemory)) / 2
+	}
+	limit := factor * median
+	i := 0
+	for i < n && float64(measured[i].LastRequestMemory) > limit {
+		i++
+	}
+	if i == 0 {
+		return nil
+	}
+	return measured[:i]
+}
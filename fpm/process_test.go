@@ -0,0 +1,80 @@
+package fpm
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+)
+
+func pids(procs []Process) []int {
+	var out []int
+	for _, p := range procs {
+		out = append(out, p.PID)
+	}
+	return out
+}
+
+func equalPIDs(procs []Process, want ...int) bool {
+	return slices.Equal(pids(procs), want)
+}
+
+func TestFullStatus(t *testing.T) {
+	var st Status
+	if err := json.Unmarshal([]byte(fullStatusPage), &st); err != nil {
+		t.Fatal(err)
+	}
+	if len(st.Processes) != 6 {
+		t.Fatalf("Expected 6 processes, got %d", len(st.Processes))
+	}
+	p := st.Processes[1]
+	if p.PID != 102 || p.State != StateRunning || p.RequestDuration != 95*time.Second ||
+		p.RequestMethod != "POST" || p.RequestURI != "/export.php?all=1" || p.ContentLength != 512 ||
+		p.Script != "/app/export.php" || p.StartSince != time.Hour || !p.StartTime.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("Unexpected process %+v", p)
+	}
+	if p := st.Processes[4]; p.LastRequestCPU != 90 || p.LastRequestMemory != 64<<20 {
+		t.Errorf("Unexpected last request usage %+v", p)
+	}
+
+	if got := st.LongestRunning(1); !equalPIDs(got, 102) {
+		t.Errorf("LongestRunning(1) = %v", pids(got))
+	}
+	if got := st.LongestRunning(-1); !equalPIDs(got, 102, 104) {
+		t.Errorf("LongestRunning(-1) = %v", pids(got))
+	}
+	if got := st.RunningLongerThan(time.Minute); !equalPIDs(got, 102) {
+		t.Errorf("RunningLongerThan(1m) = %v", pids(got))
+	}
+	if got := st.MemoryOutliers(4); !equalPIDs(got, 105) {
+		t.Errorf("MemoryOutliers(4) = %v", pids(got))
+	}
+	if got := st.MemoryOutliers(40); len(got) != 0 {
+		t.Errorf("MemoryOutliers(40) = %v", pids(got))
+	}
+	if got := (&Status{}).MemoryOutliers(2); got != nil {
+		t.Errorf("Expected no outliers without processes, got %v", pids(got))
+	}
+}
+
+func TestScrapeFull(t *testing.T) {
+	f := newFakeFPM(t, fullStatusPage)
+	NewScraper(nil).Scrape(context.Background(), []Pool{f.pool("www")})
+	if q := f.lastQuery(); q != "json" {
+		t.Errorf("Expected plain status query by default, got %q", q)
+	}
+
+	config := DefaultScraperConfig()
+	config.Full = true
+	snap := NewScraper(config).Scrape(context.Background(), []Pool{f.pool("www")})
+	if err := snap.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if q := f.lastQuery(); q != "json&full" {
+		t.Errorf("Expected full status query, got %q", q)
+	}
+	if n := len(snap.Results[0].Status.Processes); n != 6 {
+		t.Errorf("Expected 6 processes, got %d", n)
+	}
+}
@@ -0,0 +1,192 @@
+package fpm
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gophpeek/fcgx"
+)
+
+// ScraperConfig holds configuration options for a Scraper.
+type ScraperConfig struct {
+	// Concurrency is the number of pools scraped at the same time.
+	// Default: 8
+	Concurrency int
+
+	// Timeout limits scraping a single pool, including the dial.
+	// Default: 5 seconds
+	Timeout time.Duration
+
+	// Full fetches the per-process list of each pool into Status.Processes.
+	// The page grows with pm.max_children, so leave it off for plain metrics.
+	// Default: false
+	Full bool
+
+	// ClientConfig configures the connection made to each pool.
+	// Default: fcgx.DefaultConfig()
+	ClientConfig *fcgx.Config
+}
+
+// DefaultScraperConfig returns a ScraperConfig with sensible defaults
+func DefaultScraperConfig() *ScraperConfig {
+	return &ScraperConfig{
+		Concurrency:  8,
+		Timeout:      5 * time.Second,
+		ClientConfig: fcgx.DefaultConfig(),
+	}
+}
+
+// Scraper fetches the status of many pools concurrently. Each scrape uses a
+// fresh connection per pool, so a Scraper holds no connections between
+// scrapes. All methods are thread-safe and can be called concurrently.
+type Scraper struct {
+	config *ScraperConfig
+}
+
+// NewScraper creates a Scraper. A nil config uses DefaultScraperConfig.
+func NewScraper(config *ScraperConfig) *Scraper {
+	if config == nil {
+		config = DefaultScraperConfig()
+	}
+	c := *config
+	if c.Concurrency < 1 {
+		c.Concurrency = 8
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 5 * time.Second
+	}
+	if c.ClientConfig == nil {
+		c.ClientConfig = fcgx.DefaultConfig()
+	}
+	return &Scraper{config: &c}
+}
+
+// Result is the outcome of scraping one pool.
+type Result struct {
+	Pool     Pool
+	Status   *Status       // Nil when Err is set
+	Err      error         // A *PoolError when the scrape failed
+	Duration time.Duration // Time taken to scrape the pool
+}
+
+// PoolError reports a pool that could not be scraped.
+type PoolError struct {
+	Pool Pool
+	Err  error
+}
+
+func (e *PoolError) Error() string {
+	return "fpm: pool " + e.Pool.String() + ": " + e.Err.Error()
+}
+
+func (e *PoolError) Unwrap() error {
+	return e.Err
+}
+
+// Totals aggregates the status of every pool that was scraped successfully.
+type Totals struct {
+	Pools              int // Pools scraped successfully
+	Failed             int // Pools that could not be scraped
+	AcceptedConn       uint64
+	ListenQueue        int
+	MaxListenQueue     int // Highest of the pools
+	IdleProcesses      int
+	ActiveProcesses    int
+	TotalProcesses     int
+	MaxChildrenReached uint64
+	SlowRequests       uint64
+}
+
+// Snapshot is the result of scraping a set of pools.
+type Snapshot struct {
+	Time    time.Time // When the scrape started
+	Results []Result  // One per pool, in the order the pools were given
+	Totals  Totals
+}
+
+// Err joins the errors of every pool that could not be scraped, or returns
+// nil when all succeeded.
+func (s *Snapshot) Err() error {
+	var errs []error
+	for _, r := range s.Results {
+		if r.Err != nil {
+			errs = append(errs, r.Err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Scrape fetches the status of every pool, at most Concurrency at a time.
+// Pools that fail or exceed Timeout are reported in their Result without
+// affecting the others.
+func (s *Scraper) Scrape(ctx context.Context, pools []Pool) *Snapshot {
+	snap := &Snapshot{Time: time.Now(), Results: make([]Result, len(pools))}
+
+	sem := make(chan struct{}, s.config.Concurrency)
+	var wg sync.WaitGroup
+	for i, pool := range pools {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				snap.Results[i] = Result{Pool: pool, Err: &PoolError{Pool: pool, Err: ctx.Err()}}
+				return
+			}
+			snap.Results[i] = s.scrape(ctx, pool)
+		}()
+	}
+	wg.Wait()
+
+	for _, r := range snap.Results {
+		snap.Totals.add(r)
+	}
+	return snap
+}
+
+// scrape fetches the status of a single pool.
+func (s *Scraper) scrape(ctx context.Context, pool Pool) Result {
+	ctx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	status, err := s.fetchStatus(ctx, pool)
+	r := Result{Pool: pool, Status: status, Duration: time.Since(start)}
+	if err != nil {
+		r.Err = &PoolError{Pool: pool, Err: err}
+	}
+	return r
+}
+
+func (s *Scraper) fetchStatus(ctx context.Context, pool Pool) (*Status, error) {
+	client, err := fcgx.DialContextWithConfig(ctx, pool.Network, pool.Address, s.config.ClientConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	if s.config.Full {
+		return FetchFullStatus(ctx, client, pool.StatusPath)
+	}
+	return FetchStatus(ctx, client, pool.StatusPath)
+}
+
+func (t *Totals) add(r Result) {
+	if r.Err != nil {
+		t.Failed++
+		return
+	}
+	st := r.Status
+	t.Pools++
+	t.AcceptedConn += st.AcceptedConn
+	t.ListenQueue += st.ListenQueue
+	t.MaxListenQueue = max(t.MaxListenQueue, st.MaxListenQueue)
+	t.IdleProcesses += st.IdleProcesses
+	t.ActiveProcesses += st.ActiveProcesses
+	t.TotalProcesses += st.TotalProcesses
+	t.MaxChildrenReached += st.MaxChildrenReached
+	t.SlowRequests += st.SlowRequests
+}
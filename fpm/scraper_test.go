@@ -0,0 +1,120 @@
+package fpm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/gophpeek/fcgx"
+	"github.com/gophpeek/fcgx/fpmconf"
+	"github.com/gophpeek/fcgx/internal/fcgitest"
+)
+
+func TestScrape(t *testing.T) {
+	a := newFakeFPM(t, statusPage)
+	b := newFakeFPM(t, strings.Replace(statusPage, `"max listen queue":7`, `"max listen queue":12`, 1))
+	broken := newFakeFPM(t, "")
+	broken.respondWith("404 Not Found", 0)
+
+	dead := Pool{Name: "dead", Network: "tcp", Address: fcgitest.DeadAddr(t)}
+
+	pools := []Pool{a.pool("a"), dead, b.pool("b"), broken.pool("broken")}
+	snap := NewScraper(nil).Scrape(context.Background(), pools)
+
+	if len(snap.Results) != 4 {
+		t.Fatalf("Expected a result per pool, got %d", len(snap.Results))
+	}
+	for i, r := range snap.Results {
+		if r.Pool != pools[i] {
+			t.Errorf("Result %d is for %v, want %v", i, r.Pool, pools[i])
+		}
+	}
+
+	st := snap.Results[0].Status
+	if st == nil || st.Pool != "www" || st.ActiveProcesses != 4 || st.AcceptedConn != 1500 ||
+		st.StartSince != time.Hour || !st.StartTime.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("Unexpected status %+v", st)
+	}
+
+	if !errors.Is(snap.Results[1].Err, fcgx.ErrConnect) {
+		t.Errorf("Expected connect error for dead pool, got %v", snap.Results[1].Err)
+	}
+	var pe *PoolError
+	if !errors.As(snap.Results[3].Err, &pe) || pe.Pool.Name != "broken" || !strings.Contains(pe.Error(), "404") {
+		t.Errorf("Expected pool error for broken status page, got %v", snap.Results[3].Err)
+	}
+	if err := snap.Err(); err == nil || !strings.Contains(err.Error(), "pool dead") || !strings.Contains(err.Error(), "pool broken") {
+		t.Errorf("Expected joined errors, got %v", err)
+	}
+
+	want := Totals{Pools: 2, Failed: 2, AcceptedConn: 3000, ListenQueue: 4, MaxListenQueue: 12,
+		IdleProcesses: 2, ActiveProcesses: 8, TotalProcesses: 10, MaxChildrenReached: 6, SlowRequests: 18}
+	if snap.Totals != want {
+		t.Errorf("Totals = %+v, want %+v", snap.Totals, want)
+	}
+}
+
+func TestScrapeConcurrency(t *testing.T) {
+	f := newFakeFPM(t, statusPage)
+	f.respondWith("", 30*time.Millisecond)
+
+	pools := make([]Pool, 6)
+	for i := range pools {
+		pools[i] = f.pool("")
+	}
+	config := DefaultScraperConfig()
+	config.Concurrency = 2
+	snap := NewScraper(config).Scrape(context.Background(), pools)
+	if err := snap.Err(); err != nil {
+		t.Fatalf("Scrape failed: %v", err)
+	}
+	if n := f.maxConcurrent(); n > 2 {
+		t.Errorf("Expected at most 2 concurrent scrapes, saw %d", n)
+	}
+}
+
+func TestScrapeTimeout(t *testing.T) {
+	fast := newFakeFPM(t, statusPage)
+	slow := newFakeFPM(t, statusPage)
+	slow.respondWith("", time.Second)
+
+	config := DefaultScraperConfig()
+	config.Timeout = 50 * time.Millisecond
+	start := time.Now()
+	snap := NewScraper(config).Scrape(context.Background(), []Pool{fast.pool("fast"), slow.pool("slow")})
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected slow pool to be cut off by its timeout, took %v", elapsed)
+	}
+	if snap.Results[0].Err != nil || !errors.Is(snap.Results[1].Err, fcgx.ErrTimeout) {
+		t.Errorf("Expected only the slow pool to time out, got %v", snap.Err())
+	}
+}
+
+func TestPoolsFromConfig(t *testing.T) {
+	fsys := fstest.MapFS{"etc/php-fpm.conf": {Data: []byte(`
+[www]
+listen = /run/php/www.sock
+pm.status_path = /status
+[api]
+listen = 9001
+pm.status_path = /fpm-status
+pm.status_listen = 127.0.0.1:9101
+[private]
+listen = 9002
+`)}}
+	conf, err := fpmconf.ParseFS(fsys, "/etc/php-fpm.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Pool{
+		{Name: "www", Network: "unix", Address: "/run/php/www.sock", StatusPath: "/status"},
+		{Name: "api", Network: "tcp", Address: "127.0.0.1:9101", StatusPath: "/fpm-status"},
+	}
+	got := PoolsFromConfig(conf)
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("PoolsFromConfig = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,159 @@
+// Package fpm monitors PHP-FPM pools through their status pages, fetched
+// directly over FastCGI with fcgx so the pages never have to be exposed
+// through a web server.
+//
+// Example usage:
+//
+//	scraper := fpm.NewScraper(nil)
+//	snap := scraper.Scrape(ctx, []fpm.Pool{
+//		{Name: "www", Network: "unix", Address: "/run/php/www.sock"},
+//		{Name: "api", Network: "tcp", Address: "127.0.0.1:9001"},
+//	})
+//	fmt.Println(snap.Totals.ActiveProcesses, "busy workers")
+//	if err := snap.Err(); err != nil {
+//		log.Print(err)
+//	}
+package fpm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gophpeek/fcgx"
+	"github.com/gophpeek/fcgx/fpmconf"
+)
+
+// DefaultStatusPath is the status path used when a Pool does not set one.
+const DefaultStatusPath = "/status"
+
+// Pool is a PHP-FPM pool whose status page can be scraped.
+type Pool struct {
+	Name       string // Label for results; the pool name reported by FPM is in Status
+	Network    string // "tcp" or "unix"
+	Address    string // Listener serving the status page
+	StatusPath string // pm.status_path; Default: "/status"
+}
+
+func (p Pool) String() string {
+	if p.Name != "" {
+		return p.Name
+	}
+	return p.Network + ":" + p.Address
+}
+
+// PoolsFromConfig returns the pools in conf that have a status page, using
+// pm.status_listen when it is configured.
+func PoolsFromConfig(conf *fpmconf.Config) []Pool {
+	var pools []Pool
+	for _, p := range conf.Pools {
+		ep := p.StatusEndpoint()
+		if ep == nil {
+			continue
+		}
+		pools = append(pools, Pool{Name: p.Name, Network: ep.Network, Address: ep.Address, StatusPath: ep.Path})
+	}
+	return pools
+}
+
+// Status is the pool status reported by the PHP-FPM status page.
+type Status struct {
+	Pool               string
+	ProcessManager     string // "static", "dynamic" or "ondemand"
+	StartTime          time.Time
+	StartSince         time.Duration
+	AcceptedConn       uint64
+	ListenQueue        int
+	MaxListenQueue     int
+	ListenQueueLen     int
+	IdleProcesses      int
+	ActiveProcesses    int
+	TotalProcesses     int
+	MaxActiveProcesses int
+	MaxChildrenReached uint64
+	SlowRequests       uint64
+
+	// Processes lists the pool's workers. It is only filled in by
+	// FetchFullStatus.
+	Processes []Process
+}
+
+// statusJSON is the JSON form of the status page.
+type statusJSON struct {
+	Pool               string    `json:"pool"`
+	ProcessManager     string    `json:"process manager"`
+	StartTime          int64     `json:"start time"`
+	StartSince         int64     `json:"start since"`
+	AcceptedConn       uint64    `json:"accepted conn"`
+	ListenQueue        int       `json:"listen queue"`
+	MaxListenQueue     int       `json:"max listen queue"`
+	ListenQueueLen     int       `json:"listen queue len"`
+	IdleProcesses      int       `json:"idle processes"`
+	ActiveProcesses    int       `json:"active processes"`
+	TotalProcesses     int       `json:"total processes"`
+	MaxActiveProcesses int       `json:"max active processes"`
+	MaxChildrenReached uint64    `json:"max children reached"`
+	SlowRequests       uint64    `json:"slow requests"`
+	Processes          []Process `json:"processes"`
+}
+
+// UnmarshalJSON decodes the "status?json" page, with or without "full".
+func (s *Status) UnmarshalJSON(data []byte) error {
+	var j statusJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*s = Status{
+		Pool:               j.Pool,
+		ProcessManager:     j.ProcessManager,
+		StartTime:          time.Unix(j.StartTime, 0),
+		StartSince:         time.Duration(j.StartSince) * time.Second,
+		AcceptedConn:       j.AcceptedConn,
+		ListenQueue:        j.ListenQueue,
+		MaxListenQueue:     j.MaxListenQueue,
+		ListenQueueLen:     j.ListenQueueLen,
+		IdleProcesses:      j.IdleProcesses,
+		ActiveProcesses:    j.ActiveProcesses,
+		TotalProcesses:     j.TotalProcesses,
+		MaxActiveProcesses: j.MaxActiveProcesses,
+		MaxChildrenReached: j.MaxChildrenReached,
+		SlowRequests:       j.SlowRequests,
+		Processes:          j.Processes,
+	}
+	return nil
+}
+
+// FetchStatus queries the status page at path over client. An empty path
+// uses DefaultStatusPath.
+func FetchStatus(ctx context.Context, client *fcgx.Client, path string) (*Status, error) {
+	var status Status
+	if err := fetch(ctx, client, path, "json", &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// fetch requests the status page with query and decodes the JSON into out.
+func fetch(ctx context.Context, client *fcgx.Client, path, query string, out any) error {
+	if path == "" {
+		path = DefaultStatusPath
+	}
+	resp, err := client.Get(ctx, map[string]string{
+		"SCRIPT_NAME":     path,
+		"SCRIPT_FILENAME": path,
+		"QUERY_STRING":    query,
+	})
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return fmt.Errorf("fpm: status page %s returned %s", path, resp.Status)
+	}
+	if err := fcgx.ReadJSON(resp, out); err != nil {
+		return fmt.Errorf("fpm: decoding status page %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package fpm
+
+import (
+	"sync"
+	"time"
+)
+
+// TrackerConfig holds configuration options for a Tracker.
+type TrackerConfig struct {
+	// Window is the period rates are computed over. One sample older than
+	// the window is kept so rates cover the whole window once enough
+	// samples have been observed.
+	// Default: 1 minute
+	Window time.Duration
+}
+
+// DefaultTrackerConfig returns a TrackerConfig with sensible defaults
+func DefaultTrackerConfig() *TrackerConfig {
+	return &TrackerConfig{Window: time.Minute}
+}
+
+// Rates are derived from the status samples of one pool within the window.
+// Counter rates are zero until two samples have been observed.
+type Rates struct {
+	Pool    string
+	Span    time.Duration // Time between the oldest and newest sample used
+	Samples int           // Samples used
+
+	// Restarted reports that PHP-FPM restarted, or its counters were reset,
+	// within the window. Counter deltas then only include the time since.
+	Restarted bool
+
+	RequestsPerSec     float64 // Accepted connections per second
+	SlowRequestsPerSec float64 // Requests exceeding request_slowlog_timeout per second
+
+	// SaturationEvents is how often the pool hit pm.max_children within the
+	// window.
+	SaturationEvents uint64
+
+	// Utilization is the share of worker processes busy in the newest
+	// sample, and AvgUtilization its mean over the window.
+	Utilization    float64
+	AvgUtilization float64
+
+	// ListenQueue is the newest number of connections waiting for a worker.
+	// QueuePressure is the share of samples in the window in which
+	// connections were waiting.
+	ListenQueue     int
+	AvgListenQueue  float64
+	PeakListenQueue int
+	QueuePressure   float64
+}
+
+// Tracker turns successive status snapshots into rates. It keeps recent
+// samples per pool and handles PHP-FPM restarts, detected by a changed
+// start time, and counters that go backwards.
+// All methods are thread-safe and can be called concurrently.
+type Tracker struct {
+	config *TrackerConfig
+
+	mu    sync.Mutex
+	pools map[string][]sample
+}
+
+// sample is one observed status with counters made monotonic across
+// restarts.
+type sample struct {
+	at          time.Time
+	status      Status
+	accepted    uint64
+	slow        uint64
+	maxChildren uint64
+	reset       bool // Counters were reset since the previous sample
+}
+
+// NewTracker creates an empty Tracker. A nil config uses
+// DefaultTrackerConfig.
+func NewTracker(config *TrackerConfig) *Tracker {
+	if config == nil {
+		config = DefaultTrackerConfig()
+	}
+	c := *config
+	if c.Window <= 0 {
+		c.Window = time.Minute
+	}
+	return &Tracker{config: &c, pools: make(map[string][]sample)}
+}
+
+// Observe records the status of pool taken at at and returns the pool's
+// rates. Samples older than the newest one already observed are ignored.
+func (t *Tracker) Observe(pool string, at time.Time, status *Status) Rates {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	samples := t.pools[pool]
+	s := sample{at: at, status: *status}
+	if n := len(samples); n > 0 {
+		prev := samples[n-1]
+		if !at.After(prev.at) {
+			return t.rates(pool, samples)
+		}
+		s.reset = !status.StartTime.Equal(prev.status.StartTime)
+		var reset bool
+		s.accepted, reset = advance(prev.accepted, prev.status.AcceptedConn, status.AcceptedConn, s.reset)
+		s.reset = s.reset || reset
+		s.slow, reset = advance(prev.slow, prev.status.SlowRequests, status.SlowRequests, s.reset)
+		s.reset = s.reset || reset
+		s.maxChildren, reset = advance(prev.maxChildren, prev.status.MaxChildrenReached, status.MaxChildrenReached, s.reset)
+		s.reset = s.reset || reset
+	}
+	samples = append(samples, s)
+
+	// Keep one sample at or before the start of the window as its anchor
+	cutoff := at.Add(-t.config.Window)
+	drop := 0
+	for drop+1 < len(samples) && !samples[drop+1].at.After(cutoff) {
+		drop++
+	}
+	samples = append(samples[:0], samples[drop:]...)
+	t.pools[pool] = samples
+	return t.rates(pool, samples)
+}
+
+// advance adds the change of a cumulative counter to its monotonic total.
+// A restart or a counter going backwards means it restarted from zero.
+func advance(total, prev, cur uint64, restarted bool) (uint64, bool) {
+	if restarted || cur < prev {
+		return total + cur, true
+	}
+	return total + cur - prev, false
+}
+
+// ObserveSnapshot records every pool scraped successfully in snap, keyed by
+// Pool.String(), and returns their rates.
+func (t *Tracker) ObserveSnapshot(snap *Snapshot) map[string]Rates {
+	rates := make(map[string]Rates)
+	for _, r := range snap.Results {
+		if r.Err != nil {
+			continue
+		}
+		name := r.Pool.String()
+		rates[name] = t.Observe(name, snap.Time, r.Status)
+	}
+	return rates
+}
+
+// Rates returns the current rates of pool, or false if it has not been
+// observed.
+func (t *Tracker) Rates(pool string) (Rates, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	samples, ok := t.pools[pool]
+	if !ok {
+		return Rates{}, false
+	}
+	return t.rates(pool, samples), true
+}
+
+// Forget drops the samples of pool, for example when it is removed.
+func (t *Tracker) Forget(pool string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.pools, pool)
+}
+
+// rates computes the rates of a pool from its samples.
+func (t *Tracker) rates(pool string, samples []sample) Rates {
+	first, last := samples[0], samples[len(samples)-1]
+	r := Rates{
+		Pool:        pool,
+		Span:        last.at.Sub(first.at),
+		Samples:     len(samples),
+		Utilization: utilization(last.status),
+		ListenQueue: last.status.ListenQueue,
+	}
+
+	queued := 0
+	for i, s := range samples {
+		if i > 0 && s.reset {
+			r.Restarted = true
+		}
+		r.AvgUtilization += utilization(s.status)
+		r.AvgListenQueue += float64(s.status.ListenQueue)
+		r.PeakListenQueue = max(r.PeakListenQueue, s.status.ListenQueue)
+		if s.status.ListenQueue > 0 {
+			queued++
+		}
+	}
+	n := float64(len(samples))
+	r.AvgUtilization /= n
+	r.AvgListenQueue /= n
+	r.QueuePressure = float64(queued) / n
+
+	r.SaturationEvents = last.maxChildren - first.maxChildren
+	if secs := r.Span.Seconds(); secs > 0 {
+		r.RequestsPerSec = float64(last.accepted-first.accepted) / secs
+		r.SlowRequestsPerSec = float64(last.slow-first.slow) / secs
+	}
+	return r
+}
+
+func utilization(s Status) float64 {
+	if s.TotalProcesses == 0 {
+		return 0
+	}
+	return float64(s.ActiveProcesses) / float64(s.TotalProcesses)
+}
@@ -0,0 +1,122 @@
+package fpm
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+var trackerStart = time.Unix(1700000000, 0)
+
+func at(sec int) time.Time {
+	return trackerStart.Add(time.Duration(sec) * time.Second)
+}
+
+func approx(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestTrackerRates(t *testing.T) {
+	tr := NewTracker(nil)
+	st := Status{StartTime: trackerStart, AcceptedConn: 1000, SlowRequests: 5, MaxChildrenReached: 1, ActiveProcesses: 2, TotalProcesses: 4}
+
+	r := tr.Observe("www", at(0), &st)
+	if r.Samples != 1 || r.RequestsPerSec != 0 || r.Utilization != 0.5 {
+		t.Errorf("Unexpected rates for a single sample %+v", r)
+	}
+
+	st.AcceptedConn, st.SlowRequests, st.MaxChildrenReached = 1300, 8, 3
+	st.ActiveProcesses, st.ListenQueue = 4, 6
+	r = tr.Observe("www", at(10), &st)
+	if !approx(r.RequestsPerSec, 30) || !approx(r.SlowRequestsPerSec, 0.3) || r.SaturationEvents != 2 {
+		t.Errorf("Unexpected counter rates %+v", r)
+	}
+	if r.Utilization != 1 || !approx(r.AvgUtilization, 0.75) || r.ListenQueue != 6 || r.PeakListenQueue != 6 ||
+		!approx(r.AvgListenQueue, 3) || !approx(r.QueuePressure, 0.5) || r.Restarted {
+		t.Errorf("Unexpected gauges %+v", r)
+	}
+
+	// Samples observed out of order are ignored
+	if r := tr.Observe("www", at(5), &st); r.Samples != 2 {
+		t.Errorf("Expected stale sample to be ignored, got %d samples", r.Samples)
+	}
+	if got, ok := tr.Rates("www"); !ok || got.Samples != 2 {
+		t.Errorf("Rates = %+v, %v", got, ok)
+	}
+	tr.Forget("www")
+	if _, ok := tr.Rates("www"); ok {
+		t.Error("Expected forgotten pool to have no rates")
+	}
+}
+
+func TestTrackerRestart(t *testing.T) {
+	tr := NewTracker(nil)
+	tr.Observe("www", at(0), &Status{StartTime: trackerStart, AcceptedConn: 5000, MaxChildrenReached: 7})
+
+	// FPM restarted at 5s and has accepted 200 connections since
+	restarted := Status{StartTime: at(5), AcceptedConn: 200}
+	r := tr.Observe("www", at(10), &restarted)
+	if !r.Restarted || !approx(r.RequestsPerSec, 20) || r.SaturationEvents != 0 {
+		t.Errorf("Unexpected rates across restart %+v", r)
+	}
+
+	restarted.AcceptedConn = 400
+	r = tr.Observe("www", at(20), &restarted)
+	if !approx(r.RequestsPerSec, 20) {
+		t.Errorf("Expected counting to continue after restart, got %+v", r)
+	}
+
+	// A counter going backwards without a new start time is a reset too
+	reset := Status{StartTime: at(5), AcceptedConn: 100}
+	r = tr.Observe("www", at(30), &reset)
+	if !r.Restarted || !approx(r.RequestsPerSec, 500.0/30) {
+		t.Errorf("Unexpected rates after counter reset %+v", r)
+	}
+}
+
+func TestTrackerWindow(t *testing.T) {
+	config := DefaultTrackerConfig()
+	config.Window = 30 * time.Second
+	tr := NewTracker(config)
+
+	st := Status{StartTime: trackerStart}
+	for sec := 0; sec <= 100; sec += 10 {
+		// 10 requests per second until 60s, 50 after
+		if sec > 60 {
+			st.AcceptedConn += 500
+		} else if sec > 0 {
+			st.AcceptedConn += 100
+		}
+		tr.Observe("www", at(sec), &st)
+	}
+
+	r, _ := tr.Rates("www")
+	if r.Span != 30*time.Second || r.Samples != 4 || !approx(r.RequestsPerSec, 50) {
+		t.Errorf("Expected rates over the last 30s only, got %+v", r)
+	}
+
+	// A restart that has left the window is no longer reported
+	tr.Observe("api", at(0), &Status{StartTime: trackerStart, AcceptedConn: 10})
+	tr.Observe("api", at(10), &Status{StartTime: at(5), AcceptedConn: 1})
+	if r := tr.Observe("api", at(50), &Status{StartTime: at(5), AcceptedConn: 1}); r.Restarted {
+		t.Errorf("Expected restart outside the window to be forgotten, got %+v", r)
+	}
+}
+
+func TestTrackerObserveSnapshot(t *testing.T) {
+	tr := NewTracker(nil)
+	snap := &Snapshot{
+		Time: at(0),
+		Results: []Result{
+			{Pool: Pool{Name: "www"}, Status: &Status{StartTime: trackerStart, AcceptedConn: 10}},
+			{Pool: Pool{Name: "dead"}, Err: &PoolError{}},
+		},
+	}
+	tr.ObserveSnapshot(snap)
+	snap.Time = at(2)
+	snap.Results[0].Status = &Status{StartTime: trackerStart, AcceptedConn: 30}
+	rates := tr.ObserveSnapshot(snap)
+	if len(rates) != 1 || !approx(rates["www"].RequestsPerSec, 10) {
+		t.Errorf("Unexpected snapshot rates %+v", rates)
+	}
+}
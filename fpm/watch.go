@@ -0,0 +1,288 @@
+package fpm
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Rule is a condition on the status of a pool that fires once it has held
+// for For and resolves once it has been false for ClearAfter. The two
+// durations keep a flapping condition from producing a stream of events.
+type Rule struct {
+	// Name identifies the rule in events.
+	Name string
+
+	// Check reports whether the condition holds. cur is nil when the pool
+	// could not be scraped, which the rules in this package other than
+	// Unreachable treat as not holding. prev is the pool's previous
+	// successful status, or nil before the first one.
+	Check func(cur, prev *Status) bool
+
+	// For is how long the condition must hold before the rule fires. Zero
+	// fires on the first poll it holds.
+	For time.Duration
+
+	// ClearAfter is how long the condition must be false before a firing
+	// rule resolves. Zero resolves on the first poll it is false.
+	ClearAfter time.Duration
+}
+
+// ListenQueueAbove fires when more than n connections have been waiting for
+// a worker for d.
+func ListenQueueAbove(n int, d time.Duration) Rule {
+	return Rule{
+		Name: fmt.Sprintf("listen_queue_above_%d", n),
+		Check: func(cur, _ *Status) bool {
+			return cur != nil && cur.ListenQueue > n
+		},
+		For: d,
+	}
+}
+
+// NoIdleProcesses fires when the pool has had no idle worker for d.
+func NoIdleProcesses(d time.Duration) Rule {
+	return Rule{
+		Name: "no_idle_processes",
+		Check: func(cur, _ *Status) bool {
+			return cur != nil && cur.TotalProcesses > 0 && cur.IdleProcesses == 0
+		},
+		For: d,
+	}
+}
+
+// MaxChildrenReached fires when the pool hit pm.max_children since the
+// previous poll.
+func MaxChildrenReached() Rule {
+	return Rule{
+		Name: "max_children_reached",
+		Check: func(cur, prev *Status) bool {
+			// A restart resets the counter, so only compare the same run
+			return cur != nil && prev != nil && cur.StartTime.Equal(prev.StartTime) &&
+				cur.MaxChildrenReached > prev.MaxChildrenReached
+		},
+	}
+}
+
+// Unreachable fires when the pool's status page could not be scraped for d.
+func Unreachable(d time.Duration) Rule {
+	return Rule{
+		Name: "unreachable",
+		Check: func(cur, _ *Status) bool {
+			return cur == nil
+		},
+		For: d,
+	}
+}
+
+// WatchConfig holds configuration options for Watch.
+type WatchConfig struct {
+	// Interval is the time between polls.
+	// Default: 10 seconds
+	Interval time.Duration
+
+	// Rules are evaluated against every pool on each poll.
+	// Default: ListenQueueAbove(0, 30s), NoIdleProcesses(30s),
+	// MaxChildrenReached() and Unreachable(30s), each with a ClearAfter of
+	// 1 minute
+	Rules []Rule
+
+	// ScraperConfig configures the polls.
+	// Default: DefaultScraperConfig()
+	ScraperConfig *ScraperConfig
+}
+
+// DefaultWatchConfig returns a WatchConfig with sensible defaults
+func DefaultWatchConfig() *WatchConfig {
+	rules := []Rule{
+		ListenQueueAbove(0, 30*time.Second),
+		NoIdleProcesses(30 * time.Second),
+		MaxChildrenReached(),
+		Unreachable(30 * time.Second),
+	}
+	for i := range rules {
+		rules[i].ClearAfter = time.Minute
+	}
+	return &WatchConfig{
+		Interval:      10 * time.Second,
+		Rules:         rules,
+		ScraperConfig: DefaultScraperConfig(),
+	}
+}
+
+// EventKind tells whether a rule started or stopped firing.
+type EventKind int
+
+const (
+	EventFiring EventKind = iota + 1
+	EventResolved
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventFiring:
+		return "firing"
+	case EventResolved:
+		return "resolved"
+	default:
+		return fmt.Sprintf("EventKind(%d)", int(k))
+	}
+}
+
+// Event reports a rule changing state for a pool.
+type Event struct {
+	Kind EventKind
+	Rule string
+	Pool string    // Pool.String()
+	Time time.Time // Poll that changed the state
+
+	// Since is when the condition started holding for a firing event, or
+	// stopped holding for a resolved one.
+	Since time.Time
+
+	Status *Status // Status at Time, nil when the pool could not be scraped
+	Err    error   // Scrape error, if any
+}
+
+// Watch polls pools every Interval until ctx is done and sends an Event on
+// the returned channel whenever a rule starts or stops firing. Polling waits
+// for events to be received, so the channel must be drained. It is closed
+// once ctx is done. A nil config uses DefaultWatchConfig.
+func Watch(ctx context.Context, pools []Pool, config *WatchConfig) <-chan Event {
+	events := make(chan Event, 16)
+	go func() {
+		defer close(events)
+		WatchFunc(ctx, pools, config, func(e Event) {
+			select {
+			case events <- e:
+			case <-ctx.Done():
+			}
+		})
+	}()
+	return events
+}
+
+// WatchFunc is like Watch but calls fn for each event instead. It blocks
+// until ctx is done and returns ctx.Err().
+func WatchFunc(ctx context.Context, pools []Pool, config *WatchConfig, fn func(Event)) error {
+	w := newWatcher(config)
+	scraper := NewScraper(w.config.ScraperConfig)
+	ticker := time.NewTicker(w.config.Interval)
+	defer ticker.Stop()
+	for {
+		snap := scraper.Scrape(ctx, pools)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		for _, e := range w.observe(snap) {
+			fn(e)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// watcher holds the rule states of every pool between polls.
+type watcher struct {
+	config *WatchConfig
+	prev   map[string]*Status
+	states map[ruleKey]*ruleState
+}
+
+type ruleKey struct {
+	pool string
+	rule int // Index into Rules
+}
+
+// ruleState tracks a rule for one pool. pending is only set while the rule
+// is not firing, and clearing only while it is.
+type ruleState struct {
+	firing   bool
+	pending  time.Time // Condition started holding while not firing
+	clearing time.Time // Condition stopped holding while firing
+}
+
+func newWatcher(config *WatchConfig) *watcher {
+	if config == nil {
+		config = DefaultWatchConfig()
+	}
+	c := *config
+	if c.Interval <= 0 {
+		c.Interval = 10 * time.Second
+	}
+	if c.Rules == nil {
+		c.Rules = DefaultWatchConfig().Rules
+	}
+	return &watcher{
+		config: &c,
+		prev:   make(map[string]*Status),
+		states: make(map[ruleKey]*ruleState),
+	}
+}
+
+// observe evaluates every rule against snap and returns the resulting
+// events, in pool and rule order.
+func (w *watcher) observe(snap *Snapshot) []Event {
+	var events []Event
+	now := snap.Time
+	for _, r := range snap.Results {
+		pool := r.Pool.String()
+		prev := w.prev[pool]
+		for i, rule := range w.config.Rules {
+			key := ruleKey{pool, i}
+			st := w.states[key]
+			if st == nil {
+				st = &ruleState{}
+				w.states[key] = st
+			}
+			kind, since := st.update(rule, rule.Check(r.Status, prev), now)
+			if kind != 0 {
+				events = append(events, Event{
+					Kind: kind, Rule: rule.Name, Pool: pool, Time: now,
+					Since: since, Status: r.Status, Err: r.Err,
+				})
+			}
+		}
+		if r.Status != nil {
+			w.prev[pool] = r.Status
+		}
+	}
+	return events
+}
+
+// update advances the state with the condition observed at now and returns
+// the kind of event it caused, if any.
+func (st *ruleState) update(rule Rule, holds bool, now time.Time) (EventKind, time.Time) {
+	if !st.firing {
+		if !holds {
+			st.pending = time.Time{}
+			return 0, time.Time{}
+		}
+		if st.pending.IsZero() {
+			st.pending = now
+		}
+		if now.Sub(st.pending) < rule.For {
+			return 0, time.Time{}
+		}
+		since := st.pending
+		st.firing, st.pending = true, time.Time{}
+		return EventFiring, since
+	}
+
+	if holds {
+		st.clearing = time.Time{}
+		return 0, time.Time{}
+	}
+	if st.clearing.IsZero() {
+		st.clearing = now
+	}
+	if now.Sub(st.clearing) < rule.ClearAfter {
+		return 0, time.Time{}
+	}
+	since := st.clearing
+	st.firing, st.clearing = false, time.Time{}
+	return EventResolved, since
+}
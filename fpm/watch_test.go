@@ -0,0 +1,107 @@
+package fpm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func watchSnapshot(sec int, st *Status) *Snapshot {
+	r := Result{Pool: Pool{Name: "www"}, Status: st}
+	if st == nil {
+		r.Err = &PoolError{Pool: r.Pool, Err: errors.New("connection refused")}
+	}
+	return &Snapshot{Time: at(sec), Results: []Result{r}}
+}
+
+func TestWatchHysteresis(t *testing.T) {
+	rule := ListenQueueAbove(5, 20*time.Second)
+	rule.ClearAfter = 20 * time.Second
+	w := newWatcher(&WatchConfig{Rules: []Rule{rule}})
+
+	queue := []int{10, 10, 0, 10, 10, 10, 0, 10, 0, 0, 0}
+	var got []string
+	for i, q := range queue {
+		st := &Status{StartTime: trackerStart, ListenQueue: q}
+		for _, e := range w.observe(watchSnapshot(i*10, st)) {
+			if e.Rule != "listen_queue_above_5" || e.Pool != "www" || e.Status != st {
+				t.Errorf("Unexpected event %+v", e)
+			}
+			got = append(got, e.Kind.String()+"@"+e.Time.Sub(trackerStart).String()+"/"+e.Since.Sub(trackerStart).String())
+		}
+	}
+	// The queue drops at 20s before holding for 20s, fires at 50s after
+	// holding since 30s, and only resolves once it has been empty from 80s
+	want := []string{"firing@50s/30s", "resolved@1m40s/1m20s"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("Events = %v, want %v", got, want)
+	}
+}
+
+func TestWatchRules(t *testing.T) {
+	w := newWatcher(&WatchConfig{Rules: []Rule{NoIdleProcesses(0), MaxChildrenReached(), Unreachable(10 * time.Second)}})
+	fired := func(events []Event) map[string]EventKind {
+		m := make(map[string]EventKind)
+		for _, e := range events {
+			m[e.Rule] = e.Kind
+		}
+		return m
+	}
+
+	busy := &Status{StartTime: trackerStart, TotalProcesses: 5, ActiveProcesses: 5, MaxChildrenReached: 2}
+	if got := fired(w.observe(watchSnapshot(0, busy))); len(got) != 1 || got["no_idle_processes"] != EventFiring {
+		t.Errorf("Expected only no idle processes to fire on the first poll, got %v", got)
+	}
+
+	more := *busy
+	more.MaxChildrenReached = 3
+	if got := fired(w.observe(watchSnapshot(10, &more))); len(got) != 1 || got["max_children_reached"] != EventFiring {
+		t.Errorf("Expected max children reached to fire, got %v", got)
+	}
+
+	// The other conditions do not hold while the pool is unreachable
+	got := fired(w.observe(watchSnapshot(20, nil)))
+	if len(got) != 2 || got["no_idle_processes"] != EventResolved || got["max_children_reached"] != EventResolved {
+		t.Errorf("Expected the other rules to resolve, got %v", got)
+	}
+	events := w.observe(watchSnapshot(30, nil))
+	if got := fired(events); len(got) != 1 || got["unreachable"] != EventFiring {
+		t.Errorf("Expected unreachable to fire, got %v", got)
+	}
+	if events[0].Err == nil || events[0].Status != nil {
+		t.Errorf("Expected scrape error in events, got %+v", events[0])
+	}
+
+	// A restart resets the counter without counting as reaching the limit,
+	// and a failed scrape keeps the previous status for comparison
+	restarted := &Status{StartTime: at(25), TotalProcesses: 5, IdleProcesses: 5, MaxChildrenReached: 4}
+	got = fired(w.observe(watchSnapshot(40, restarted)))
+	if got["unreachable"] != EventResolved || got["max_children_reached"] != 0 {
+		t.Errorf("Unexpected events after restart %v", got)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	f := newFakeFPM(t, statusPage)
+	config := DefaultWatchConfig()
+	config.Interval = 10 * time.Millisecond
+	config.Rules = []Rule{ListenQueueAbove(1, 0)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := Watch(ctx, []Pool{f.pool("www")}, config)
+
+	select {
+	case e := <-events:
+		if e.Kind != EventFiring || e.Status == nil || e.Status.ListenQueue != 2 {
+			t.Errorf("Unexpected event %+v", e)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for an event")
+	}
+
+	cancel()
+	for range events {
+	}
+}
@@ -0,0 +1,54 @@
+package fpmconf
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// DefaultPaths are glob patterns for the main PHP-FPM configuration file in
+// common distributions and the official Docker images.
+var DefaultPaths = []string{
+	"/etc/php/*/fpm/php-fpm.conf",          // Debian, Ubuntu
+	"/etc/php-fpm.conf",                    // RHEL, Fedora
+	"/etc/php*/php-fpm.conf",               // Alpine, Arch
+	"/usr/local/etc/php-fpm.conf",          // Official Docker images, FreeBSD
+	"/opt/remi/php*/root/etc/php-fpm.conf", // Remi software collections
+}
+
+// Discover parses every PHP-FPM configuration found at DefaultPaths. It
+// returns the configurations that could be parsed along with an error
+// joining the failures, so one broken installation does not hide the others.
+func Discover() ([]*Config, error) {
+	return DiscoverFS(os.DirFS("/"), DefaultPaths)
+}
+
+// DiscoverFS is like Discover but searches fsys, which must be rooted at "/",
+// for the given absolute glob patterns.
+func DiscoverFS(fsys fs.FS, patterns []string) ([]*Config, error) {
+	var confs []*Config
+	var errs []error
+	seen := map[string]bool{}
+	for _, pattern := range patterns {
+		matches, err := fs.Glob(fsys, strings.TrimPrefix(pattern, "/"))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, m := range matches {
+			name := "/" + m
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			conf, err := ParseFS(fsys, name)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			confs = append(confs, conf)
+		}
+	}
+	return confs, errors.Join(errs...)
+}
@@ -0,0 +1,353 @@
+// Package fpmconf parses PHP-FPM configuration files to find the pools on a
+// host and where they listen, so a monitoring agent can create fcgx clients
+// for every pool without being told about them.
+//
+// It understands the INI dialect of php-fpm.conf: the [global] section, one
+// section per pool, include directives with glob patterns, $pool
+// substitution and ${ENV} variables. Sections repeated across files are
+// merged, as PHP-FPM does.
+//
+// Example usage:
+//
+//	conf, err := fpmconf.Parse("/etc/php/8.3/fpm/php-fpm.conf")
+//	if err != nil {
+//		return err
+//	}
+//	for _, pool := range conf.Pools {
+//		client, err := pool.DialContext(ctx, nil)
+//		...
+//	}
+package fpmconf
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gophpeek/fcgx"
+)
+
+// maxIncludeDepth guards against include cycles.
+const maxIncludeDepth = 10
+
+// Config is a parsed PHP-FPM configuration.
+type Config struct {
+	// Path is the main configuration file.
+	Path string
+
+	// Global holds the directives of the [global] section.
+	Global map[string]string
+
+	// Pools lists the pools in the order they are first defined.
+	Pools []*Pool
+
+	// Files lists every file read, in order.
+	Files []string
+}
+
+// Pool is the configuration of a single PHP-FPM pool.
+type Pool struct {
+	Name string
+	File string // File the pool was first defined in
+
+	// Listen is the listen directive, with a relative socket path made
+	// absolute. Network and Address are its dialable form: wildcard and
+	// port-only listen addresses are dialed on the loopback interface.
+	Listen  string
+	Network string
+	Address string
+
+	// StatusPath and PingPath are pm.status_path and ping.path, empty when
+	// disabled. StatusListen is pm.status_listen, a dedicated listener for
+	// the status page.
+	StatusPath   string
+	StatusListen string
+	PingPath     string
+	PingResponse string // Default: "pong"
+
+	// Process manager settings.
+	PM              string // "static", "dynamic" or "ondemand"
+	MaxChildren     int
+	StartServers    int
+	MinSpareServers int
+	MaxSpareServers int
+	MaxRequests     int
+
+	// Directives holds every directive of the pool section, after
+	// substitution, including php_value[...] and env[...] entries.
+	Directives map[string]string
+}
+
+// Pool returns the pool named name, or nil.
+func (c *Config) Pool(name string) *Pool {
+	for _, p := range c.Pools {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// StatusEndpoint returns where to query the pool's status page, using
+// pm.status_listen when it is set, or nil when the status page is disabled.
+// It can be used as fcgx.Config.SlowRequestStatus.
+func (p *Pool) StatusEndpoint() *fcgx.StatusEndpoint {
+	if p.StatusPath == "" {
+		return nil
+	}
+	ep := &fcgx.StatusEndpoint{Network: p.Network, Address: p.Address, Path: p.StatusPath}
+	if p.StatusListen != "" {
+		ep.Network, ep.Address = ListenAddress(p.StatusListen)
+	}
+	return ep
+}
+
+// DialContext connects an fcgx client to the pool. A nil config uses
+// fcgx.DefaultConfig.
+func (p *Pool) DialContext(ctx context.Context, config *fcgx.Config) (*fcgx.Client, error) {
+	return fcgx.DialContextWithConfig(ctx, p.Network, p.Address, config)
+}
+
+// ListenAddress converts a PHP-FPM listen directive to a network and address
+// that can be dialed. A port on its own and wildcard hosts map to the
+// loopback interface.
+func ListenAddress(listen string) (network, address string) {
+	if strings.HasPrefix(listen, "/") || !strings.Contains(listen, ":") && !isPort(listen) {
+		return "unix", listen
+	}
+	if isPort(listen) {
+		return "tcp", net.JoinHostPort("127.0.0.1", listen)
+	}
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return "tcp", listen
+	}
+	switch host {
+	case "", "0.0.0.0", "*":
+		host = "127.0.0.1"
+	case "::":
+		host = "::1"
+	}
+	return "tcp", net.JoinHostPort(host, port)
+}
+
+func isPort(s string) bool {
+	n, err := strconv.Atoi(s)
+	return err == nil && n > 0 && n < 65536
+}
+
+// Parse reads the PHP-FPM configuration at path and every file it includes.
+// Relative include patterns are resolved against the installation prefix,
+// taken to be the parent of the directory holding path (/usr/local for
+// /usr/local/etc/php-fpm.conf).
+func Parse(path string) (*Config, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseFS(os.DirFS("/"), filepath.ToSlash(abs))
+}
+
+// ParseFS is like Parse but reads from fsys, which must be rooted at "/".
+// name is an absolute slash-separated path.
+func ParseFS(fsys fs.FS, name string) (*Config, error) {
+	p := &parser{
+		fsys:   fsys,
+		prefix: path.Dir(path.Dir(name)),
+		conf:   &Config{Path: name, Global: map[string]string{}},
+	}
+	if err := p.parseFile(name, 0); err != nil {
+		return nil, err
+	}
+	for _, pool := range p.conf.Pools {
+		if err := pool.resolve(p.prefix); err != nil {
+			return nil, err
+		}
+	}
+	return p.conf, nil
+}
+
+type parser struct {
+	fsys   fs.FS
+	prefix string
+	conf   *Config
+
+	// pool is the pool section being parsed, nil in [global].
+	pool *Pool
+}
+
+// readFile reads the file at the absolute path name.
+func (p *parser) readFile(name string) ([]byte, error) {
+	return fs.ReadFile(p.fsys, strings.TrimPrefix(name, "/"))
+}
+
+func (p *parser) parseFile(name string, depth int) error {
+	if depth > maxIncludeDepth {
+		return fmt.Errorf("fpmconf: %s: includes nested too deeply", name)
+	}
+	data, err := p.readFile(name)
+	if err != nil {
+		return fmt.Errorf("fpmconf: %w", err)
+	}
+	p.conf.Files = append(p.conf.Files, name)
+
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == ';' || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' {
+			end := strings.IndexByte(line, ']')
+			if end < 0 {
+				return fmt.Errorf("fpmconf: %s:%d: unterminated section %q", name, i+1, line)
+			}
+			p.section(strings.TrimSpace(line[1:end]), name)
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("fpmconf: %s:%d: expected key = value, got %q", name, i+1, line)
+		}
+		key = strings.TrimSpace(key)
+		value, err := parseValue(value)
+		if err != nil {
+			return fmt.Errorf("fpmconf: %s:%d: %v", name, i+1, err)
+		}
+		value = expandEnv(value)
+
+		if key == "include" {
+			if err := p.include(value, depth); err != nil {
+				return err
+			}
+			continue
+		}
+		if p.pool == nil {
+			p.conf.Global[key] = value
+			continue
+		}
+		p.pool.Directives[key] = strings.ReplaceAll(value, "$pool", p.pool.Name)
+	}
+	return nil
+}
+
+// section switches to the section called name, merging repeated pools.
+func (p *parser) section(name, file string) {
+	if name == "global" {
+		p.pool = nil
+		return
+	}
+	if p.pool = p.conf.Pool(name); p.pool == nil {
+		p.pool = &Pool{Name: name, File: file, Directives: map[string]string{}}
+		p.conf.Pools = append(p.conf.Pools, p.pool)
+	}
+}
+
+// include parses every file matching pattern, in lexical order.
+func (p *parser) include(pattern string, depth int) error {
+	if !path.IsAbs(pattern) {
+		pattern = path.Join(p.prefix, pattern)
+	}
+	matches, err := fs.Glob(p.fsys, strings.TrimPrefix(pattern, "/"))
+	if err != nil {
+		return fmt.Errorf("fpmconf: include %s: %w", pattern, err)
+	}
+	for _, m := range matches {
+		if err := p.parseFile("/"+m, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseValue unquotes a value and strips a trailing comment.
+func parseValue(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s != "" && (s[0] == '"' || s[0] == '\'') {
+		end := strings.IndexByte(s[1:], s[0])
+		if end < 0 {
+			return "", fmt.Errorf("unterminated quoted value %s", s)
+		}
+		return s[1 : end+1], nil
+	}
+	if i := strings.IndexByte(s, ';'); i >= 0 {
+		s = strings.TrimSpace(s[:i])
+	}
+	return s, nil
+}
+
+// expandEnv replaces ${NAME} with the environment variable NAME, as
+// PHP-FPM does. $pool is left for the pool section to substitute.
+func expandEnv(s string) string {
+	for {
+		start := strings.Index(s, "${")
+		if start < 0 {
+			return s
+		}
+		end := strings.IndexByte(s[start:], '}')
+		if end < 0 {
+			return s
+		}
+		s = s[:start] + os.Getenv(s[start+2:start+end]) + s[start+end+1:]
+	}
+}
+
+// resolve fills the typed fields from the directives.
+func (pool *Pool) resolve(prefix string) error {
+	d := pool.Directives
+	pool.Listen = resolveListen(prefix, d["listen"])
+	if pool.Listen == "" {
+		return fmt.Errorf("fpmconf: pool %s: no listen address", pool.Name)
+	}
+	pool.Network, pool.Address = ListenAddress(pool.Listen)
+
+	pool.StatusPath = d["pm.status_path"]
+	pool.StatusListen = resolveListen(prefix, d["pm.status_listen"])
+	pool.PingPath = d["ping.path"]
+	pool.PingResponse = d["ping.response"]
+	if pool.PingResponse == "" {
+		pool.PingResponse = "pong"
+	}
+	pool.PM = d["pm"]
+
+	ints := []struct {
+		key string
+		dst *int
+	}{
+		{"pm.max_children", &pool.MaxChildren},
+		{"pm.start_servers", &pool.StartServers},
+		{"pm.min_spare_servers", &pool.MinSpareServers},
+		{"pm.max_spare_servers", &pool.MaxSpareServers},
+		{"pm.max_requests", &pool.MaxRequests},
+	}
+	for _, f := range ints {
+		v, ok := d[f.key]
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("fpmconf: pool %s: invalid %s %q", pool.Name, f.key, v)
+		}
+		*f.dst = n
+	}
+	if pool.PM == "dynamic" && pool.StartServers == 0 {
+		// PHP-FPM's default
+		pool.StartServers = pool.MinSpareServers + (pool.MaxSpareServers-pool.MinSpareServers)/2
+	}
+	return nil
+}
+
+// resolveListen makes a relative socket path relative to prefix.
+func resolveListen(prefix, listen string) string {
+	if listen == "" || strings.HasPrefix(listen, "/") || strings.Contains(listen, ":") || isPort(listen) {
+		return listen
+	}
+	return path.Join(prefix, listen)
+}
@@ -0,0 +1,186 @@
+package fpmconf
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// dockerFS mirrors the layout of the official php:fpm Docker image, whose
+// zz-docker.conf overrides the listen address of the www pool.
+var dockerFS = fstest.MapFS{
+	"usr/local/etc/php-fpm.conf": {Data: []byte(`
+[global]
+include=etc/php-fpm.d/*.conf
+`)},
+	"usr/local/etc/php-fpm.d/www.conf": {Data: []byte(`
+; Start a new pool named 'www'.
+[www]
+user = www-data
+listen = 127.0.0.1:9000
+pm = dynamic
+pm.max_children = 5
+pm.min_spare_servers = 1
+pm.max_spare_servers = 3
+;pm.status_path = /status
+`)},
+	"usr/local/etc/php-fpm.d/zz-docker.conf": {Data: []byte(`
+[global]
+daemonize = no
+
+[www]
+listen = 9000
+`)},
+}
+
+func TestParseDocker(t *testing.T) {
+	conf, err := ParseFS(dockerFS, "/usr/local/etc/php-fpm.conf")
+	if err != nil {
+		t.Fatalf("ParseFS failed: %v", err)
+	}
+	if len(conf.Pools) != 1 {
+		t.Fatalf("Expected one merged pool, got %d", len(conf.Pools))
+	}
+	www := conf.Pool("www")
+	if www.Listen != "9000" || www.Network != "tcp" || www.Address != "127.0.0.1:9000" {
+		t.Errorf("Unexpected listen %q -> %s %s", www.Listen, www.Network, www.Address)
+	}
+	if www.PM != "dynamic" || www.MaxChildren != 5 || www.StartServers != 2 || www.MaxSpareServers != 3 {
+		t.Errorf("Unexpected pm settings %+v", www)
+	}
+	if www.StatusPath != "" || www.StatusEndpoint() != nil {
+		t.Errorf("Expected commented out status path to be ignored, got %q", www.StatusPath)
+	}
+	if www.File != "/usr/local/etc/php-fpm.d/www.conf" || conf.Global["daemonize"] != "no" {
+		t.Errorf("Unexpected file %q or globals %v", www.File, conf.Global)
+	}
+	wantFiles := []string{
+		"/usr/local/etc/php-fpm.conf",
+		"/usr/local/etc/php-fpm.d/www.conf",
+		"/usr/local/etc/php-fpm.d/zz-docker.conf",
+	}
+	if !reflect.DeepEqual(conf.Files, wantFiles) {
+		t.Errorf("Files = %v, want %v", conf.Files, wantFiles)
+	}
+}
+
+func TestParsePools(t *testing.T) {
+	t.Setenv("FPM_CHILDREN", "12")
+	fsys := fstest.MapFS{
+		"etc/php/8.3/fpm/php-fpm.conf": {Data: []byte(`
+[global]
+pid = /run/php/php8.3-fpm.pid
+include=/etc/php/8.3/fpm/pool.d/*.conf
+`)},
+		"etc/php/8.3/fpm/pool.d/api.conf": {Data: []byte(`
+[api]
+listen = /run/php/$pool.sock ; per-pool socket
+listen.owner = www-data
+pm = static
+pm.max_children = ${FPM_CHILDREN}
+pm.max_requests = 500
+pm.status_path = /status
+pm.status_listen = /run/php/$pool-status.sock
+ping.path = /ping
+php_admin_value[error_log] = /var/log/fpm/$pool.log
+`)},
+		"etc/php/8.3/fpm/pool.d/shop.conf": {Data: []byte(`
+[shop]
+listen = "[::]:9001"
+pm = ondemand
+pm.max_children = 4
+pm.status_path = /fpm-status
+ping.path = /ping
+ping.response = "ok ; not a comment"
+`)},
+	}
+
+	conf, err := ParseFS(fsys, "/etc/php/8.3/fpm/php-fpm.conf")
+	if err != nil {
+		t.Fatalf("ParseFS failed: %v", err)
+	}
+	if len(conf.Pools) != 2 || conf.Pools[0].Name != "api" || conf.Pools[1].Name != "shop" {
+		t.Fatalf("Unexpected pools %v", conf.Pools)
+	}
+
+	api := conf.Pools[0]
+	if api.Network != "unix" || api.Address != "/run/php/api.sock" {
+		t.Errorf("Unexpected api listen %s %s", api.Network, api.Address)
+	}
+	if api.PM != "static" || api.MaxChildren != 12 || api.MaxRequests != 500 || api.PingResponse != "pong" {
+		t.Errorf("Unexpected api settings %+v", api)
+	}
+	if got := api.Directives["php_admin_value[error_log]"]; got != "/var/log/fpm/api.log" {
+		t.Errorf("Unexpected php_admin_value %q", got)
+	}
+	if ep := api.StatusEndpoint(); ep == nil || ep.Network != "unix" || ep.Address != "/run/php/api-status.sock" || ep.Path != "/status" {
+		t.Errorf("Expected status endpoint on the status listener, got %+v", ep)
+	}
+
+	shop := conf.Pools[1]
+	if shop.Network != "tcp" || shop.Address != "[::1]:9001" {
+		t.Errorf("Unexpected shop listen %s %s", shop.Network, shop.Address)
+	}
+	if shop.PingResponse != "ok ; not a comment" {
+		t.Errorf("Unexpected quoted value %q", shop.PingResponse)
+	}
+	if ep := shop.StatusEndpoint(); ep == nil || ep.Address != "[::1]:9001" || ep.Path != "/fpm-status" {
+		t.Errorf("Expected status endpoint on the pool listener, got %+v", ep)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		conf string
+		want string
+	}{
+		{"syntax", "[www]\nlisten\n", "php-fpm.conf:2: expected key = value"},
+		{"section", "[www\n", "unterminated section"},
+		{"quote", "[www]\nlisten = \"/run/a.sock\n", "unterminated quoted value"},
+		{"listen", "[www]\npm = static\n", "pool www: no listen address"},
+		{"number", "[www]\nlisten = 9000\npm.max_children = many\n", "invalid pm.max_children"},
+		{"cycle", "include = /etc/php-fpm.conf\n", "nested too deeply"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := fstest.MapFS{"etc/php-fpm.conf": {Data: []byte(tt.conf)}}
+			_, err := ParseFS(fsys, "/etc/php-fpm.conf")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestListenAddress(t *testing.T) {
+	tests := []struct{ listen, network, address string }{
+		{"/run/php/fpm.sock", "unix", "/run/php/fpm.sock"},
+		{"9000", "tcp", "127.0.0.1:9000"},
+		{"127.0.0.1:9000", "tcp", "127.0.0.1:9000"},
+		{"0.0.0.0:9000", "tcp", "127.0.0.1:9000"},
+		{"[::]:9000", "tcp", "[::1]:9000"},
+		{"php:9000", "tcp", "php:9000"},
+	}
+	for _, tt := range tests {
+		if network, address := ListenAddress(tt.listen); network != tt.network || address != tt.address {
+			t.Errorf("ListenAddress(%q) = %s %s, want %s %s", tt.listen, network, address, tt.network, tt.address)
+		}
+	}
+}
+
+func TestDiscoverFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"etc/php/8.2/fpm/php-fpm.conf": {Data: []byte("[www]\nlisten = /run/php/php8.2-fpm.sock\n")},
+		"etc/php/8.3/fpm/php-fpm.conf": {Data: []byte("[www]\nlisten = /run/php/php8.3-fpm.sock\n")},
+		"etc/php/7.4/fpm/php-fpm.conf": {Data: []byte("[www\n")},
+	}
+	confs, err := DiscoverFS(fsys, DefaultPaths)
+	if err == nil || !strings.Contains(err.Error(), "7.4") {
+		t.Errorf("Expected broken configuration to be reported, got %v", err)
+	}
+	if len(confs) != 2 || confs[0].Pools[0].Address != "/run/php/php8.2-fpm.sock" || confs[1].Pools[0].Address != "/run/php/php8.3-fpm.sock" {
+		t.Errorf("Unexpected configurations %+v", confs)
+	}
+}
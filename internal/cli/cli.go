@@ -0,0 +1,117 @@
+// Package cli holds the address and request flag handling shared by the
+// fcgx and fcgx-bench commands.
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// MultiFlag collects a flag that may be repeated.
+type MultiFlag []string
+
+func (f *MultiFlag) String() string { return strings.Join(*f, ", ") }
+
+func (f *MultiFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+// ParseAddress splits an address argument into network and address. It
+// accepts "unix:/path", "tcp:host:port", a socket path starting with "/" or
+// "." and host:port.
+func ParseAddress(s string) (network, address string) {
+	if rest, ok := strings.CutPrefix(s, "unix:"); ok {
+		return "unix", rest
+	}
+	if rest, ok := strings.CutPrefix(s, "tcp:"); ok {
+		return "tcp", rest
+	}
+	if strings.HasPrefix(s, "/") || strings.HasPrefix(s, ".") {
+		return "unix", s
+	}
+	return "tcp", s
+}
+
+// Request holds the flags describing the request a command sends.
+type Request struct {
+	Script  string
+	Method  string
+	Query   string
+	Data    string
+	Headers MultiFlag
+	Params  MultiFlag
+}
+
+// AddFlags registers -script, -method, -query, -d, -H and -param on fs.
+// dataUsage describes the forms -d accepts.
+func (r *Request) AddFlags(fs *flag.FlagSet, dataUsage string) {
+	fs.StringVar(&r.Script, "script", "", "script to run, sent as SCRIPT_FILENAME and SCRIPT_NAME")
+	fs.StringVar(&r.Method, "method", "", "request method (default GET, or POST with -d)")
+	fs.StringVar(&r.Query, "query", "", "query string, without the leading '?'")
+	fs.StringVar(&r.Data, "d", "", "request body; "+dataUsage)
+	fs.Var(&r.Headers, "H", "request header 'Name: value', sent as HTTP_NAME (repeatable)")
+	fs.Var(&r.Params, "param", "raw FastCGI param K=V, overriding any other (repeatable)")
+}
+
+// BuildParams builds the FastCGI params from the flags, with software as
+// SERVER_SOFTWARE. Raw -param values are applied last so they can override
+// anything else.
+func (r *Request) BuildParams(software string) (map[string]string, error) {
+	method := strings.ToUpper(r.Method)
+	if method == "" {
+		method = "GET"
+		if r.Data != "" {
+			method = "POST"
+		}
+	}
+	uri := r.Script
+	if r.Query != "" {
+		uri += "?" + r.Query
+	}
+	params := map[string]string{
+		"GATEWAY_INTERFACE": "CGI/1.1",
+		"SERVER_PROTOCOL":   "HTTP/1.1",
+		"SERVER_SOFTWARE":   software,
+		"REQUEST_METHOD":    method,
+		"SCRIPT_FILENAME":   r.Script,
+		"SCRIPT_NAME":       r.Script,
+		"DOCUMENT_URI":      r.Script,
+		"REQUEST_URI":       uri,
+		"QUERY_STRING":      r.Query,
+	}
+
+	for _, h := range r.Headers {
+		name, value, ok := strings.Cut(h, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid header %q, want 'Name: value'", h)
+		}
+		params[HeaderParam(name)] = strings.TrimSpace(value)
+	}
+	for _, p := range r.Params {
+		k, v, ok := strings.Cut(p, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid param %q, want K=V", p)
+		}
+		params[k] = v
+	}
+	return params, nil
+}
+
+// HeaderParam returns the CGI param name for an HTTP header.
+func HeaderParam(name string) string {
+	key := strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+	if key == "CONTENT_TYPE" || key == "CONTENT_LENGTH" {
+		return key
+	}
+	return "HTTP_" + key
+}
+
+// SetDefault sets params[key] to value unless it is set already.
+func SetDefault(params map[string]string, key, value string) {
+	if _, ok := params[key]; !ok {
+		params[key] = value
+	}
+}
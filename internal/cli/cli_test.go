@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"testing"
+)
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct{ in, network, address string }{
+		{"unix:/run/php/fpm.sock", "unix", "/run/php/fpm.sock"},
+		{"/run/php/fpm.sock", "unix", "/run/php/fpm.sock"},
+		{"tcp:127.0.0.1:9000", "tcp", "127.0.0.1:9000"},
+		{"php-fpm:9000", "tcp", "php-fpm:9000"},
+	}
+	for _, tt := range tests {
+		if network, address := ParseAddress(tt.in); network != tt.network || address != tt.address {
+			t.Errorf("ParseAddress(%q) = %s, %s", tt.in, network, address)
+		}
+	}
+}
+
+func TestBuildParams(t *testing.T) {
+	r := Request{
+		Script:  "/var/www/index.php",
+		Query:   "a=1",
+		Data:    "x",
+		Headers: MultiFlag{"Content-Type: application/json", "X-Trace-Id: abc"},
+		Params:  MultiFlag{"SERVER_SOFTWARE=custom"},
+	}
+	params, err := r.BuildParams("fcgx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"REQUEST_METHOD":  "POST",
+		"REQUEST_URI":     "/var/www/index.php?a=1",
+		"QUERY_STRING":    "a=1",
+		"CONTENT_TYPE":    "application/json",
+		"HTTP_X_TRACE_ID": "abc",
+		"SERVER_SOFTWARE": "custom",
+	}
+	for k, v := range want {
+		if params[k] != v {
+			t.Errorf("Expected %s=%q, got %q", k, v, params[k])
+		}
+	}
+
+	if _, err := (&Request{Headers: MultiFlag{"no colon"}}).BuildParams("fcgx"); err == nil {
+		t.Error("Expected invalid header to be rejected")
+	}
+	if _, err := (&Request{Params: MultiFlag{"=v"}}).BuildParams("fcgx"); err == nil {
+		t.Error("Expected invalid param to be rejected")
+	}
+}
@@ -0,0 +1,207 @@
+// Package fcgitest provides an in-process FastCGI responder for the tests of
+// fcgx and the packages and commands built on it.
+package fcgitest
+
+import (
+	"io"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/gophpeek/fcgx/protocol"
+)
+
+// Request is a FastCGI request as decoded by Server.
+type Request struct {
+	ID     uint16
+	Flags  uint8
+	Params map[string]string
+	Stdin  []byte
+}
+
+// Handler writes the raw response records for a request to w, which is the
+// server side of the connection.
+type Handler func(w io.Writer, req *Request)
+
+// Server is a minimal FastCGI responder. It honours FCGI_KEEP_CONN the same
+// way PHP-FPM does and serves each connection on its own goroutine.
+type Server struct {
+	ln      net.Listener
+	handler Handler
+
+	mu       sync.Mutex
+	accepted int
+	requests []*Request
+}
+
+// NewServer starts a server on a TCP port of the loopback interface that
+// answers requests with handler. It is closed when the test ends.
+func NewServer(t testing.TB, handler Handler) *Server {
+	t.Helper()
+	return listen(t, "tcp", "127.0.0.1:0", handler)
+}
+
+// NewUnixServer is like NewServer but listens on a unix socket in a
+// temporary directory.
+func NewUnixServer(t testing.TB, handler Handler) *Server {
+	t.Helper()
+	return listen(t, "unix", filepath.Join(t.TempDir(), "fpm.sock"), handler)
+}
+
+func listen(t testing.TB, network, address string, handler Handler) *Server {
+	t.Helper()
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	return Serve(t, ln, handler)
+}
+
+// Serve starts a server accepting connections from ln, such as a TLS
+// listener. ln is closed when the test ends.
+func Serve(t testing.TB, ln net.Listener, handler Handler) *Server {
+	s := &Server{ln: ln, handler: handler}
+	go s.serve()
+	t.Cleanup(func() { ln.Close() })
+	return s
+}
+
+// DeadAddr returns a TCP address on the loopback interface that nothing is
+// listening on.
+func DeadAddr(t testing.TB) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Connections returns the number of connections accepted so far.
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accepted
+}
+
+// Request returns the i-th request received, counting from 0.
+func (s *Server) Request(i int) *Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[i]
+}
+
+// Requests returns the requests received so far.
+func (s *Server) Requests() []*Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Request(nil), s.requests...)
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.accepted++
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	serveConn(conn, s.handler, func(req *Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, req)
+		s.mu.Unlock()
+	})
+}
+
+// ServeConn answers the requests sent on conn with handler until the client
+// closes it or a request arrives without FCGI_KEEP_CONN. It is meant for
+// connections made without a listener, such as one end of net.Pipe.
+func ServeConn(conn net.Conn, handler Handler) {
+	serveConn(conn, handler, func(*Request) {})
+}
+
+func serveConn(conn net.Conn, handler Handler, record func(*Request)) {
+	defer conn.Close()
+	for {
+		req, err := ReadRequest(conn)
+		if err != nil {
+			return
+		}
+		record(req)
+		handler(conn, req)
+		if req.Flags&protocol.FlagKeepConn == 0 {
+			return
+		}
+	}
+}
+
+// ReadRequest reads the records of one request from r, up to and including
+// its closing empty FCGI_STDIN record.
+func ReadRequest(r io.Reader) (*Request, error) {
+	req := &Request{}
+	rr := protocol.NewRecordReader(r)
+	var params []byte
+	for {
+		rec, err := rr.ReadRecord()
+		if err != nil {
+			return nil, err
+		}
+
+		switch rec.Type {
+		case protocol.TypeBeginRequest:
+			var body protocol.BeginRequestBody
+			if err := body.UnmarshalBinary(rec.Content); err != nil {
+				return nil, err
+			}
+			req.ID = rec.RequestID
+			req.Flags = body.Flags
+		case protocol.TypeParams:
+			if len(rec.Content) == 0 {
+				if req.Params, err = protocol.DecodePairsMap(params); err != nil {
+					return nil, err
+				}
+			}
+			params = append(params, rec.Content...)
+		case protocol.TypeStdin:
+			if len(rec.Content) == 0 {
+				return req, nil
+			}
+			req.Stdin = append(req.Stdin, rec.Content...)
+		}
+	}
+}
+
+// WriteRecord writes a single record with padding to w.
+func WriteRecord(w io.Writer, recType protocol.RecordType, reqID uint16, content []byte) {
+	_ = protocol.NewRecordWriter(w).WriteRecord(recType, reqID, content)
+}
+
+// WriteEndRequest writes an FCGI_END_REQUEST record.
+func WriteEndRequest(w io.Writer, reqID uint16, appStatus uint32, protocolStatus protocol.ProtocolStatus) {
+	body := protocol.EndRequestBody{AppStatus: appStatus, ProtocolStatus: protocolStatus}
+	_ = protocol.NewRecordWriter(w).WriteEndRequest(reqID, body)
+}
+
+// RespondWith returns a handler that answers every request with body as
+// FCGI_STDOUT, followed by a successful FCGI_END_REQUEST.
+func RespondWith(body string) Handler {
+	return func(w io.Writer, req *Request) {
+		WriteRecord(w, protocol.TypeStdout, req.ID, []byte(body))
+		WriteRecord(w, protocol.TypeStdout, req.ID, nil)
+		WriteEndRequest(w, req.ID, 0, protocol.StatusRequestComplete)
+	}
+}
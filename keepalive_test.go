@@ -0,0 +1,180 @@
+package fcgx
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gophpeek/fcgx/internal/fcgitest"
+	"github.com/gophpeek/fcgx/protocol"
+)
+
+func testParams() map[string]string {
+	return map[string]string{
+		"SCRIPT_FILENAME": "/var/www/html/get.php",
+		"SCRIPT_NAME":     "/get.php",
+	}
+}
+
+func doGet(t *testing.T, client *Client) error {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	resp, err := client.Get(ctx, testParams())
+	if err != nil {
+		return err
+	}
+	body, err := ReadBody(resp)
+	if err != nil {
+		return err
+	}
+	if string(body) != "ok" {
+		t.Errorf("Expected body %q, got %q", "ok", body)
+	}
+	return nil
+}
+
+func TestKeepConn(t *testing.T) {
+	t.Run("WithoutKeepConnIsSingleUse", func(t *testing.T) {
+		srv := fcgitest.NewServer(t, fcgitest.RespondWith("Content-Type: text/plain\r\n\r\nok"))
+		client, err := Dial("tcp", srv.Addr())
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		defer client.Close()
+
+		if err := doGet(t, client); err != nil {
+			t.Fatalf("First request failed: %v", err)
+		}
+		if err := doGet(t, client); !errors.Is(err, ErrConnClosedByServer) {
+			t.Fatalf("Expected ErrConnClosedByServer, got %v", err)
+		}
+		if srv.Request(0).Flags&protocol.FlagKeepConn != 0 {
+			t.Error("Expected FCGI_KEEP_CONN to be unset")
+		}
+	})
+
+	t.Run("AutoRedial", func(t *testing.T) {
+		srv := fcgitest.NewServer(t, fcgitest.RespondWith("Content-Type: text/plain\r\n\r\nok"))
+		config := DefaultConfig()
+		config.AutoRedial = true
+		client, err := DialWithConfig("tcp", srv.Addr(), config)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		defer client.Close()
+
+		for i := 0; i < 3; i++ {
+			if err := doGet(t, client); err != nil {
+				t.Fatalf("Request %d failed: %v", i, err)
+			}
+		}
+		if n := srv.Connections(); n != 3 {
+			t.Errorf("Expected 3 connections, got %d", n)
+		}
+	})
+
+	t.Run("ReusesConnection", func(t *testing.T) {
+		srv := fcgitest.NewServer(t, fcgitest.RespondWith("Content-Type: text/plain\r\n\r\nok"))
+		config := DefaultConfig()
+		config.KeepConn = true
+		client, err := DialWithConfig("tcp", srv.Addr(), config)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		defer client.Close()
+
+		for i := 0; i < 3; i++ {
+			if err := doGet(t, client); err != nil {
+				t.Fatalf("Request %d failed: %v", i, err)
+			}
+		}
+		if n := srv.Connections(); n != 1 {
+			t.Errorf("Expected 1 connection, got %d", n)
+		}
+		if srv.Request(0).Flags&protocol.FlagKeepConn == 0 {
+			t.Error("Expected FCGI_KEEP_CONN to be set")
+		}
+	})
+
+	t.Run("DetectsServerClose", func(t *testing.T) {
+		closing := func(w io.Writer, req *fcgitest.Request) {
+			fcgitest.RespondWith("Content-Type: text/plain\r\n\r\nok")(w, req)
+			w.(net.Conn).Close()
+		}
+
+		for _, redial := range []bool{false, true} {
+			srv := fcgitest.NewServer(t, closing)
+			config := DefaultConfig()
+			config.KeepConn = true
+			config.AutoRedial = redial
+			client, err := DialWithConfig("tcp", srv.Addr(), config)
+			if err != nil {
+				t.Fatalf("Dial failed: %v", err)
+			}
+			defer client.Close()
+
+			if err := doGet(t, client); err != nil {
+				t.Fatalf("First request failed: %v", err)
+			}
+			time.Sleep(50 * time.Millisecond)
+
+			err = doGet(t, client)
+			if redial {
+				if err != nil {
+					t.Fatalf("Expected redial to succeed, got %v", err)
+				}
+				if n := srv.Connections(); n != 2 {
+					t.Errorf("Expected 2 connections, got %d", n)
+				}
+			} else if !errors.Is(err, ErrConnClosedByServer) {
+				t.Fatalf("Expected ErrConnClosedByServer, got %v", err)
+			}
+		}
+	})
+
+	t.Run("CancelledRequestNotReused", func(t *testing.T) {
+		srv := fcgitest.NewServer(t, func(w io.Writer, req *fcgitest.Request) {
+			fcgitest.RespondWith("Content-Type: text/plain\r\n\r\n"+req.Params["SCRIPT_NAME"])(w, req)
+		})
+		config := DefaultConfig()
+		config.KeepConn = true
+		config.AutoRedial = true
+		client, err := DialWithConfig("tcp", srv.Addr(), config)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		defer client.Close()
+
+		// Cancel once the request is fully sent; the server still answers it
+		ctx, cancel := context.WithCancel(context.Background())
+		ctx = WithTrace(ctx, &Trace{WroteStdin: func(int64) { cancel() }})
+		_, err = client.Get(ctx, map[string]string{"SCRIPT_NAME": "/first.php"})
+		if !errors.Is(err, ErrContextCancelled) {
+			t.Fatalf("Expected ErrContextCancelled, got %v", err)
+		}
+
+		ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		resp, err := client.Get(ctx, map[string]string{"SCRIPT_NAME": "/second.php"})
+		if err != nil {
+			t.Fatalf("Second request failed: %v", err)
+		}
+		body, err := ReadBody(resp)
+		if err != nil {
+			t.Fatalf("ReadBody failed: %v", err)
+		}
+		if string(body) != "/second.php" {
+			t.Errorf("Expected body %q, got %q", "/second.php", body)
+		}
+		if n := srv.Connections(); n != 2 {
+			t.Errorf("Expected 2 connections, got %d", n)
+		}
+		if id := srv.Request(1).ID; id == srv.Request(0).ID {
+			t.Errorf("Expected a new request ID, got %d twice", id)
+		}
+	})
+}
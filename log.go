@@ -0,0 +1,84 @@
+package fcgx
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gophpeek/fcgx/protocol"
+)
+
+// Log messages are prefixed like errors so they are easy to find in
+// application logs. Every call site checks Config.Logger first so requests
+// without a logger do not pay for building attributes.
+
+// scriptName returns the script a request runs, for logs and errors.
+func scriptName(params map[string]string) string {
+	if s := params["SCRIPT_NAME"]; s != "" {
+		return s
+	}
+	return params["SCRIPT_FILENAME"]
+}
+
+// logDial records the outcome of a connection attempt.
+func logDial(ctx context.Context, logger *slog.Logger, network, address string, elapsed time.Duration, err error) {
+	if err != nil {
+		logger.LogAttrs(ctx, slog.LevelWarn, "fcgx: dial failed",
+			slog.String("network", network),
+			slog.String("address", address),
+			slog.Duration("duration", elapsed),
+			slog.Any("error", err))
+		return
+	}
+	logger.LogAttrs(ctx, slog.LevelDebug, "fcgx: connected",
+		slog.String("network", network),
+		slog.String("address", address),
+		slog.Duration("duration", elapsed))
+}
+
+// logRequest records a finished request attempt, at warning level when it
+// took at least SlowRequestThreshold.
+func (c *Client) logRequest(ctx context.Context, params map[string]string, elapsed time.Duration, resp *http.Response, err error) {
+	attrs := []slog.Attr{
+		slog.Int("reqID", int(c.reqID)),
+		slog.String("address", c.address),
+		slog.String("script", scriptName(params)),
+		slog.Duration("duration", elapsed),
+	}
+	if resp != nil {
+		attrs = append(attrs, slog.Int("status", resp.StatusCode))
+	}
+	if err != nil {
+		attrs = append(attrs, slog.Any("error", err))
+	}
+
+	if threshold := c.config.SlowRequestThreshold; threshold > 0 && elapsed >= threshold {
+		attrs = append(attrs, slog.Duration("threshold", threshold))
+		c.config.Logger.LogAttrs(ctx, slog.LevelWarn, "fcgx: slow request", attrs...)
+		return
+	}
+	msg := "fcgx: request completed"
+	if err != nil {
+		msg = "fcgx: request failed"
+	}
+	c.config.Logger.LogAttrs(ctx, slog.LevelDebug, msg, attrs...)
+}
+
+// logSkippedRecord records a record ignored outside StrictProtocol.
+func (c *Client) logSkippedRecord(ctx context.Context, h protocol.Header, reason string) {
+	c.config.Logger.LogAttrs(ctx, slog.LevelWarn, "fcgx: skipping unexpected record",
+		slog.String("reason", reason),
+		slog.String("type", h.Type.String()),
+		slog.Int("reqID", int(h.RequestID)),
+		slog.String("address", c.address))
+}
+
+// logStderr records FCGI_STDERR output, which PHP uses for errors and notices.
+func (c *Client) logStderr(ctx context.Context, params map[string]string, p []byte) {
+	c.config.Logger.LogAttrs(ctx, slog.LevelWarn, "fcgx: stderr",
+		slog.Int("reqID", int(c.reqID)),
+		slog.String("address", c.address),
+		slog.String("script", scriptName(params)),
+		slog.String("output", string(p)))
+}
@@ -0,0 +1,99 @@
+package fcgx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gophpeek/fcgx/internal/fcgitest"
+	"github.com/gophpeek/fcgx/protocol"
+)
+
+// logRecorder collects log records as decoded JSON objects.
+type logRecorder struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (r *logRecorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.buf.Write(p)
+}
+
+func (r *logRecorder) records(t *testing.T) map[string]map[string]any {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	records := make(map[string]map[string]any)
+	dec := json.NewDecoder(&r.buf)
+	for dec.More() {
+		var rec map[string]any
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatalf("decoding log record: %v", err)
+		}
+		records[rec["msg"].(string)] = rec
+	}
+	return records
+}
+
+func TestLogging(t *testing.T) {
+	srv := fcgitest.NewServer(t, func(w io.Writer, req *fcgitest.Request) {
+		fcgitest.WriteRecord(w, protocol.TypeStdout, req.ID+1, []byte("stray"))
+		fcgitest.WriteRecord(w, protocol.TypeStderr, req.ID, []byte("PHP Warning: oops"))
+		time.Sleep(20 * time.Millisecond)
+		fcgitest.RespondWith("Content-Type: text/plain\r\n\r\nok")(w, req)
+	})
+
+	rec := &logRecorder{}
+	config := DefaultConfig()
+	config.Logger = slog.New(slog.NewJSONHandler(rec, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	config.SlowRequestThreshold = 10 * time.Millisecond
+	client, err := DialWithConfig("tcp", srv.Addr(), config)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	params := testParams()
+	params["SCRIPT_NAME"] = "/slow.php"
+	resp, err := client.Get(ctx, params)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	// FCGI_STDERR is logged but never mixed into the response
+	if body, _ := ReadBody(resp); string(body) != "ok" {
+		t.Errorf("Expected body %q, got %q", "ok", body)
+	}
+
+	records := rec.records(t)
+	want := map[string]map[string]any{
+		"fcgx: connected":                  {"level": "DEBUG", "address": srv.Addr()},
+		"fcgx: skipping unexpected record": {"level": "WARN", "type": "FCGI_STDOUT", "reqID": float64(2)},
+		"fcgx: stderr":                     {"level": "WARN", "output": "PHP Warning: oops", "script": "/slow.php"},
+		"fcgx: connection not reusable":    {"level": "DEBUG"},
+		"fcgx: slow request":               {"level": "WARN", "script": "/slow.php", "status": float64(200), "reqID": float64(1)},
+	}
+	for msg, attrs := range want {
+		got, ok := records[msg]
+		if !ok {
+			t.Errorf("Missing log record %q, got %v", msg, records)
+			continue
+		}
+		for k, v := range attrs {
+			if got[k] != v {
+				t.Errorf("%q: %s = %v, want %v", msg, k, got[k], v)
+			}
+		}
+	}
+	if _, ok := records["fcgx: slow request"]["duration"]; !ok {
+		t.Error("Expected slow request to log its duration")
+	}
+}
@@ -0,0 +1,5 @@
+//go:build !race
+
+package fcgx
+
+const raceEnabled = false
@@ -0,0 +1,134 @@
+package fcgx
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// ParamPolicy validates the params of every request before anything is
+// sent, protecting against request smuggling through user-influenced
+// values. A rejected request fails with ErrInvalidParams.
+//
+// It rejects names that are not HTTP tokens, which includes names with NUL
+// bytes, spaces or newlines, values containing NUL, CR or LF, and the
+// HTTP_PROXY param, which PHP code commonly mistakes for the proxy setting
+// of outgoing requests (httpoxy). With DocumentRoot set it also confines
+// SCRIPT_FILENAME.
+type ParamPolicy struct {
+	// DocumentRoot, if set, requires SCRIPT_FILENAME to be an absolute path
+	// without ".." elements that resolves, following symlinks, to a file
+	// under this directory. It is checked on the client's filesystem, so
+	// only set it when the client shares the server's view of the files.
+	// Default: "" (not checked)
+	DocumentRoot string
+
+	// Sanitize drops invalid params and HTTP_PROXY instead of rejecting the
+	// request. The caller's map is left alone; a copy without them is sent.
+	// SCRIPT_FILENAME outside DocumentRoot is always rejected.
+	// Default: false
+	Sanitize bool
+
+	// AllowHTTPProxy lets the HTTP_PROXY param through.
+	// Default: false
+	AllowHTTPProxy bool
+}
+
+// Validate checks params against the policy. It returns the params to send,
+// which are a sanitized copy when Sanitize dropped any, or an error wrapping
+// ErrInvalidParams.
+func (p *ParamPolicy) Validate(params map[string]string) (map[string]string, error) {
+	var drop []string
+	for name, value := range params {
+		if err := p.checkParam(name, value); err != nil {
+			if !p.Sanitize {
+				return nil, &Error{Op: "validating params", Kind: ErrInvalidParams, Err: err}
+			}
+			drop = append(drop, name)
+		}
+	}
+	if len(drop) > 0 {
+		clean := make(map[string]string, len(params)-len(drop))
+		for name, value := range params {
+			clean[name] = value
+		}
+		for _, name := range drop {
+			delete(clean, name)
+		}
+		params = clean
+	}
+
+	if p.DocumentRoot != "" {
+		if err := confineScript(p.DocumentRoot, params["SCRIPT_FILENAME"]); err != nil {
+			return nil, &Error{Op: "validating params", Kind: ErrInvalidParams, Err: err}
+		}
+	}
+	return params, nil
+}
+
+// checkParam reports why a single param is not allowed.
+func (p *ParamPolicy) checkParam(name, value string) error {
+	if name == "" {
+		return errors.New("empty param name")
+	}
+	for i := 0; i < len(name); i++ {
+		if !isTokenChar(name[i]) {
+			return fmt.Errorf("param name %q contains invalid character %q", name, name[i])
+		}
+	}
+	if i := strings.IndexAny(value, "\x00\r\n"); i >= 0 {
+		return fmt.Errorf("param %s contains invalid character %q", name, value[i])
+	}
+	if !p.AllowHTTPProxy && strings.EqualFold(name, "HTTP_PROXY") {
+		return errors.New("param HTTP_PROXY is not allowed (httpoxy)")
+	}
+	return nil
+}
+
+// isTokenChar reports whether c may appear in an HTTP token (RFC 9110).
+func isTokenChar(c byte) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
+
+// confineScript reports an error unless script resolves to a path under
+// root. A script that does not exist yet is resolved through its directory.
+func confineScript(root, script string) error {
+	if script == "" {
+		return errors.New("SCRIPT_FILENAME is required with a document root")
+	}
+	if !filepath.IsAbs(script) {
+		return fmt.Errorf("SCRIPT_FILENAME %q is not absolute", script)
+	}
+	for _, elem := range strings.Split(filepath.ToSlash(script), "/") {
+		if elem == ".." {
+			return fmt.Errorf("SCRIPT_FILENAME %q contains ..", script)
+		}
+	}
+
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return fmt.Errorf("resolving document root: %w", err)
+	}
+	realScript, err := filepath.EvalSymlinks(script)
+	if errors.Is(err, fs.ErrNotExist) {
+		var dir string
+		if dir, err = filepath.EvalSymlinks(filepath.Dir(script)); err == nil {
+			realScript = filepath.Join(dir, filepath.Base(script))
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("resolving SCRIPT_FILENAME: %w", err)
+	}
+
+	rel, err := filepath.Rel(realRoot, realScript)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("SCRIPT_FILENAME %q resolves outside the document root", script)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package fcgx
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gophpeek/fcgx/internal/fcgitest"
+)
+
+func TestParamPolicy(t *testing.T) {
+	p := &ParamPolicy{}
+	tests := []struct {
+		name   string
+		params map[string]string
+		want   string // Substring of the error, empty for valid params
+	}{
+		{"valid", map[string]string{"SCRIPT_FILENAME": "/app/index.php", "HTTP_X_FORWARDED_FOR": "1.2.3.4"}, ""},
+		{"empty name", map[string]string{"": "x"}, "empty param name"},
+		{"space in name", map[string]string{"HTTP_X FOO": "x"}, `invalid character ' '`},
+		{"NUL in name", map[string]string{"HTTP_X\x00": "x"}, `invalid character '\x00'`},
+		{"newline in name", map[string]string{"HTTP_X\nHOST": "x"}, `invalid character '\n'`},
+		{"newline in value", map[string]string{"HTTP_X_FOO": "a\r\nStatus: 302"}, `HTTP_X_FOO contains invalid character '\r'`},
+		{"NUL in value", map[string]string{"SCRIPT_FILENAME": "/app/x.php\x00.jpg"}, `invalid character '\x00'`},
+		{"httpoxy", map[string]string{"HTTP_PROXY": "http://evil:8080"}, "httpoxy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := p.Validate(tt.params)
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("Expected valid params, got %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidParams) || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected ErrInvalidParams containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+
+	allow := &ParamPolicy{AllowHTTPProxy: true}
+	if _, err := allow.Validate(map[string]string{"HTTP_PROXY": "x"}); err != nil {
+		t.Errorf("Expected HTTP_PROXY to be allowed, got %v", err)
+	}
+}
+
+func TestParamPolicySanitize(t *testing.T) {
+	p := &ParamPolicy{Sanitize: true}
+	params := map[string]string{"SCRIPT_FILENAME": "/app/index.php", "HTTP_PROXY": "x", "HTTP_X_BAD": "a\nb"}
+	got, err := p.Validate(params)
+	if err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if len(got) != 1 || got["SCRIPT_FILENAME"] != "/app/index.php" {
+		t.Errorf("Expected invalid params to be dropped, got %v", got)
+	}
+	if len(params) != 3 {
+		t.Errorf("Expected the caller's params to be left alone, got %v", params)
+	}
+}
+
+func TestParamPolicyDocumentRoot(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "public")
+	for _, dir := range []string{root, filepath.Join(base, "secret")} {
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []string{filepath.Join(root, "index.php"), filepath.Join(base, "secret", "keys.php")} {
+		if err := os.WriteFile(f, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(base, "secret", "keys.php"), filepath.Join(root, "link.php")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "index.php"), filepath.Join(root, "alias.php")); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &ParamPolicy{DocumentRoot: root}
+	tests := []struct {
+		script string
+		want   string
+	}{
+		{filepath.Join(root, "index.php"), ""},
+		{filepath.Join(root, "alias.php"), ""},   // Symlink within the root
+		{filepath.Join(root, "missing.php"), ""}, // Left to the server to report
+		{root + "/../secret/keys.php", "contains .."},
+		{filepath.Join(root, "link.php"), "outside the document root"},
+		{filepath.Join(base, "secret", "keys.php"), "outside the document root"},
+		{root, "outside the document root"},
+		{"index.php", "not absolute"},
+		{"", "required"},
+	}
+	for _, tt := range tests {
+		_, err := p.Validate(map[string]string{"SCRIPT_FILENAME": tt.script})
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("Expected %q to be allowed, got %v", tt.script, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrInvalidParams) || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Expected %q to be rejected with %q, got %v", tt.script, tt.want, err)
+		}
+	}
+}
+
+func TestClientParamPolicy(t *testing.T) {
+	s := fcgitest.NewServer(t, fcgitest.RespondWith("Content-Type: text/plain\r\n\r\nok"))
+	config := DefaultConfig()
+	config.ParamPolicy = &ParamPolicy{}
+	client, err := DialWithConfig("tcp", s.Addr(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	_, err = client.Get(context.Background(), map[string]string{"SCRIPT_NAME": "/index.php", "HTTP_PROXY": "x"})
+	var fe *Error
+	if !errors.As(err, &fe) || fe.Kind != ErrInvalidParams || fe.Script != "/index.php" || fe.Addr != s.Addr() {
+		t.Fatalf("Expected ErrInvalidParams with request context, got %v", err)
+	}
+	if n := s.Connections(); n != 1 {
+		t.Errorf("Expected only the dial, got %d connections", n)
+	}
+
+	resp, err := client.Get(context.Background(), map[string]string{"SCRIPT_NAME": "/index.php"})
+	if err != nil {
+		t.Fatalf("Expected valid request to succeed, got %v", err)
+	}
+	resp.Body.Close()
+	if n := len(s.Requests()); n != 1 {
+		t.Errorf("Expected only the valid request to reach the server, got %d", n)
+	}
+}
@@ -0,0 +1,115 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// Pair is a single FastCGI name-value pair.
+type Pair struct {
+	Name  string
+	Value string
+}
+
+// appendLength appends a pair length: one byte below 128, otherwise four
+// bytes with the high bit set.
+func appendLength(dst []byte, n int) []byte {
+	if n < 128 {
+		return append(dst, byte(n))
+	}
+	return binary.BigEndian.AppendUint32(dst, uint32(n)|1<<31)
+}
+
+// AppendPair appends the encoded name-value pair to dst and returns the
+// extended slice.
+func AppendPair(dst []byte, name, value string) []byte {
+	dst = appendLength(dst, len(name))
+	dst = appendLength(dst, len(value))
+	dst = append(dst, name...)
+	return append(dst, value...)
+}
+
+// AppendPairs appends all pairs in m to dst, sorted by name so the encoding
+// is deterministic.
+func AppendPairs(dst []byte, m map[string]string) []byte {
+	dst, _ = appendPairsSorted(dst, nil, m)
+	return dst
+}
+
+// appendPairsSorted is AppendPairs with a caller-owned scratch slice for the
+// sorted names, so repeated calls do not allocate.
+func appendPairsSorted(dst []byte, names []string, m map[string]string) ([]byte, []string) {
+	names = names[:0]
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		dst = AppendPair(dst, name, m[name])
+	}
+	return dst, names
+}
+
+// readLength decodes a pair length from the start of b.
+func readLength(b []byte) (int, []byte, error) {
+	if len(b) == 0 {
+		return 0, nil, ErrMalformedPair
+	}
+	if b[0]>>7 == 0 {
+		return int(b[0]), b[1:], nil
+	}
+	if len(b) < 4 {
+		return 0, nil, ErrMalformedPair
+	}
+	return int(binary.BigEndian.Uint32(b) &^ (1 << 31)), b[4:], nil
+}
+
+// NextPair decodes the first name-value pair in b and returns it together
+// with the remaining bytes.
+func NextPair(b []byte) (Pair, []byte, error) {
+	nameLen, b, err := readLength(b)
+	if err != nil {
+		return Pair{}, nil, err
+	}
+	valueLen, b, err := readLength(b)
+	if err != nil {
+		return Pair{}, nil, err
+	}
+	if nameLen > len(b) || valueLen > len(b)-nameLen {
+		return Pair{}, nil, ErrMalformedPair
+	}
+	p := Pair{
+		Name:  string(b[:nameLen]),
+		Value: string(b[nameLen : nameLen+valueLen]),
+	}
+	return p, b[nameLen+valueLen:], nil
+}
+
+// DecodePairs decodes a complete PARAMS or GET_VALUES stream in wire order.
+func DecodePairs(b []byte) ([]Pair, error) {
+	var pairs []Pair
+	for len(b) > 0 {
+		p, rest, err := NextPair(b)
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, p)
+		b = rest
+	}
+	return pairs, nil
+}
+
+// DecodePairsMap decodes a complete PARAMS or GET_VALUES stream into a map.
+// Later duplicates overwrite earlier ones.
+func DecodePairsMap(b []byte) (map[string]string, error) {
+	m := make(map[string]string)
+	for len(b) > 0 {
+		p, rest, err := NextPair(b)
+		if err != nil {
+			return nil, err
+		}
+		m[p.Name] = p.Value
+		b = rest
+	}
+	return m, nil
+}
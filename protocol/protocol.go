@@ -0,0 +1,267 @@
+// Package protocol implements the FastCGI record layer: record headers,
+// BEGIN_REQUEST/END_REQUEST bodies, name-value pairs and a record reader and
+// writer.
+//
+// It is the codec used by the fcgx client and can be used on its own to build
+// FastCGI servers, proxies or traffic inspectors.
+//
+// Example usage:
+//
+//	w := protocol.NewRecordWriter(conn)
+//	_ = w.WriteBeginRequest(1, protocol.BeginRequestBody{Role: protocol.RoleResponder})
+//	_ = w.WritePairs(protocol.TypeParams, 1, params)
+//	_ = w.WriteRecord(protocol.TypeParams, 1, nil)
+//	_ = w.WriteRecord(protocol.TypeStdin, 1, nil)
+//
+//	r := protocol.NewRecordReader(conn)
+//	for {
+//		rec, err := r.ReadRecord()
+//		if err != nil {
+//			return err
+//		}
+//		if rec.Type == protocol.TypeEndRequest {
+//			break
+//		}
+//	}
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrContentTooLarge = errors.New("fcgx/protocol: record content exceeds 65535 bytes")
+	ErrShortBody       = errors.New("fcgx/protocol: record body too short")
+	ErrShortHeader     = errors.New("fcgx/protocol: record header too short")
+	ErrMalformedPair   = errors.New("fcgx/protocol: malformed name-value pair")
+)
+
+const (
+	Version1      = 1     // FastCGI protocol version
+	HeaderLen     = 8     // Record header length in bytes
+	MaxContentLen = 65535 // Maximum content length of a single record
+	MaxPaddingLen = 255   // Maximum padding length of a single record
+
+	// NullRequestID is the request ID used by management records.
+	NullRequestID = 0
+)
+
+// RecordType identifies the function of a FastCGI record.
+type RecordType uint8
+
+const (
+	TypeBeginRequest    RecordType = 1  // Starts a request
+	TypeAbortRequest    RecordType = 2  // Aborts a request
+	TypeEndRequest      RecordType = 3  // Ends a request
+	TypeParams          RecordType = 4  // Name-value pairs from client to application
+	TypeStdin           RecordType = 5  // STDIN data from client to application
+	TypeStdout          RecordType = 6  // STDOUT data from application to client
+	TypeStderr          RecordType = 7  // STDERR data from application to client
+	TypeData            RecordType = 8  // Additional data stream for the Filter role
+	TypeGetValues       RecordType = 9  // Management query for server variables
+	TypeGetValuesResult RecordType = 10 // Management response to TypeGetValues
+	TypeUnknownType     RecordType = 11 // Management response to an unrecognized record type
+)
+
+var typeNames = [...]string{
+	TypeBeginRequest:    "BEGIN_REQUEST",
+	TypeAbortRequest:    "ABORT_REQUEST",
+	TypeEndRequest:      "END_REQUEST",
+	TypeParams:          "PARAMS",
+	TypeStdin:           "STDIN",
+	TypeStdout:          "STDOUT",
+	TypeStderr:          "STDERR",
+	TypeData:            "DATA",
+	TypeGetValues:       "GET_VALUES",
+	TypeGetValuesResult: "GET_VALUES_RESULT",
+	TypeUnknownType:     "UNKNOWN_TYPE",
+}
+
+func (t RecordType) String() string {
+	if int(t) < len(typeNames) && typeNames[t] != "" {
+		return "FCGI_" + typeNames[t]
+	}
+	return fmt.Sprintf("FCGI_TYPE(%d)", uint8(t))
+}
+
+// IsManagement reports whether t is a management record type, which is
+// always sent with NullRequestID.
+func (t RecordType) IsManagement() bool {
+	return t == TypeGetValues || t == TypeGetValuesResult || t == TypeUnknownType
+}
+
+// Role is the role requested in a BEGIN_REQUEST record.
+type Role uint16
+
+const (
+	RoleResponder  Role = 1 // Receives request data and generates a response
+	RoleAuthorizer Role = 2 // Generates an authorized/unauthorized decision
+	RoleFilter     Role = 3 // Filters an additional data stream
+)
+
+// FlagKeepConn asks the application to keep the connection open after the
+// request completes.
+const FlagKeepConn uint8 = 1
+
+// ProtocolStatus is the protocol-level outcome reported in END_REQUEST.
+type ProtocolStatus uint8
+
+const (
+	StatusRequestComplete ProtocolStatus = 0 // Normal end of request
+	StatusCantMultiplex   ProtocolStatus = 1 // Rejected: connection already carries a request
+	StatusOverloaded      ProtocolStatus = 2 // Rejected: application out of resources
+	StatusUnknownRole     ProtocolStatus = 3 // Rejected: role not supported
+)
+
+func (s ProtocolStatus) String() string {
+	switch s {
+	case StatusRequestComplete:
+		return "FCGI_REQUEST_COMPLETE"
+	case StatusCantMultiplex:
+		return "FCGI_CANT_MPX_CONN"
+	case StatusOverloaded:
+		return "FCGI_OVERLOADED"
+	case StatusUnknownRole:
+		return "FCGI_UNKNOWN_ROLE"
+	}
+	return fmt.Sprintf("FCGI_STATUS(%d)", uint8(s))
+}
+
+// Header is a FastCGI record header as defined in the FastCGI specification.
+type Header struct {
+	Version       uint8      // Protocol version (always 1)
+	Type          RecordType // Record type
+	RequestID     uint16     // Request the record belongs to
+	ContentLength uint16     // Length of the content that follows the header
+	PaddingLength uint8      // Number of padding bytes that follow the content
+	Reserved      uint8      // Reserved for future use (always 0)
+}
+
+// NewHeader returns a version 1 header for content of the given length,
+// padded to a multiple of 8 bytes as recommended by the specification.
+func NewHeader(t RecordType, reqID uint16, contentLen int) Header {
+	return Header{
+		Version:       Version1,
+		Type:          t,
+		RequestID:     reqID,
+		ContentLength: uint16(contentLen),
+		PaddingLength: uint8(-contentLen & 7),
+	}
+}
+
+// Encode writes the wire form of h into b, which must be at least HeaderLen bytes.
+func (h Header) Encode(b []byte) {
+	_ = b[HeaderLen-1]
+	b[0] = h.Version
+	b[1] = byte(h.Type)
+	binary.BigEndian.PutUint16(b[2:4], h.RequestID)
+	binary.BigEndian.PutUint16(b[4:6], h.ContentLength)
+	b[6] = h.PaddingLength
+	b[7] = h.Reserved
+}
+
+// DecodeHeader parses a record header from the first HeaderLen bytes of b.
+func DecodeHeader(b []byte) (Header, error) {
+	if len(b) < HeaderLen {
+		return Header{}, ErrShortHeader
+	}
+	return Header{
+		Version:       b[0],
+		Type:          RecordType(b[1]),
+		RequestID:     binary.BigEndian.Uint16(b[2:4]),
+		ContentLength: binary.BigEndian.Uint16(b[4:6]),
+		PaddingLength: b[6],
+		Reserved:      b[7],
+	}, nil
+}
+
+// Record is a decoded FastCGI record without its padding.
+type Record struct {
+	Header
+	Content []byte
+}
+
+// BeginRequestBody is the body of a BEGIN_REQUEST record.
+type BeginRequestBody struct {
+	Role  Role
+	Flags uint8
+}
+
+// AppendBinary appends the 8-byte wire form of b to dst.
+func (b BeginRequestBody) AppendBinary(dst []byte) ([]byte, error) {
+	dst = binary.BigEndian.AppendUint16(dst, uint16(b.Role))
+	return append(dst, b.Flags, 0, 0, 0, 0, 0), nil
+}
+
+// MarshalBinary encodes the 8-byte wire form of b.
+func (b BeginRequestBody) MarshalBinary() ([]byte, error) {
+	return b.AppendBinary(make([]byte, 0, 8))
+}
+
+// UnmarshalBinary decodes a BEGIN_REQUEST body.
+func (b *BeginRequestBody) UnmarshalBinary(data []byte) error {
+	if len(data) < 8 {
+		return ErrShortBody
+	}
+	b.Role = Role(binary.BigEndian.Uint16(data[0:2]))
+	b.Flags = data[2]
+	return nil
+}
+
+// KeepConn reports whether FlagKeepConn is set.
+func (b BeginRequestBody) KeepConn() bool {
+	return b.Flags&FlagKeepConn != 0
+}
+
+// EndRequestBody is the body of an END_REQUEST record.
+type EndRequestBody struct {
+	AppStatus      uint32 // Application exit status
+	ProtocolStatus ProtocolStatus
+}
+
+// AppendBinary appends the 8-byte wire form of b to dst.
+func (b EndRequestBody) AppendBinary(dst []byte) ([]byte, error) {
+	dst = binary.BigEndian.AppendUint32(dst, b.AppStatus)
+	return append(dst, byte(b.ProtocolStatus), 0, 0, 0), nil
+}
+
+// MarshalBinary encodes the 8-byte wire form of b.
+func (b EndRequestBody) MarshalBinary() ([]byte, error) {
+	return b.AppendBinary(make([]byte, 0, 8))
+}
+
+// UnmarshalBinary decodes an END_REQUEST body.
+func (b *EndRequestBody) UnmarshalBinary(data []byte) error {
+	if len(data) < 8 {
+		return ErrShortBody
+	}
+	b.AppStatus = binary.BigEndian.Uint32(data[0:4])
+	b.ProtocolStatus = ProtocolStatus(data[4])
+	return nil
+}
+
+// UnknownTypeBody is the body of an UNKNOWN_TYPE management record.
+type UnknownTypeBody struct {
+	Type RecordType // The record type the peer did not recognize
+}
+
+// AppendBinary appends the 8-byte wire form of b to dst.
+func (b UnknownTypeBody) AppendBinary(dst []byte) ([]byte, error) {
+	return append(dst, byte(b.Type), 0, 0, 0, 0, 0, 0, 0), nil
+}
+
+// MarshalBinary encodes the 8-byte wire form of b.
+func (b UnknownTypeBody) MarshalBinary() ([]byte, error) {
+	return b.AppendBinary(make([]byte, 0, 8))
+}
+
+// UnmarshalBinary decodes an UNKNOWN_TYPE body.
+func (b *UnknownTypeBody) UnmarshalBinary(data []byte) error {
+	if len(data) < 1 {
+		return ErrShortBody
+	}
+	b.Type = RecordType(data[0])
+	return nil
+}
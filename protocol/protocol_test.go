@@ -0,0 +1,187 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestHeaderRoundTrip(t *testing.T) {
+	h := NewHeader(TypeStdout, 513, 13)
+	if h.PaddingLength != 3 {
+		t.Errorf("Expected padding 3, got %d", h.PaddingLength)
+	}
+
+	var b [HeaderLen]byte
+	h.Encode(b[:])
+	want := []byte{1, 6, 2, 1, 0, 13, 3, 0}
+	if !bytes.Equal(b[:], want) {
+		t.Errorf("Expected %v, got %v", want, b)
+	}
+
+	got, err := DecodeHeader(b[:])
+	if err != nil {
+		t.Fatalf("DecodeHeader failed: %v", err)
+	}
+	if got != h {
+		t.Errorf("Expected %+v, got %+v", h, got)
+	}
+
+	if _, err := DecodeHeader(b[:7]); !errors.Is(err, ErrShortHeader) {
+		t.Errorf("Expected ErrShortHeader, got %v", err)
+	}
+}
+
+func TestBodies(t *testing.T) {
+	begin := BeginRequestBody{Role: RoleFilter, Flags: FlagKeepConn}
+	b, _ := begin.MarshalBinary()
+	var gotBegin BeginRequestBody
+	if err := gotBegin.UnmarshalBinary(b); err != nil || gotBegin != begin {
+		t.Errorf("BeginRequestBody round trip: got %+v, %v", gotBegin, err)
+	}
+	if !gotBegin.KeepConn() {
+		t.Error("Expected KeepConn to be set")
+	}
+
+	end := EndRequestBody{AppStatus: 255, ProtocolStatus: StatusOverloaded}
+	b, _ = end.MarshalBinary()
+	var gotEnd EndRequestBody
+	if err := gotEnd.UnmarshalBinary(b); err != nil || gotEnd != end {
+		t.Errorf("EndRequestBody round trip: got %+v, %v", gotEnd, err)
+	}
+	if err := gotEnd.UnmarshalBinary(b[:4]); !errors.Is(err, ErrShortBody) {
+		t.Errorf("Expected ErrShortBody, got %v", err)
+	}
+
+	if s := StatusOverloaded.String(); s != "FCGI_OVERLOADED" {
+		t.Errorf("Unexpected status string %q", s)
+	}
+	if s := TypeUnknownType.String(); s != "FCGI_UNKNOWN_TYPE" {
+		t.Errorf("Unexpected type string %q", s)
+	}
+	if s := RecordType(42).String(); s != "FCGI_TYPE(42)" {
+		t.Errorf("Unexpected type string %q", s)
+	}
+}
+
+func TestPairs(t *testing.T) {
+	long := strings.Repeat("x", 300)
+	params := map[string]string{
+		"SCRIPT_FILENAME": "/var/www/html/index.php",
+		"EMPTY":           "",
+		long:              long,
+	}
+
+	b := AppendPairs(nil, params)
+	got, err := DecodePairsMap(b)
+	if err != nil {
+		t.Fatalf("DecodePairsMap failed: %v", err)
+	}
+	if len(got) != len(params) {
+		t.Fatalf("Expected %d pairs, got %d", len(params), len(got))
+	}
+	for k, v := range params {
+		if got[k] != v {
+			t.Errorf("Pair %q: expected %q, got %q", k[:5], v, got[k])
+		}
+	}
+
+	pairs, err := DecodePairs(b)
+	if err != nil {
+		t.Fatalf("DecodePairs failed: %v", err)
+	}
+	if pairs[0].Name != "EMPTY" || pairs[1].Name != "SCRIPT_FILENAME" {
+		t.Errorf("Expected pairs sorted by name, got %q, %q", pairs[0].Name, pairs[1].Name)
+	}
+
+	// Long lengths use four bytes with the high bit set
+	enc := AppendPair(nil, "K", long)
+	if !bytes.Equal(enc[:5], []byte{1, 0x80, 0, 1, 44}) {
+		t.Errorf("Unexpected long length encoding %v", enc[:5])
+	}
+
+	for _, bad := range [][]byte{{5, 0, 'a'}, {0x80, 0}, {1}} {
+		if _, err := DecodePairs(bad); !errors.Is(err, ErrMalformedPair) {
+			t.Errorf("Expected ErrMalformedPair for %v, got %v", bad, err)
+		}
+	}
+}
+
+func TestRecordReaderWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewRecordWriter(&buf)
+
+	if err := w.WriteBeginRequest(1, BeginRequestBody{Role: RoleResponder, Flags: FlagKeepConn}); err != nil {
+		t.Fatalf("WriteBeginRequest failed: %v", err)
+	}
+	if err := w.WritePairs(TypeParams, 1, map[string]string{"A": strings.Repeat("v", 70000)}); err != nil {
+		t.Fatalf("WritePairs failed: %v", err)
+	}
+	if err := w.WriteRecord(TypeParams, 1, nil); err != nil {
+		t.Fatalf("WriteRecord failed: %v", err)
+	}
+	if err := w.WriteStream(TypeStdin, 1, []byte("hello world"), 4); err != nil {
+		t.Fatalf("WriteStream failed: %v", err)
+	}
+	if err := w.WriteEndRequest(1, EndRequestBody{AppStatus: 3}); err != nil {
+		t.Fatalf("WriteEndRequest failed: %v", err)
+	}
+	if err := w.WriteRecord(TypeStdout, 1, make([]byte, MaxContentLen+1)); !errors.Is(err, ErrContentTooLarge) {
+		t.Errorf("Expected ErrContentTooLarge, got %v", err)
+	}
+	if buf.Len()%8 != 0 {
+		t.Errorf("Expected records padded to 8 bytes, got %d bytes", buf.Len())
+	}
+
+	r := NewRecordReader(&buf)
+	var types []RecordType
+	var params, stdin []byte
+	for {
+		rec, err := r.ReadRecord()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("ReadRecord failed: %v", err)
+		}
+		types = append(types, rec.Type)
+		switch rec.Type {
+		case TypeParams:
+			params = append(params, rec.Content...)
+		case TypeStdin:
+			stdin = append(stdin, rec.Content...)
+		case TypeEndRequest:
+			var body EndRequestBody
+			if err := body.UnmarshalBinary(rec.Content); err != nil || body.AppStatus != 3 {
+				t.Errorf("Unexpected END_REQUEST body %+v, %v", body, err)
+			}
+		}
+	}
+
+	want := []RecordType{TypeBeginRequest, TypeParams, TypeParams, TypeParams, TypeStdin, TypeStdin, TypeStdin, TypeEndRequest}
+	if len(types) != len(want) {
+		t.Fatalf("Expected record types %v, got %v", want, types)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Errorf("Record %d: expected %s, got %s", i, want[i], types[i])
+		}
+	}
+	if got, err := DecodePairsMap(params); err != nil || len(got["A"]) != 70000 {
+		t.Errorf("Unexpected params after split: %d bytes, %v", len(got["A"]), err)
+	}
+	if string(stdin) != "hello world" {
+		t.Errorf("Expected stdin %q, got %q", "hello world", stdin)
+	}
+}
+
+func TestRecordReaderTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	_ = NewRecordWriter(&buf).WriteRecord(TypeStdout, 1, []byte("truncated"))
+	r := NewRecordReader(bytes.NewReader(buf.Bytes()[:HeaderLen+4]))
+	if _, err := r.ReadRecord(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+package protocol
+
+import (
+	"io"
+	"net"
+)
+
+// zeroPadding is the source of padding bytes for every record.
+var zeroPadding [MaxPaddingLen]byte
+
+// RecordWriter writes FastCGI records to an underlying writer.
+// It is not safe for concurrent use.
+//
+// Headers are encoded into a fixed array and, on TCP and Unix connections,
+// header, content and padding are sent with a single vectored write (writev)
+// without copying the content. Other writers receive one Write call per
+// record from an internal buffer that is reused across records.
+type RecordWriter struct {
+	w        io.Writer
+	vectored bool
+
+	hdr   [HeaderLen]byte
+	body  [8]byte
+	iov   [3][]byte
+	vec   net.Buffers
+	buf   []byte
+	pairs []byte
+	names []string
+}
+
+// NewRecordWriter returns a RecordWriter that writes records to w.
+func NewRecordWriter(w io.Writer) *RecordWriter {
+	rw := &RecordWriter{w: w}
+	switch w.(type) {
+	case *net.TCPConn, *net.UnixConn:
+		rw.vectored = true
+	}
+	return rw
+}
+
+// WriteRecord writes a single record, padding its content to a multiple of
+// 8 bytes. The record is sent in one write call and content is not retained.
+func (rw *RecordWriter) WriteRecord(t RecordType, reqID uint16, content []byte) error {
+	if len(content) > MaxContentLen {
+		return ErrContentTooLarge
+	}
+	h := NewHeader(t, reqID, len(content))
+	padding := zeroPadding[:h.PaddingLength]
+
+	if rw.vectored {
+		h.Encode(rw.hdr[:])
+		rw.iov = [3][]byte{rw.hdr[:], content, padding}
+		rw.vec = rw.iov[:]
+		_, err := rw.vec.WriteTo(rw.w)
+		rw.iov = [3][]byte{}
+		return err
+	}
+
+	size := HeaderLen + len(content) + len(padding)
+	if cap(rw.buf) < size {
+		rw.buf = make([]byte, size)
+	}
+	buf := rw.buf[:size]
+	h.Encode(buf)
+	n := copy(buf[HeaderLen:], content)
+	copy(buf[HeaderLen+n:], padding)
+
+	_, err := rw.w.Write(buf)
+	return err
+}
+
+// WriteStream writes data as a sequence of records of type t, each carrying
+// at most maxChunk bytes. It does not write the terminating empty record.
+// A maxChunk outside (0, MaxContentLen] is treated as MaxContentLen.
+func (rw *RecordWriter) WriteStream(t RecordType, reqID uint16, data []byte, maxChunk int) error {
+	if maxChunk <= 0 || maxChunk > MaxContentLen {
+		maxChunk = MaxContentLen
+	}
+	for len(data) > 0 {
+		chunk := data
+		if len(chunk) > maxChunk {
+			chunk = chunk[:maxChunk]
+		}
+		if err := rw.WriteRecord(t, reqID, chunk); err != nil {
+			return err
+		}
+		data = data[len(chunk):]
+	}
+	return nil
+}
+
+// WritePairs encodes pairs and writes them as records of type t, splitting
+// across records when the encoding exceeds MaxContentLen. It does not write
+// the terminating empty record.
+func (rw *RecordWriter) WritePairs(t RecordType, reqID uint16, pairs map[string]string) error {
+	rw.pairs, rw.names = appendPairsSorted(rw.pairs[:0], rw.names, pairs)
+	clear(rw.names)
+	return rw.WriteStream(t, reqID, rw.pairs, MaxContentLen)
+}
+
+// WriteBeginRequest writes a BEGIN_REQUEST record.
+func (rw *RecordWriter) WriteBeginRequest(reqID uint16, body BeginRequestBody) error {
+	b, _ := body.AppendBinary(rw.body[:0])
+	return rw.WriteRecord(TypeBeginRequest, reqID, b)
+}
+
+// WriteEndRequest writes an END_REQUEST record.
+func (rw *RecordWriter) WriteEndRequest(reqID uint16, body EndRequestBody) error {
+	b, _ := body.AppendBinary(rw.body[:0])
+	return rw.WriteRecord(TypeEndRequest, reqID, b)
+}
+
+// RecordReader reads FastCGI records from an underlying reader.
+// It is not safe for concurrent use.
+//
+// Content is read into a buffer owned by the reader and reused for every
+// record, so steady-state reads do not allocate. Callers that keep content
+// beyond the next read must copy it.
+type RecordReader struct {
+	r   io.Reader
+	hdr [HeaderLen]byte
+	buf []byte
+}
+
+// NewRecordReader returns a RecordReader that reads records from r.
+func NewRecordReader(r io.Reader) *RecordReader {
+	return &RecordReader{r: r}
+}
+
+// ReadHeader reads the next record header. The caller must consume the
+// record's content and padding, for example with ReadContent, before reading
+// the next header.
+func (rr *RecordReader) ReadHeader() (Header, error) {
+	if _, err := io.ReadFull(rr.r, rr.hdr[:]); err != nil {
+		return Header{}, err
+	}
+	return DecodeHeader(rr.hdr[:])
+}
+
+// ReadContent reads the content and padding that follow h and returns the
+// content. The returned slice is only valid until the next call on rr.
+// A clean EOF inside the record is reported as io.ErrUnexpectedEOF.
+func (rr *RecordReader) ReadContent(h Header) ([]byte, error) {
+	size := int(h.ContentLength) + int(h.PaddingLength)
+	if cap(rr.buf) < size {
+		rr.buf = make([]byte, size)
+	}
+	buf := rr.buf[:size]
+	if _, err := io.ReadFull(rr.r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf[:h.ContentLength], nil
+}
+
+// ReadRecord reads the next complete record. Padding is consumed and dropped.
+// The record content is only valid until the next call on rr.
+func (rr *RecordReader) ReadRecord() (Record, error) {
+	h, err := rr.ReadHeader()
+	if err != nil {
+		return Record{}, err
+	}
+	content, err := rr.ReadContent(h)
+	if err != nil {
+		return Record{}, err
+	}
+	return Record{Header: h, Content: content}, nil
+}
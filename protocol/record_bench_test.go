@@ -0,0 +1,113 @@
+package protocol
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+var benchParams = map[string]string{
+	"SCRIPT_FILENAME": "/status",
+	"SCRIPT_NAME":     "/status",
+	"QUERY_STRING":    "json&full",
+	"REQUEST_METHOD":  "GET",
+	"SERVER_PROTOCOL": "HTTP/1.1",
+	"REMOTE_ADDR":     "127.0.0.1",
+}
+
+func TestCodecAllocs(t *testing.T) {
+	w := NewRecordWriter(io.Discard)
+	content := make([]byte, 1000)
+
+	var encoded bytes.Buffer
+	_ = NewRecordWriter(&encoded).WriteRecord(TypeStdout, 1, content)
+	src := bytes.NewReader(encoded.Bytes())
+	r := NewRecordReader(src)
+
+	tests := []struct {
+		name string
+		fn   func()
+	}{
+		{"WriteRecord", func() { _ = w.WriteRecord(TypeStdin, 1, content) }},
+		{"WritePairs", func() { _ = w.WritePairs(TypeParams, 1, benchParams) }},
+		{"WriteBeginRequest", func() { _ = w.WriteBeginRequest(1, BeginRequestBody{Role: RoleResponder}) }},
+		{"ReadRecord", func() {
+			src.Reset(encoded.Bytes())
+			_, _ = r.ReadRecord()
+		}},
+	}
+	for _, tt := range tests {
+		tt.fn() // warm up reusable buffers
+		if n := testing.AllocsPerRun(100, tt.fn); n != 0 {
+			t.Errorf("%s: expected 0 allocations, got %v", tt.name, n)
+		}
+	}
+}
+
+// tcpDiscard returns a loopback TCP connection whose peer discards everything.
+func tcpDiscard(b *testing.B) net.Conn {
+	b.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatalf("listen: %v", err)
+	}
+	b.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		b.Fatalf("dial: %v", err)
+	}
+	b.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func BenchmarkWriteRecord(b *testing.B) {
+	content := make([]byte, 4093)
+	b.Run("Buffered", func(b *testing.B) {
+		w := NewRecordWriter(io.Discard)
+		b.ReportAllocs()
+		b.SetBytes(int64(len(content)))
+		for i := 0; i < b.N; i++ {
+			_ = w.WriteRecord(TypeStdin, 1, content)
+		}
+	})
+	b.Run("Vectored", func(b *testing.B) {
+		w := NewRecordWriter(tcpDiscard(b))
+		b.ReportAllocs()
+		b.SetBytes(int64(len(content)))
+		for i := 0; i < b.N; i++ {
+			_ = w.WriteRecord(TypeStdin, 1, content)
+		}
+	})
+}
+
+func BenchmarkWritePairs(b *testing.B) {
+	w := NewRecordWriter(io.Discard)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = w.WritePairs(TypeParams, 1, benchParams)
+	}
+}
+
+func BenchmarkReadRecord(b *testing.B) {
+	var encoded bytes.Buffer
+	_ = NewRecordWriter(&encoded).WriteRecord(TypeStdout, 1, make([]byte, 4093))
+	src := bytes.NewReader(encoded.Bytes())
+	r := NewRecordReader(src)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(encoded.Len()))
+	for i := 0; i < b.N; i++ {
+		src.Reset(encoded.Bytes())
+		if _, err := r.ReadRecord(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
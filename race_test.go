@@ -0,0 +1,7 @@
+//go:build race
+
+package fcgx
+
+// raceEnabled reports whether the race detector is on. It makes sync.Pool
+// drop items at random, which breaks allocation counts.
+const raceEnabled = true
@@ -0,0 +1,178 @@
+package fcgx
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/gophpeek/fcgx/internal/fcgitest"
+	"github.com/gophpeek/fcgx/protocol"
+)
+
+func TestUnexpectedRecords(t *testing.T) {
+	okBody := "Content-Type: text/plain\r\n\r\nok"
+
+	tests := []struct {
+		name       string
+		before     func(w io.Writer, req *fcgitest.Request)
+		strictFail bool
+	}{
+		{
+			name: "UnknownRecordType",
+			before: func(w io.Writer, req *fcgitest.Request) {
+				fcgitest.WriteRecord(w, protocol.RecordType(42), req.ID, []byte("ignored payload"))
+			},
+			strictFail: true,
+		},
+		{
+			name: "OtherRequestID",
+			before: func(w io.Writer, req *fcgitest.Request) {
+				fcgitest.WriteRecord(w, protocol.TypeStdout, req.ID+1, []byte("not ours"))
+			},
+			strictFail: true,
+		},
+		{
+			name: "ManagementRecord",
+			before: func(w io.Writer, req *fcgitest.Request) {
+				fcgitest.WriteRecord(w, protocol.TypeGetValuesResult, 0, []byte{0})
+			},
+			strictFail: true,
+		},
+		{
+			name: "WrongVersion",
+			before: func(w io.Writer, req *fcgitest.Request) {
+				var buf bytes.Buffer
+				fcgitest.WriteRecord(&buf, protocol.TypeStdout, req.ID, nil)
+				b := buf.Bytes()
+				b[0] = 2
+				_, _ = w.Write(b)
+			},
+			strictFail: true,
+		},
+	}
+
+	for _, tt := range tests {
+		for _, strict := range []bool{false, true} {
+			name := tt.name
+			if strict {
+				name += "/Strict"
+			}
+			t.Run(name, func(t *testing.T) {
+				srv := fcgitest.NewServer(t, func(w io.Writer, req *fcgitest.Request) {
+					tt.before(w, req)
+					fcgitest.RespondWith(okBody)(w, req)
+				})
+				config := DefaultConfig()
+				config.StrictProtocol = strict
+				client, err := DialWithConfig("tcp", srv.Addr(), config)
+				if err != nil {
+					t.Fatalf("Dial failed: %v", err)
+				}
+				defer client.Close()
+
+				err = doGet(t, client)
+				if strict && tt.strictFail {
+					if !errors.Is(err, ErrProtocol) {
+						t.Fatalf("Expected ErrProtocol, got %v", err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("Expected record to be skipped, got %v", err)
+				}
+			})
+		}
+	}
+}
+
+func TestStderrNotInResponse(t *testing.T) {
+	// PHP may write to stderr before, between and after stdout records
+	srv := fcgitest.NewServer(t, func(w io.Writer, req *fcgitest.Request) {
+		fcgitest.WriteRecord(w, protocol.TypeStderr, req.ID, []byte("PHP Warning: a\n"))
+		fcgitest.WriteRecord(w, protocol.TypeStdout, req.ID, []byte("Status: 201 Created\r\nContent-Type: text/plain\r\n"))
+		fcgitest.WriteRecord(w, protocol.TypeStderr, req.ID, []byte("PHP Notice: b\n"))
+		fcgitest.WriteRecord(w, protocol.TypeStdout, req.ID, []byte("\r\nhello"))
+		fcgitest.WriteRecord(w, protocol.TypeStderr, req.ID, []byte("PHP Notice: c\n"))
+		fcgitest.WriteRecord(w, protocol.TypeStdout, req.ID, nil)
+		fcgitest.WriteRecord(w, protocol.TypeStderr, req.ID, nil)
+		fcgitest.WriteEndRequest(w, req.ID, 0, protocol.StatusRequestComplete)
+	})
+	client, err := Dial("tcp", srv.Addr())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close()
+
+	var stderr bytes.Buffer
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	ctx = WithTrace(ctx, &Trace{StderrChunk: func(p []byte) { stderr.Write(p) }})
+	resp, err := client.Get(ctx, testParams())
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("Reading body failed: %v", err)
+	}
+
+	if resp.StatusCode != 201 || resp.Header.Get("Content-Type") != "text/plain" {
+		t.Errorf("Expected headers unaffected by stderr, got %d %v", resp.StatusCode, resp.Header)
+	}
+	if string(body) != "hello" {
+		t.Errorf("Expected body %q, got %q", "hello", body)
+	}
+	if want := "PHP Warning: a\nPHP Notice: b\nPHP Notice: c\n"; stderr.String() != want {
+		t.Errorf("Expected stderr %q through the trace, got %q", want, stderr.String())
+	}
+}
+
+func TestUnknownTypeRecord(t *testing.T) {
+	srv := fcgitest.NewServer(t, func(w io.Writer, req *fcgitest.Request) {
+		fcgitest.WriteRecord(w, protocol.TypeUnknownType, 0, []byte{byte(protocol.TypeGetValues), 0, 0, 0, 0, 0, 0, 0})
+	})
+	client, err := Dial("tcp", srv.Addr())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_, err = client.Get(ctx, testParams())
+	if !errors.Is(err, ErrProtocol) {
+		t.Fatalf("Expected ErrProtocol, got %v", err)
+	}
+	var unknown *UnknownTypeError
+	if !errors.As(err, &unknown) {
+		t.Fatalf("Expected UnknownTypeError, got %v", err)
+	}
+	if unknown.Type != protocol.TypeGetValues {
+		t.Errorf("Expected type %d, got %d", protocol.TypeGetValues, unknown.Type)
+	}
+}
+
+func TestRecordPaddingConsumed(t *testing.T) {
+	// A record with padding beyond what the writer would produce must still be
+	// skipped entirely before the next header is read.
+	srv := fcgitest.NewServer(t, func(w io.Writer, req *fcgitest.Request) {
+		b := make([]byte, protocol.HeaderLen+16)
+		h := protocol.Header{Version: protocol.Version1, Type: 42, RequestID: req.ID, ContentLength: 3, PaddingLength: 13}
+		h.Encode(b)
+		_, _ = w.Write(b)
+		fcgitest.RespondWith("Content-Type: text/plain\r\n\r\nok")(w, req)
+	})
+	client, err := Dial("tcp", srv.Addr())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close()
+
+	if err := doGet(t, client); err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+}
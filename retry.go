@@ -0,0 +1,164 @@
+package fcgx
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"strings"
+	"time"
+)
+
+// RetryPolicy controls how failed requests and connection attempts are
+// retried. Requests are only retried for idempotent methods unless
+// RetryNonIdempotent is set; errors that prove the request never reached the
+// application (ErrConnect, ErrConnClosedByServer before sending, ErrOverloaded)
+// are retried for every method. Request bodies are buffered so they can be
+// replayed on each attempt. Zero fields take their defaults, so
+// &RetryPolicy{MaxAttempts: 5} keeps the default backoff and RetryOn.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first.
+	// 1 or a negative value disables retries.
+	// Default: 3
+	MaxAttempts int
+
+	// InitialBackoff is the delay before the first retry.
+	// Default: 100 milliseconds
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the delay between attempts.
+	// Default: 2 seconds
+	MaxBackoff time.Duration
+
+	// Multiplier grows the delay after each attempt.
+	// Default: 2
+	Multiplier float64
+
+	// Jitter randomly shortens each delay by up to this fraction (0 to 1) so
+	// callers retrying at the same time spread out. A negative value
+	// disables jitter.
+	// Default: 0.2
+	Jitter float64
+
+	// RetryOn lists the sentinel errors that are retried.
+	// Default: ErrConnect, ErrConnClosedByServer, ErrUnexpectedEOF, ErrOverloaded
+	RetryOn []error
+
+	// RetryNonIdempotent allows retrying methods such as POST after errors
+	// that may have happened once the application started processing them.
+	// Default: false
+	RetryNonIdempotent bool
+}
+
+// DefaultRetryPolicy returns a RetryPolicy with sensible defaults
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     2 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+		RetryOn:        []error{ErrConnect, ErrConnClosedByServer, ErrUnexpectedEOF, ErrOverloaded},
+	}
+}
+
+// notSentErrors are errors that guarantee the application never processed
+// the request, so retrying is safe for any method.
+var notSentErrors = []error{ErrConnect, ErrConnClosedByServer, ErrOverloaded, ErrCircuitOpen}
+
+// idempotentMethods are the HTTP methods that are safe to repeat (RFC 9110).
+var idempotentMethods = map[string]bool{
+	"":        true, // PHP-FPM treats a missing REQUEST_METHOD like GET
+	"GET":     true,
+	"HEAD":    true,
+	"OPTIONS": true,
+	"TRACE":   true,
+	"PUT":     true,
+	"DELETE":  true,
+}
+
+func isAny(err error, targets []error) bool {
+	for _, target := range targets {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// enabled reports whether p allows more than one attempt.
+func (p *RetryPolicy) enabled() bool {
+	return p != nil && (p.MaxAttempts == 0 || p.MaxAttempts > 1)
+}
+
+// withDefaults returns a copy of p with zero fields set to their defaults.
+func (p *RetryPolicy) withDefaults() *RetryPolicy {
+	if p == nil {
+		return nil
+	}
+	c := *p
+	d := DefaultRetryPolicy()
+	if c.MaxAttempts == 0 {
+		c.MaxAttempts = d.MaxAttempts
+	}
+	if c.InitialBackoff == 0 {
+		c.InitialBackoff = d.InitialBackoff
+	}
+	if c.MaxBackoff == 0 {
+		c.MaxBackoff = d.MaxBackoff
+	}
+	if c.Multiplier == 0 {
+		c.Multiplier = d.Multiplier
+	}
+	if c.Jitter == 0 {
+		c.Jitter = d.Jitter
+	}
+	if c.RetryOn == nil {
+		c.RetryOn = d.RetryOn
+	}
+	return &c
+}
+
+// shouldRetry reports whether a request with the given method that failed
+// with err on the given attempt may be attempted again.
+func (p *RetryPolicy) shouldRetry(attempt int, method string, err error) bool {
+	if !p.enabled() || attempt >= p.MaxAttempts {
+		return false
+	}
+	if !isAny(err, p.RetryOn) {
+		return false
+	}
+	return p.RetryNonIdempotent || idempotentMethods[strings.ToUpper(method)] || isAny(err, notSentErrors)
+}
+
+// backoff returns the delay before the attempt following the given one.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		d -= d * math.Min(p.Jitter, 1) * rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+// withRetry calls fn until it succeeds, the policy gives up or ctx is done.
+// A nil policy calls fn exactly once.
+func withRetry[T any](ctx context.Context, p *RetryPolicy, method string, fn func(attempt int) (T, error)) (T, error) {
+	p = p.withDefaults()
+	for attempt := 1; ; attempt++ {
+		v, err := fn(attempt)
+		if err == nil || !p.shouldRetry(attempt, method, err) {
+			return v, err
+		}
+
+		timer := time.NewTimer(p.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return v, err
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package fcgx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gophpeek/fcgx/internal/fcgitest"
+	"github.com/gophpeek/fcgx/protocol"
+)
+
+func fastRetryPolicy() *RetryPolicy {
+	p := DefaultRetryPolicy()
+	p.InitialBackoff = time.Millisecond
+	p.MaxBackoff = 5 * time.Millisecond
+	return p
+}
+
+func TestRetryPolicyShouldRetry(t *testing.T) {
+	p := DefaultRetryPolicy()
+	eof := fmt.Errorf("%w: reading header", ErrUnexpectedEOF)
+	connect := fmt.Errorf("%w: dialing", ErrConnect)
+
+	tests := []struct {
+		attempt int
+		method  string
+		err     error
+		want    bool
+	}{
+		{1, "GET", eof, true},
+		{1, "", eof, true},
+		{1, "delete", eof, true},
+		{1, "POST", eof, false},
+		{1, "POST", connect, true},
+		{1, "POST", fmt.Errorf("%w: rejected", ErrOverloaded), true},
+		{1, "GET", fmt.Errorf("%w: slow", ErrTimeout), false},
+		{1, "GET", ErrContextCancelled, false},
+		{2, "GET", eof, true},
+		{3, "GET", eof, false},
+	}
+	for _, tt := range tests {
+		if got := p.shouldRetry(tt.attempt, tt.method, tt.err); got != tt.want {
+			t.Errorf("shouldRetry(%d, %q, %v) = %v, want %v", tt.attempt, tt.method, tt.err, got, tt.want)
+		}
+	}
+
+	p.RetryNonIdempotent = true
+	if !p.shouldRetry(1, "POST", eof) {
+		t.Error("Expected POST to be retried with RetryNonIdempotent")
+	}
+
+	var nilPolicy *RetryPolicy
+	if nilPolicy.shouldRetry(1, "GET", eof) {
+		t.Error("Expected nil policy never to retry")
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := &RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2}
+	for attempt, want := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 400 * time.Millisecond, 5: time.Second} {
+		if got := p.backoff(attempt); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := p.backoff(2); d < 100*time.Millisecond || d > 200*time.Millisecond {
+			t.Fatalf("Expected jittered backoff within [100ms, 200ms], got %v", d)
+		}
+	}
+}
+
+func TestRetryPolicyDefaults(t *testing.T) {
+	p := (&RetryPolicy{MaxAttempts: 5}).withDefaults()
+	d := DefaultRetryPolicy()
+	if p.MaxAttempts != 5 || p.InitialBackoff != d.InitialBackoff || p.MaxBackoff != d.MaxBackoff ||
+		p.Multiplier != d.Multiplier || p.Jitter != d.Jitter || len(p.RetryOn) != len(d.RetryOn) {
+		t.Errorf("Expected zero fields to take defaults, got %+v", p)
+	}
+	if !(&RetryPolicy{}).enabled() {
+		t.Error("Expected a zero MaxAttempts to enable retries")
+	}
+	if (&RetryPolicy{MaxAttempts: 1}).enabled() {
+		t.Error("Expected MaxAttempts 1 to disable retries")
+	}
+	if p := (&RetryPolicy{Jitter: -1}).withDefaults(); p.Jitter != -1 || p.backoff(1) != d.InitialBackoff {
+		t.Errorf("Expected negative Jitter to disable jitter, got %v", p.backoff(1))
+	}
+}
+
+// flakyServer drops the connection without a response for the first n requests.
+func flakyServer(t *testing.T, n int32, fail fcgitest.Handler) (*fcgitest.Server, *atomic.Int32) {
+	var calls atomic.Int32
+	srv := fcgitest.NewServer(t, func(w io.Writer, req *fcgitest.Request) {
+		if calls.Add(1) <= n {
+			fail(w, req)
+			return
+		}
+		fcgitest.RespondWith("Content-Type: text/plain\r\n\r\n"+string(req.Stdin)+"ok")(w, req)
+	})
+	return srv, &calls
+}
+
+func dropConn(w io.Writer, req *fcgitest.Request) {
+	w.(net.Conn).Close()
+}
+
+func overload(w io.Writer, req *fcgitest.Request) {
+	fcgitest.WriteEndRequest(w, req.ID, 0, protocol.StatusOverloaded)
+}
+
+func TestClientRetry(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	t.Run("IdempotentAfterEOF", func(t *testing.T) {
+		srv, calls := flakyServer(t, 2, dropConn)
+		config := DefaultConfig()
+		config.Retry = fastRetryPolicy()
+		client, err := DialWithConfig("tcp", srv.Addr(), config)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		defer client.Close()
+
+		if err := doGet(t, client); err != nil {
+			t.Fatalf("Expected retries to succeed, got %v", err)
+		}
+		if calls.Load() != 3 || srv.Connections() != 3 {
+			t.Errorf("Expected 3 attempts on 3 connections, got %d on %d", calls.Load(), srv.Connections())
+		}
+	})
+
+	t.Run("GivesUp", func(t *testing.T) {
+		srv, calls := flakyServer(t, 5, dropConn)
+		config := DefaultConfig()
+		config.Retry = fastRetryPolicy()
+		client, err := DialWithConfig("tcp", srv.Addr(), config)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		defer client.Close()
+
+		if err := doGet(t, client); !errors.Is(err, ErrUnexpectedEOF) {
+			t.Fatalf("Expected ErrUnexpectedEOF, got %v", err)
+		}
+		if calls.Load() != 3 {
+			t.Errorf("Expected 3 attempts, got %d", calls.Load())
+		}
+	})
+
+	t.Run("PostNotRetriedAfterEOF", func(t *testing.T) {
+		srv, calls := flakyServer(t, 1, dropConn)
+		config := DefaultConfig()
+		config.Retry = fastRetryPolicy()
+		client, err := DialWithConfig("tcp", srv.Addr(), config)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		defer client.Close()
+
+		_, err = client.Post(ctx, testParams(), strings.NewReader("a=1"), 3)
+		if !errors.Is(err, ErrUnexpectedEOF) {
+			t.Fatalf("Expected ErrUnexpectedEOF, got %v", err)
+		}
+		if calls.Load() != 1 {
+			t.Errorf("Expected a single attempt, got %d", calls.Load())
+		}
+	})
+
+	t.Run("PostReplaysBodyAfterOverload", func(t *testing.T) {
+		srv, calls := flakyServer(t, 1, overload)
+		config := DefaultConfig()
+		config.Retry = fastRetryPolicy()
+		client, err := DialWithConfig("tcp", srv.Addr(), config)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		defer client.Close()
+
+		resp, err := client.Post(ctx, testParams(), strings.NewReader("a=1"), 3)
+		if err != nil {
+			t.Fatalf("Expected retry to succeed, got %v", err)
+		}
+		body, _ := ReadBody(resp)
+		if string(body) != "a=1ok" {
+			t.Errorf("Expected replayed body, got %q", body)
+		}
+		if calls.Load() != 2 || srv.Request(1).Params["REQUEST_METHOD"] != "POST" {
+			t.Errorf("Expected 2 POST attempts, got %d", calls.Load())
+		}
+	})
+	t.Run("PartialPolicy", func(t *testing.T) {
+		srv, calls := flakyServer(t, 2, dropConn)
+		config := DefaultConfig()
+		config.Retry = &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+		client, err := DialWithConfig("tcp", srv.Addr(), config)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		defer client.Close()
+
+		if err := doGet(t, client); err != nil {
+			t.Fatalf("Expected retries with the default RetryOn to succeed, got %v", err)
+		}
+		if calls.Load() != 3 {
+			t.Errorf("Expected 3 attempts, got %d", calls.Load())
+		}
+	})
+
+	t.Run("RedialNotRetriedTwice", func(t *testing.T) {
+		srv, _ := flakyServer(t, 1, dropConn)
+		var dials atomic.Int32
+		config := DefaultConfig()
+		config.Retry = fastRetryPolicy()
+		config.Dialer = func(ctx context.Context, network, address string) (net.Conn, error) {
+			if dials.Add(1) > 1 {
+				return nil, errors.New("refused")
+			}
+			var d net.Dialer
+			return d.DialContext(ctx, network, address)
+		}
+		client, err := DialWithConfig("tcp", srv.Addr(), config)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		defer client.Close()
+
+		if err := doGet(t, client); !errors.Is(err, ErrConnect) {
+			t.Fatalf("Expected ErrConnect, got %v", err)
+		}
+		// The first dial, then one redial for each of the two retries
+		if dials.Load() != 3 {
+			t.Errorf("Expected 3 dials, got %d", dials.Load())
+		}
+	})
+}
+
+func TestBalancerRetry(t *testing.T) {
+	srv, calls := flakyServer(t, 2, overload)
+	config := DefaultBalancerConfig()
+	config.ClientConfig.Retry = fastRetryPolicy()
+	config.EjectDuration = time.Millisecond
+	bal, err := NewBalancer([]Backend{{Network: "tcp", Address: srv.Addr()}}, config)
+	if err != nil {
+		t.Fatalf("NewBalancer failed: %v", err)
+	}
+
+	if err := balancerGet(t, bal, testParams()); err != nil {
+		t.Fatalf("Expected retries to succeed, got %v", err)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("Expected 3 attempts, got %d", calls.Load())
+	}
+}
@@ -0,0 +1,214 @@
+package fcgx
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// hopByHopHeaders apply to a single connection and must not be forwarded
+// (RFC 9110, section 7.6.1).
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// HeaderPolicy cleans up responses produced by PHP before they are forwarded
+// to a client. Sanitize removes hop-by-hop headers, applies a CGI Status
+// header that PHP emitted after other headers, makes Content-Length match
+// the body, and applies the Allow and Deny lists.
+type HeaderPolicy struct {
+	// Allow, if not empty, lists the only headers kept. Content-Length is
+	// always kept since it describes the body.
+	// Default: nil (all headers)
+	Allow []string
+
+	// Deny lists headers that are removed, such as X-Powered-By.
+	// Default: nil
+	Deny []string
+
+	// Strict makes Sanitize fail with ErrInvalidResponse on conflicting
+	// Status or Content-Length headers, or a Content-Length that does not
+	// match the body, instead of repairing them.
+	// Default: false
+	Strict bool
+}
+
+// SanitizeResponse cleans resp with an empty HeaderPolicy, removing
+// hop-by-hop headers and repairing Status and Content-Length.
+func SanitizeResponse(resp *http.Response) error {
+	return (&HeaderPolicy{}).Sanitize(resp)
+}
+
+// Sanitize cleans resp in place. To check Content-Length it may read the
+// body into memory and replace resp.Body, unless the response comes from
+// this package, whose body is buffered already. The Content-Length of a
+// response to a HEAD request, as told by resp.Request, is left alone.
+func (p *HeaderPolicy) Sanitize(resp *http.Response) error {
+	method := ""
+	if resp.Request != nil {
+		method = resp.Request.Method
+	}
+	return p.sanitize(resp, method)
+}
+
+// sanitize cleans resp, the response to a request with the given method.
+func (p *HeaderPolicy) sanitize(resp *http.Response, method string) error {
+	if resp.Header == nil {
+		resp.Header = http.Header{}
+	}
+	if err := p.applyStatus(resp); err != nil {
+		return err
+	}
+	p.SanitizeHeader(resp.Header)
+	if err := p.fixContentLength(resp, method); err != nil {
+		return err
+	}
+	// Any chunked encoding has already been decoded
+	resp.TransferEncoding = nil
+	return nil
+}
+
+// SanitizeHeader removes hop-by-hop headers, including those named in
+// Connection, and applies the Allow and Deny lists to h. It leaves Status
+// and Content-Length alone, so handlers can use it on headers they copy.
+func (p *HeaderPolicy) SanitizeHeader(h http.Header) {
+	for _, v := range h["Connection"] {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		delete(h, name)
+	}
+
+	if len(p.Allow) > 0 {
+		for name := range h {
+			if name != "Content-Length" && !containsHeader(p.Allow, name) {
+				delete(h, name)
+			}
+		}
+	}
+	for _, name := range p.Deny {
+		h.Del(name)
+	}
+}
+
+func containsHeader(names []string, name string) bool {
+	for _, n := range names {
+		if textproto.CanonicalMIMEHeaderKey(n) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// applyStatus moves a CGI Status header into the response status. PHP-FPM
+// only turns it into the status line when it comes first.
+func (p *HeaderPolicy) applyStatus(resp *http.Response) error {
+	values := resp.Header["Status"]
+	if len(values) == 0 {
+		return nil
+	}
+	delete(resp.Header, "Status")
+	if p.Strict && !allEqual(values) {
+		return p.invalid("conflicting Status headers %q", values)
+	}
+	status := strings.TrimSpace(values[0])
+	code, _, _ := strings.Cut(status, " ")
+	n, err := strconv.Atoi(code)
+	if err != nil || len(code) != 3 || n < 100 {
+		if p.Strict {
+			return p.invalid("invalid Status header %q", status)
+		}
+		return nil
+	}
+	if status == code {
+		status += " " + http.StatusText(n)
+	}
+	resp.StatusCode, resp.Status = n, status
+	return nil
+}
+
+// fixContentLength sets Content-Length to the length of the body, or
+// removes it from responses that cannot have a body.
+func (p *HeaderPolicy) fixContentLength(resp *http.Response, method string) error {
+	declared := resp.Header["Content-Length"]
+	if p.Strict && !allEqual(declared) {
+		return p.invalid("conflicting Content-Length headers %q", declared)
+	}
+	if bodyless(resp.StatusCode) {
+		delete(resp.Header, "Content-Length")
+		resp.ContentLength = 0
+		return nil
+	}
+	if method == http.MethodHead {
+		// The body is omitted, so the length cannot be checked
+		return nil
+	}
+
+	n, err := bodyLength(resp)
+	if err != nil {
+		return &Error{Op: "reading response body", Kind: ErrRead, Err: err}
+	}
+	if p.Strict && len(declared) > 0 {
+		if v, err := strconv.ParseInt(strings.TrimSpace(declared[0]), 10, 64); err != nil || v != n {
+			return p.invalid("Content-Length %q does not match the body length %d", declared[0], n)
+		}
+	}
+	resp.Header["Content-Length"] = []string{strconv.FormatInt(n, 10)}
+	resp.ContentLength = n
+	return nil
+}
+
+// bodyLength returns the length of the unread body. Bodies not buffered by
+// this package are read into memory.
+func bodyLength(resp *http.Response) (int64, error) {
+	if resp.Body == nil || resp.Body == http.NoBody {
+		return 0, nil
+	}
+	if b, ok := resp.Body.(*pooledBody); ok && !chunked(resp.TransferEncoding) {
+		return int64(b.reader.Buffered() + b.buf.Len()), nil
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return 0, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	return int64(len(data)), nil
+}
+
+// bodyless reports whether responses with status code must not have a body.
+func bodyless(code int) bool {
+	return code >= 100 && code < 200 || code == http.StatusNoContent || code == http.StatusNotModified
+}
+
+func allEqual(values []string) bool {
+	if len(values) < 2 {
+		return true
+	}
+	for _, v := range values[1:] {
+		if strings.TrimSpace(v) != strings.TrimSpace(values[0]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *HeaderPolicy) invalid(format string, args ...any) error {
+	return wrapPhase(fmt.Errorf(format, args...), ErrInvalidResponse, PhaseParse, "sanitizing response")
+}
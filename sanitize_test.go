@@ -0,0 +1,151 @@
+package fcgx
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gophpeek/fcgx/internal/fcgitest"
+)
+
+func testResponse(header http.Header, body string) *http.Response {
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: 200,
+		Header:     header,
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+}
+
+func TestSanitizeResponse(t *testing.T) {
+	resp := testResponse(http.Header{
+		"Connection":        {"keep-alive, X-Internal"},
+		"Keep-Alive":        {"timeout=5"},
+		"X-Internal":        {"secret"},
+		"Transfer-Encoding": {"chunked"},
+		"Upgrade":           {"h2c"},
+		"Content-Length":    {"100", "5"},
+		"Content-Type":      {"text/html"},
+		"Status":            {"404"},
+	}, "hello")
+	if err := SanitizeResponse(resp); err != nil {
+		t.Fatalf("SanitizeResponse failed: %v", err)
+	}
+
+	for _, name := range []string{"Connection", "Keep-Alive", "X-Internal", "Transfer-Encoding", "Upgrade", "Status"} {
+		if _, ok := resp.Header[name]; ok {
+			t.Errorf("Expected %s to be removed", name)
+		}
+	}
+	if resp.StatusCode != 404 || resp.Status != "404 Not Found" {
+		t.Errorf("Expected Status header to be applied, got %q", resp.Status)
+	}
+	if got := resp.Header["Content-Length"]; len(got) != 1 || got[0] != "5" || resp.ContentLength != 5 {
+		t.Errorf("Expected Content-Length 5, got %v (%d)", got, resp.ContentLength)
+	}
+	if body, _ := io.ReadAll(resp.Body); string(body) != "hello" {
+		t.Errorf("Expected body to be preserved, got %q", body)
+	}
+	if resp.Header.Get("Content-Type") != "text/html" {
+		t.Error("Expected Content-Type to be kept")
+	}
+}
+
+func TestHeaderPolicyLists(t *testing.T) {
+	h := http.Header{
+		"Content-Type":   {"text/html"},
+		"Content-Length": {"2"},
+		"Set-Cookie":     {"a=b"},
+		"X-Powered-By":   {"PHP/8.3"},
+		"X-Debug":        {"1"},
+	}
+	p := &HeaderPolicy{Allow: []string{"content-type", "set-cookie", "x-powered-by"}, Deny: []string{"x-powered-by"}}
+	p.SanitizeHeader(h)
+	if len(h) != 3 || h.Get("Content-Type") == "" || h.Get("Set-Cookie") == "" || h.Get("Content-Length") == "" {
+		t.Errorf("Unexpected headers after allow and deny lists: %v", h)
+	}
+}
+
+func TestHeaderPolicyStrict(t *testing.T) {
+	p := &HeaderPolicy{Strict: true}
+	tests := []struct {
+		name   string
+		header http.Header
+		want   string
+	}{
+		{"conflicting length", http.Header{"Content-Length": {"2", "3"}}, "conflicting Content-Length"},
+		{"wrong length", http.Header{"Content-Length": {"10"}}, "does not match the body length 2"},
+		{"conflicting status", http.Header{"Status": {"404", "500"}}, "conflicting Status"},
+		{"invalid status", http.Header{"Status": {"oops"}}, "invalid Status"},
+	}
+	for _, tt := range tests {
+		err := p.Sanitize(testResponse(tt.header, "ok"))
+		if !errors.Is(err, ErrInvalidResponse) || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: expected ErrInvalidResponse containing %q, got %v", tt.name, tt.want, err)
+		}
+	}
+	if err := p.Sanitize(testResponse(http.Header{"Content-Length": {"2", "2"}}, "ok")); err != nil {
+		t.Errorf("Expected duplicate but equal Content-Length to pass, got %v", err)
+	}
+
+	// Responses without a body lose their Content-Length
+	resp := testResponse(http.Header{"Status": {"304 Not Modified"}, "Content-Length": {"10"}}, "")
+	if err := p.Sanitize(resp); err != nil || resp.Header.Get("Content-Length") != "" || resp.StatusCode != 304 {
+		t.Errorf("Unexpected 304 response %v, %v", resp.Header, err)
+	}
+}
+
+func TestClientHeaderPolicy(t *testing.T) {
+	s := fcgitest.NewServer(t, fcgitest.RespondWith("Content-Type: text/plain\r\nContent-Length: 99\r\n"+
+		"X-Powered-By: PHP/8.3\r\nStatus: 201 Created\r\n\r\ncreated"))
+	config := DefaultConfig()
+	config.HeaderPolicy = &HeaderPolicy{Deny: []string{"X-Powered-By"}}
+	client, err := DialWithConfig("tcp", s.Addr(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	resp, err := client.Get(context.Background(), map[string]string{"SCRIPT_FILENAME": "/index.php"})
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if resp.StatusCode != 201 || resp.ContentLength != 7 || resp.Header.Get("X-Powered-By") != "" {
+		t.Errorf("Expected sanitized response, got %d %v", resp.StatusCode, resp.Header)
+	}
+	if body, _ := ReadBody(resp); string(body) != "created" {
+		t.Errorf("Expected body created, got %q", body)
+	}
+}
+
+func TestClientHeaderPolicyHead(t *testing.T) {
+	// PHP-FPM omits the body of a HEAD response but keeps its Content-Length
+	s := fcgitest.NewServer(t, fcgitest.RespondWith("Content-Type: text/plain\r\nContent-Length: 1234\r\n\r\n"))
+	config := DefaultConfig()
+	config.HeaderPolicy = &HeaderPolicy{Strict: true}
+	client, err := DialWithConfig("tcp", s.Addr(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	params := map[string]string{"SCRIPT_FILENAME": "/index.php", "REQUEST_METHOD": "HEAD"}
+	resp, err := client.DoRequest(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("HEAD request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Length"); got != "1234" {
+		t.Errorf("Expected Content-Length 1234 to be kept, got %q", got)
+	}
+
+	// Responses built elsewhere name the method through resp.Request
+	resp = testResponse(http.Header{"Content-Length": {"1234"}}, "")
+	resp.Request = &http.Request{Method: http.MethodHead}
+	if err := (&HeaderPolicy{Strict: true}).Sanitize(resp); err != nil || resp.Header.Get("Content-Length") != "1234" {
+		t.Errorf("Expected HEAD Content-Length to be kept, got %v, %v", resp.Header, err)
+	}
+}
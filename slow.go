@@ -0,0 +1,245 @@
+package fcgx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SlowRequest describes a request that took at least
+// Config.SlowRequestThreshold. It is passed to Config.OnSlowRequest.
+type SlowRequest struct {
+	Params  map[string]string // Params the request was sent with
+	Addr    string            // Server address
+	Elapsed time.Duration     // Time from sending the request until it finished
+	Status  int               // HTTP status, or 0 when the request failed
+	Err     error             // Request error, if any
+
+	// Worker is the PHP-FPM worker that was running the request when the
+	// threshold was crossed. It is nil unless Config.SlowRequestStatus is
+	// set and the worker could be identified.
+	Worker *FPMProcess
+
+	// SnapshotErr reports why fetching the status snapshot failed.
+	SnapshotErr error
+}
+
+// FPMProcess is one worker entry of the PHP-FPM "status?full" page.
+type FPMProcess struct {
+	PID               int
+	State             string // "Idle", "Running", "Reading headers", ...
+	StartTime         time.Time
+	StartSince        time.Duration
+	Requests          int64
+	RequestDuration   time.Duration // Of the current request, or the last one when idle
+	RequestMethod     string
+	RequestURI        string // Including the query string
+	ContentLength     int64
+	User              string
+	Script            string
+	LastRequestCPU    float64 // Percent; reported once a request has finished
+	LastRequestMemory int64   // Bytes; reported once a request has finished
+}
+
+// UnmarshalJSON decodes a process entry of the "status?json&full" page.
+func (p *FPMProcess) UnmarshalJSON(data []byte) error {
+	var j fpmProcessJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*p = FPMProcess{
+		PID:               j.PID,
+		State:             j.State,
+		StartTime:         time.Unix(j.StartTime, 0),
+		StartSince:        time.Duration(j.StartSince) * time.Second,
+		Requests:          j.Requests,
+		RequestDuration:   time.Duration(j.RequestDuration) * time.Microsecond,
+		RequestMethod:     j.RequestMethod,
+		RequestURI:        j.RequestURI,
+		ContentLength:     j.ContentLength,
+		User:              j.User,
+		Script:            j.Script,
+		LastRequestCPU:    j.LastRequestCPU,
+		LastRequestMemory: j.LastRequestMemory,
+	}
+	return nil
+}
+
+// StatusEndpoint locates the PHP-FPM status page, configured with
+// pm.status_path and optionally pm.status_listen. The page is fetched
+// through the client's Dialer and TLSConfig.
+type StatusEndpoint struct {
+	// Network and Address of the status listener. Empty values use the
+	// client's own, so set them when pm.status_listen is configured.
+	Network string
+	Address string
+
+	// Path is the pool's pm.status_path.
+	// Default: "/status"
+	Path string
+
+	// Timeout limits fetching the snapshot, including the dial.
+	// Default: 1 second
+	Timeout time.Duration
+}
+
+// fpmProcessJSON is the JSON form of a process in "status?json&full".
+type fpmProcessJSON struct {
+	PID               int     `json:"pid"`
+	State             string  `json:"state"`
+	StartTime         int64   `json:"start time"`
+	StartSince        int64   `json:"start since"`
+	Requests          int64   `json:"requests"`
+	RequestDuration   int64   `json:"request duration"` // Microseconds
+	RequestMethod     string  `json:"request method"`
+	RequestURI        string  `json:"request uri"`
+	ContentLength     int64   `json:"content length"`
+	User              string  `json:"user"`
+	Script            string  `json:"script"`
+	LastRequestCPU    float64 `json:"last request cpu"`
+	LastRequestMemory int64   `json:"last request memory"`
+}
+
+// workerSnapshot is the outcome of fetching the status page mid-request.
+type workerSnapshot struct {
+	worker *FPMProcess
+	err    error
+}
+
+// watchSlowRequest fetches the status page once the request has run for
+// SlowRequestThreshold, while its worker is still busy with it. It returns
+// a function that reports the slow request, if it was slow, once the request
+// has finished.
+func (c *Client) watchSlowRequest(params map[string]string, start time.Time) func(*http.Response, error) {
+	threshold := c.config.SlowRequestThreshold
+	var snapshot chan workerSnapshot
+	var timer *time.Timer
+	if c.config.SlowRequestStatus != nil {
+		snapshot = make(chan workerSnapshot, 1)
+		timer = time.AfterFunc(threshold, func() {
+			worker, err := c.fetchWorker(params, time.Since(start))
+			snapshot <- workerSnapshot{worker, err}
+		})
+	}
+
+	return func(resp *http.Response, err error) {
+		elapsed := time.Since(start)
+		if timer != nil && timer.Stop() {
+			// Finished before the snapshot was started
+			snapshot = nil
+		}
+		if elapsed < threshold {
+			return
+		}
+
+		slow := SlowRequest{Params: params, Addr: c.address, Elapsed: elapsed, Err: err}
+		if resp != nil {
+			slow.Status = resp.StatusCode
+		}
+		if snapshot != nil {
+			s := <-snapshot
+			slow.Worker, slow.SnapshotErr = s.worker, s.err
+		}
+		c.config.OnSlowRequest(slow)
+	}
+}
+
+// fetchWorker queries the status page over a separate connection and finds
+// the worker running the request sent with params.
+func (c *Client) fetchWorker(params map[string]string, elapsed time.Duration) (*FPMProcess, error) {
+	ep := c.config.SlowRequestStatus
+	network, address := ep.Network, ep.Address
+	if network == "" {
+		network = c.network
+	}
+	if address == "" {
+		address = c.address
+	}
+	path := ep.Path
+	if path == "" {
+		path = "/status"
+	}
+	timeout := ep.Timeout
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	// Keep the transport, but not what applies to application requests
+	config := *c.config
+	config.ConnectTimeout = timeout
+	config.KeepConn = false
+	config.AutoRedial = false
+	config.Retry = nil
+	config.CircuitBreakers = nil
+	config.ParamPolicy = nil
+	config.HeaderPolicy = nil
+	config.SlowRequestThreshold = 0
+	config.OnSlowRequest = nil
+	config.SlowRequestStatus = nil
+	client, err := DialContextWithConfig(ctx, network, address, &config)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	resp, err := client.Get(ctx, map[string]string{
+		"SCRIPT_NAME":     path,
+		"SCRIPT_FILENAME": path,
+		"QUERY_STRING":    "json&full",
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("fcgx: status page %s returned %s", path, resp.Status)
+	}
+	var status struct {
+		Processes []FPMProcess `json:"processes"`
+	}
+	if err := ReadJSON(resp, &status); err != nil {
+		return nil, fmt.Errorf("fcgx: decoding status page: %w", err)
+	}
+	return findWorker(status.Processes, params, elapsed), nil
+}
+
+// findWorker picks the running worker whose script and URI match params. FPM
+// does not know the client's request ID, so among several matches the one
+// whose request duration is closest to elapsed wins.
+func findWorker(processes []FPMProcess, params map[string]string, elapsed time.Duration) *FPMProcess {
+	script := params["SCRIPT_FILENAME"]
+	uri, _, _ := strings.Cut(params["REQUEST_URI"], "?")
+
+	var best *FPMProcess
+	var bestDiff time.Duration
+	for i := range processes {
+		p := &processes[i]
+		if p.State != "Running" {
+			continue
+		}
+		if script != "" && p.Script != script {
+			continue
+		}
+		// FPM appends the query string to the URI it reports
+		if puri, _, _ := strings.Cut(p.RequestURI, "?"); uri != "" && puri != uri {
+			continue
+		}
+		diff := p.RequestDuration - elapsed
+		if diff < 0 {
+			diff = -diff
+		}
+		if best == nil || diff < bestDiff {
+			best, bestDiff = p, diff
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	w := *best
+	return &w
+}
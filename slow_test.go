@@ -0,0 +1,173 @@
+package fcgx
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gophpeek/fcgx/internal/fcgitest"
+)
+
+// fpmStatusJSON is a trimmed "status?json&full" page with the request under
+// test running in worker 42 and the status request itself in worker 43.
+const fpmStatusJSON = `{"pool":"www","processes":[
+{"pid":41,"state":"Idle","start time":1700000000,"requests":9,"request duration":100,"request uri":"/slow.php","script":"/var/www/slow.php"},
+{"pid":42,"state":"Running","start time":1700000000,"requests":3,"request duration":50000,"request method":"GET","request uri":"/slow.php?id=1","script":"/var/www/slow.php","user":"-","last request cpu":0.00,"last request memory":0},
+{"pid":43,"state":"Running","start time":1700000000,"requests":5,"request duration":10,"request uri":"/status?json&full","script":"-"}]}`
+
+// slowStatusHandler serves fpmStatusJSON on /status and sleeps on /slow.php.
+func slowStatusHandler(w io.Writer, req *fcgitest.Request) {
+	if req.Params["SCRIPT_NAME"] == "/status" {
+		fcgitest.RespondWith("Content-Type: application/json\r\n\r\n"+fpmStatusJSON)(w, req)
+		return
+	}
+	if req.Params["SCRIPT_NAME"] == "/slow.php" {
+		time.Sleep(100 * time.Millisecond)
+	}
+	fcgitest.RespondWith("Content-Type: text/plain\r\n\r\nok")(w, req)
+}
+
+func TestSlowRequest(t *testing.T) {
+	srv := fcgitest.NewServer(t, slowStatusHandler)
+
+	var mu sync.Mutex
+	var reports []SlowRequest
+	config := DefaultConfig()
+	config.KeepConn = true
+	config.SlowRequestThreshold = 30 * time.Millisecond
+	config.OnSlowRequest = func(r SlowRequest) {
+		mu.Lock()
+		defer mu.Unlock()
+		reports = append(reports, r)
+	}
+	config.SlowRequestStatus = &StatusEndpoint{}
+	client, err := DialWithConfig("tcp", srv.Addr(), config)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	for _, script := range []string{"/fast.php", "/slow.php"} {
+		params := testParams()
+		params["SCRIPT_NAME"] = script
+		params["SCRIPT_FILENAME"] = "/var/www" + script
+		params["REQUEST_URI"] = script + "?id=1"
+		resp, err := client.Get(ctx, params)
+		if err != nil {
+			t.Fatalf("Get %s failed: %v", script, err)
+		}
+		ReadBody(resp)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reports) != 1 {
+		t.Fatalf("Expected one slow request report, got %d", len(reports))
+	}
+	r := reports[0]
+	if r.Params["SCRIPT_NAME"] != "/slow.php" || r.Elapsed < 100*time.Millisecond || r.Status != 200 || r.Addr != srv.Addr() {
+		t.Errorf("Unexpected report %+v", r)
+	}
+	if r.SnapshotErr != nil {
+		t.Fatalf("Snapshot failed: %v", r.SnapshotErr)
+	}
+	if r.Worker == nil || r.Worker.PID != 42 || r.Worker.RequestDuration != 50*time.Millisecond {
+		t.Errorf("Expected worker 42, got %+v", r.Worker)
+	}
+}
+
+func TestSlowRequestDialer(t *testing.T) {
+	srv := fcgitest.NewServer(t, slowStatusHandler)
+
+	// The status page is only reachable through the dialer
+	var dials atomic.Int32
+	var report SlowRequest
+	config := DefaultConfig()
+	config.Dialer = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		dials.Add(1)
+		var d net.Dialer
+		return d.DialContext(ctx, network, srv.Addr())
+	}
+	config.SlowRequestThreshold = 30 * time.Millisecond
+	config.OnSlowRequest = func(r SlowRequest) { report = r }
+	config.SlowRequestStatus = &StatusEndpoint{}
+	client, err := DialWithConfig("tcp", "php-fpm.invalid:9000", config)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close()
+
+	params := testParams()
+	params["SCRIPT_NAME"] = "/slow.php"
+	params["SCRIPT_FILENAME"] = "/var/www/slow.php"
+	params["REQUEST_URI"] = "/slow.php?id=1"
+	resp, err := client.Get(context.Background(), params)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	ReadBody(resp)
+
+	if report.SnapshotErr != nil || report.Worker == nil || report.Worker.PID != 42 {
+		t.Errorf("Expected worker 42 through the dialer, got %+v", report)
+	}
+	if dials.Load() != 2 {
+		t.Errorf("Expected the status page to be dialed with Config.Dialer, got %d dials", dials.Load())
+	}
+}
+
+func TestSlowRequestSnapshotError(t *testing.T) {
+	srv := fcgitest.NewServer(t, func(w io.Writer, req *fcgitest.Request) {
+		time.Sleep(50 * time.Millisecond)
+		fcgitest.RespondWith("Content-Type: text/plain\r\n\r\nok")(w, req)
+	})
+
+	var report SlowRequest
+	config := DefaultConfig()
+	config.SlowRequestThreshold = 10 * time.Millisecond
+	config.OnSlowRequest = func(r SlowRequest) { report = r }
+	config.SlowRequestStatus = &StatusEndpoint{Network: "tcp", Address: fcgitest.DeadAddr(t)}
+	client, err := DialWithConfig("tcp", srv.Addr(), config)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close()
+
+	resp, err := client.Get(context.Background(), testParams())
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	ReadBody(resp)
+
+	// The request itself is still reported
+	if report.Elapsed == 0 || report.Worker != nil || report.SnapshotErr == nil {
+		t.Errorf("Expected report with snapshot error, got %+v", report)
+	}
+}
+
+func TestFindWorker(t *testing.T) {
+	processes := []FPMProcess{
+		{PID: 1, State: "Running", Script: "/app/index.php", RequestURI: "/a", RequestDuration: 900 * time.Millisecond},
+		{PID: 2, State: "Running", Script: "/app/index.php", RequestURI: "/a?x=1", RequestDuration: 2100 * time.Millisecond},
+		{PID: 3, State: "Running", Script: "/app/other.php", RequestURI: "/a", RequestDuration: 2 * time.Second},
+		{PID: 4, State: "Idle", Script: "/app/index.php", RequestURI: "/a", RequestDuration: 2 * time.Second},
+	}
+	params := map[string]string{"SCRIPT_FILENAME": "/app/index.php", "REQUEST_URI": "/a?x=1"}
+
+	// The closest request duration wins among matching running workers
+	if w := findWorker(processes, params, 2*time.Second); w == nil || w.PID != 2 {
+		t.Errorf("Expected worker 2, got %+v", w)
+	}
+	if w := findWorker(processes, params, time.Second); w == nil || w.PID != 1 {
+		t.Errorf("Expected worker 1, got %+v", w)
+	}
+	params["SCRIPT_FILENAME"] = "/app/missing.php"
+	if w := findWorker(processes, params, time.Second); w != nil {
+		t.Errorf("Expected no worker, got %+v", w)
+	}
+}
@@ -0,0 +1,146 @@
+package fcgx
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gophpeek/fcgx/internal/fcgitest"
+	"github.com/gophpeek/fcgx/protocol"
+)
+
+// streamResponse sends the response headers, then n body chunks with gap
+// between them.
+func streamResponse(delay time.Duration, n int, gap time.Duration) fcgitest.Handler {
+	return func(w io.Writer, req *fcgitest.Request) {
+		time.Sleep(delay)
+		fcgitest.WriteRecord(w, protocol.TypeStdout, req.ID, []byte("Content-Type: text/plain\r\n\r\n"))
+		for i := 0; i < n; i++ {
+			time.Sleep(gap)
+			fcgitest.WriteRecord(w, protocol.TypeStdout, req.ID, []byte("chunk\n"))
+		}
+		fcgitest.WriteEndRequest(w, req.ID, 0, protocol.StatusRequestComplete)
+	}
+}
+
+func timeoutGet(t *testing.T, config *Config, handler fcgitest.Handler) (string, error) {
+	t.Helper()
+	srv := fcgitest.NewServer(t, handler)
+	client, err := DialWithConfig("tcp", srv.Addr(), config)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close()
+
+	// No context deadline, so only the configured timeouts apply
+	resp, err := client.Get(context.Background(), testParams())
+	if err != nil {
+		return "", err
+	}
+	body, err := ReadBody(resp)
+	return string(body), err
+}
+
+func TestPhaseTimeouts(t *testing.T) {
+	t.Run("RequestTimeout", func(t *testing.T) {
+		config := DefaultConfig()
+		config.RequestTimeout = 50 * time.Millisecond
+		_, err := timeoutGet(t, config, streamResponse(200*time.Millisecond, 1, 0))
+		if !errors.Is(err, ErrTimeout) || !strings.Contains(err.Error(), "request timeout 50ms exceeded") {
+			t.Fatalf("Expected request timeout, got %v", err)
+		}
+	})
+
+	t.Run("FirstByteTimeout", func(t *testing.T) {
+		config := DefaultConfig()
+		config.FirstByteTimeout = 50 * time.Millisecond
+		_, err := timeoutGet(t, config, streamResponse(200*time.Millisecond, 1, 0))
+		var fe *Error
+		if !errors.As(err, &fe) || fe.Kind != ErrTimeout || fe.Phase != PhaseResponse {
+			t.Fatalf("Expected timeout in response phase, got %v", err)
+		}
+		if !strings.HasPrefix(fe.Op, "first byte timeout 50ms exceeded") {
+			t.Errorf("Expected first byte timeout, got %q", fe.Op)
+		}
+	})
+
+	t.Run("FirstByteTimeoutOnlyUntilFirstByte", func(t *testing.T) {
+		config := DefaultConfig()
+		config.FirstByteTimeout = 50 * time.Millisecond
+		body, err := timeoutGet(t, config, streamResponse(0, 2, 80*time.Millisecond))
+		if err != nil || body != "chunk\nchunk\n" {
+			t.Fatalf("Expected slow body after a fast first byte to succeed, got %q, %v", body, err)
+		}
+	})
+
+	t.Run("ReadIdleTimeout", func(t *testing.T) {
+		// The stream outlasts the idle timeout several times over but never stalls
+		config := DefaultConfig()
+		config.RequestTimeout = 0
+		config.ReadIdleTimeout = 100 * time.Millisecond
+		body, err := timeoutGet(t, config, streamResponse(0, 8, 30*time.Millisecond))
+		if err != nil || strings.Count(body, "chunk") != 8 {
+			t.Fatalf("Expected streaming response to succeed, got %q, %v", body, err)
+		}
+
+		_, err = timeoutGet(t, config, streamResponse(0, 1, 250*time.Millisecond))
+		if !errors.Is(err, ErrTimeout) || !strings.Contains(err.Error(), "read idle timeout 100ms exceeded") {
+			t.Fatalf("Expected read idle timeout, got %v", err)
+		}
+	})
+
+	t.Run("CappedByContext", func(t *testing.T) {
+		srv := fcgitest.NewServer(t, streamResponse(200*time.Millisecond, 1, 0))
+		config := DefaultConfig()
+		config.FirstByteTimeout = time.Minute
+		client, err := DialWithConfig("tcp", srv.Addr(), config)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		defer client.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err = client.Get(ctx, testParams())
+		if !errors.Is(err, ErrTimeout) || !strings.Contains(err.Error(), "context deadline exceeded") {
+			t.Fatalf("Expected context deadline to win, got %v", err)
+		}
+	})
+}
+
+func TestTimeoutDiscardsConn(t *testing.T) {
+	srv := fcgitest.NewServer(t, streamResponse(200*time.Millisecond, 1, 0))
+	config := DefaultConfig()
+	config.KeepConn = true
+	config.FirstByteTimeout = 50 * time.Millisecond
+	config.CircuitBreakers = NewCircuitBreakers(testBreakerConfig())
+	client, err := DialWithConfig("tcp", srv.Addr(), config)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close()
+
+	if _, err := client.Get(context.Background(), testParams()); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Expected ErrTimeout, got %v", err)
+	}
+
+	// Later requests report the discarded connection, not a timeout of their own
+	params := testParams()
+	params["SCRIPT_NAME"] = "/b.php"
+	_, err = client.Get(context.Background(), params)
+	var fe *Error
+	if !errors.As(err, &fe) || fe.Kind != ErrConnClosedByServer || fe.Script != "/b.php" {
+		t.Fatalf("Expected ErrConnClosedByServer for /b.php, got %v", err)
+	}
+	var cause *Error
+	if !errors.As(fe.Err, &cause) || cause.Kind != ErrTimeout {
+		t.Errorf("Expected the timeout as the cause, got %v", fe.Err)
+	}
+	stats := config.CircuitBreakers.Stats()
+	if len(stats) != 1 || stats[0].Requests != 1 || stats[0].Failures != 1 {
+		t.Errorf("Expected only the timeout to count against the breaker, got %+v", stats)
+	}
+}
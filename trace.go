@@ -0,0 +1,153 @@
+package fcgx
+
+import (
+	"context"
+	"net"
+	"reflect"
+
+	"github.com/gophpeek/fcgx/protocol"
+)
+
+// Trace is a set of hooks run at stages of a FastCGI request, in the spirit
+// of net/http/httptrace. Attach it to a request's context with WithTrace.
+// Any hook may be nil. Hooks are called synchronously from the goroutine
+// making the request and must not block.
+type Trace struct {
+	// ConnectStart is called before dialing, including redials and retried
+	// dials.
+	ConnectStart func(network, address string)
+
+	// ConnectDone is called when a dial completes. err is nil on success.
+	ConnectDone func(network, address string, err error)
+
+	// GotConn is called when the request has a connection to send on.
+	GotConn func(GotConnInfo)
+
+	// WroteParams is called after all FCGI_PARAMS records have been written.
+	WroteParams func()
+
+	// WroteStdin is called after the request body and the closing empty
+	// FCGI_STDIN record have been written. n is the number of body bytes.
+	WroteStdin func(n int64)
+
+	// FirstResponseByte is called when the first FCGI_STDOUT content arrives.
+	FirstResponseByte func()
+
+	// StderrChunk is called with the content of each FCGI_STDERR record.
+	// p is only valid for the duration of the call. Stderr output is never
+	// part of the response, so this and Config.Logger are the only ways to
+	// see it.
+	StderrChunk func(p []byte)
+
+	// EndRequest is called when FCGI_END_REQUEST is received.
+	EndRequest func(EndRequestInfo)
+}
+
+// GotConnInfo describes the connection a request is sent on.
+type GotConnInfo struct {
+	Conn   net.Conn
+	Reused bool // Whether the connection already carried an earlier request
+}
+
+// EndRequestInfo describes a completed FastCGI request.
+type EndRequestInfo struct {
+	AppStatus      uint32                  // Exit status reported by the application
+	ProtocolStatus protocol.ProtocolStatus // Whether the server completed or rejected the request
+	StdoutBytes    int64                   // FCGI_STDOUT content received
+	StderrBytes    int64                   // FCGI_STDERR content received
+}
+
+type traceKey struct{}
+
+// WithTrace returns a context based on ctx that runs the hooks in trace.
+// Hooks already attached to ctx are still called, after the new ones.
+func WithTrace(ctx context.Context, trace *Trace) context.Context {
+	if trace == nil {
+		panic("fcgx: nil trace")
+	}
+	old := ContextTrace(ctx)
+	trace = trace.compose(old)
+	return context.WithValue(ctx, traceKey{}, trace)
+}
+
+// ContextTrace returns the Trace attached to ctx, or nil.
+func ContextTrace(ctx context.Context) *Trace {
+	trace, _ := ctx.Value(traceKey{}).(*Trace)
+	return trace
+}
+
+// compose returns a copy of t whose hooks also call those of old.
+func (t *Trace) compose(old *Trace) *Trace {
+	nt := *t
+	if old == nil {
+		return &nt
+	}
+	tv := reflect.ValueOf(&nt).Elem()
+	ov := reflect.ValueOf(old).Elem()
+	for i := 0; i < tv.NumField(); i++ {
+		tf, of := tv.Field(i), ov.Field(i)
+		if of.IsNil() {
+			continue
+		}
+		if tf.IsNil() {
+			tf.Set(of)
+			continue
+		}
+		first, second := tf.Interface(), of.Interface()
+		tf.Set(reflect.MakeFunc(tf.Type(), func(args []reflect.Value) []reflect.Value {
+			reflect.ValueOf(first).Call(args)
+			return reflect.ValueOf(second).Call(args)
+		}))
+	}
+	return &nt
+}
+
+// The helpers below are nil-safe so call sites need no checks.
+
+func (t *Trace) connectStart(network, address string) {
+	if t != nil && t.ConnectStart != nil {
+		t.ConnectStart(network, address)
+	}
+}
+
+func (t *Trace) connectDone(network, address string, err error) {
+	if t != nil && t.ConnectDone != nil {
+		t.ConnectDone(network, address, err)
+	}
+}
+
+func (t *Trace) gotConn(info GotConnInfo) {
+	if t != nil && t.GotConn != nil {
+		t.GotConn(info)
+	}
+}
+
+func (t *Trace) wroteParams() {
+	if t != nil && t.WroteParams != nil {
+		t.WroteParams()
+	}
+}
+
+func (t *Trace) wroteStdin(n int64) {
+	if t != nil && t.WroteStdin != nil {
+		t.WroteStdin(n)
+	}
+}
+
+func (t *Trace) firstResponseByte() {
+	if t != nil && t.FirstResponseByte != nil {
+		t.FirstResponseByte()
+	}
+}
+
+func (t *Trace) stderrChunk(p []byte) {
+	if t != nil && t.StderrChunk != nil {
+		t.StderrChunk(p)
+	}
+}
+
+func (t *Trace) endRequest(info EndRequestInfo) {
+	if t != nil && t.EndRequest != nil {
+		t.EndRequest(info)
+	}
+}
@@ -0,0 +1,90 @@
+package fcgx
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gophpeek/fcgx/internal/fcgitest"
+	"github.com/gophpeek/fcgx/protocol"
+)
+
+func TestTrace(t *testing.T) {
+	srv := fcgitest.NewServer(t, func(w io.Writer, req *fcgitest.Request) {
+		fcgitest.WriteRecord(w, protocol.TypeStderr, req.ID, []byte("PHP Notice: x"))
+		fcgitest.WriteRecord(w, protocol.TypeStdout, req.ID, []byte("Content-Type: text/plain\r\n\r\nok"))
+		fcgitest.WriteEndRequest(w, req.ID, 7, protocol.StatusRequestComplete)
+	})
+
+	var events []string
+	trace := &Trace{
+		ConnectStart: func(network, address string) { events = append(events, "ConnectStart") },
+		ConnectDone: func(network, address string, err error) {
+			events = append(events, fmt.Sprintf("ConnectDone(%v)", err))
+		},
+		GotConn: func(info GotConnInfo) {
+			events = append(events, fmt.Sprintf("GotConn(reused=%v)", info.Reused))
+		},
+		WroteParams:       func() { events = append(events, "WroteParams") },
+		WroteStdin:        func(n int64) { events = append(events, fmt.Sprintf("WroteStdin(%d)", n)) },
+		FirstResponseByte: func() { events = append(events, "FirstResponseByte") },
+		StderrChunk:       func(p []byte) { events = append(events, fmt.Sprintf("Stderr(%s)", p)) },
+		EndRequest: func(info EndRequestInfo) {
+			events = append(events, fmt.Sprintf("EndRequest(%d, %s, %d, %d)",
+				info.AppStatus, info.ProtocolStatus, info.StdoutBytes, info.StderrBytes))
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	ctx = WithTrace(ctx, trace)
+
+	config := DefaultConfig()
+	config.KeepConn = true
+	client, err := DialContextWithConfig(ctx, "tcp", srv.Addr(), config)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close()
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Post(ctx, testParams(), strings.NewReader("a=1"), 3)
+		if err != nil {
+			t.Fatalf("Request %d failed: %v", i, err)
+		}
+		ReadBody(resp)
+	}
+
+	request := []string{"WroteParams", "WroteStdin(3)", "Stderr(PHP Notice: x)", "FirstResponseByte", "EndRequest(7, FCGI_REQUEST_COMPLETE, 30, 13)"}
+	want := []string{"ConnectStart", "ConnectDone(<nil>)", "GotConn(reused=false)"}
+	want = append(want, request...)
+	want = append(want, "GotConn(reused=true)")
+	want = append(want, request...)
+	if got := strings.Join(events, "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("Unexpected events:\n%s\nwant:\n%s", got, strings.Join(want, "\n"))
+	}
+}
+
+func TestWithTraceComposes(t *testing.T) {
+	var calls []string
+	ctx := WithTrace(context.Background(), &Trace{
+		WroteParams: func() { calls = append(calls, "outer") },
+		WroteStdin:  func(int64) { calls = append(calls, "outer-only") },
+	})
+	ctx = WithTrace(ctx, &Trace{
+		WroteParams: func() { calls = append(calls, "inner") },
+	})
+
+	trace := ContextTrace(ctx)
+	trace.wroteParams()
+	trace.wroteStdin(0)
+	trace.firstResponseByte()
+	if got := strings.Join(calls, ","); got != "inner,outer,outer-only" {
+		t.Errorf("Expected hooks to compose, got %s", got)
+	}
+	if ContextTrace(context.Background()) != nil {
+		t.Error("Expected no trace on a plain context")
+	}
+}